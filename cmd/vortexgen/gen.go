@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// primitives maps the basic types supported in a schema to the method of proto.IO they are marshaled with.
+var primitives = map[string]string{
+	"bool":    "Bool",
+	"uint8":   "Uint8",
+	"byte":    "Uint8",
+	"int8":    "Int8",
+	"uint16":  "Uint16",
+	"int16":   "Int16",
+	"uint32":  "Uint32",
+	"int32":   "Int32",
+	"uint64":  "Uint64",
+	"int64":   "Int64",
+	"float32": "Float32",
+	"float64": "Float64",
+	"string":  "String",
+}
+
+// varints maps the integer types that may be marshaled as varints to the method of proto.IO they are then
+// marshaled with.
+var varints = map[string]string{
+	"uint16": "Varuint16",
+	"int16":  "Varint16",
+	"uint32": "Varuint32",
+	"int32":  "Varint32",
+	"uint64": "Varuint64",
+	"int64":  "Varint64",
+}
+
+// selectors maps the types of other packages supported in a schema to the method of proto.IO they are
+// marshaled with.
+var selectors = map[string]string{
+	"color.RGBA":    "RGBA",
+	"time.Time":     "Time",
+	"time.Duration": "Duration",
+	"big.Int":       "BigInt",
+	"uuid.UUID":     "BEUUID",
+}
+
+// sliceHelpers maps length prefixes to the generic helpers of the proto package that marshal slices of
+// Marshalers and of primitives with that prefix. An empty helper is not available.
+var sliceHelpers = map[string][2]string{
+	"varuint32": {"Slice", "FuncSlice"},
+	"uint8":     {"SliceUint8Length", ""},
+	"uint16":    {"SliceUint16Length", "FuncSliceUint16Length"},
+	"uint32":    {"SliceUint32Length", "FuncSliceUint32Length"},
+	"varint32":  {"SliceVarint32Length", ""},
+}
+
+// lengthTypes maps length prefixes to the type of the length and the method of proto.IO it is marshaled
+// with.
+var lengthTypes = map[string][2]string{
+	"varuint32": {"uint32", "Varuint32"},
+	"uint8":     {"uint8", "Uint8"},
+	"uint16":    {"uint16", "Uint16"},
+	"uint32":    {"uint32", "Uint32"},
+	"varint32":  {"int32", "Varint32"},
+}
+
+// generator generates the Go source of a schema.
+type generator struct {
+	s   *schema
+	buf bytes.Buffer
+	// imports holds the names of the packages referred to by the generated code.
+	imports map[string]bool
+}
+
+// generate generates the Go source of the schema passed. The source is formatted with gofmt.
+func generate(s *schema, pkg string) ([]byte, error) {
+	if pkg == "" {
+		pkg = s.pkg
+	}
+	g := &generator{s: s, imports: map[string]bool{"proto": true}}
+
+	body := &g.buf
+	for _, decl := range s.file.Decls {
+		if gen := decl.(*ast.GenDecl); gen.Tok != token.IMPORT {
+			body.WriteString(s.source(gen, gen.Doc) + "\n\n")
+		}
+	}
+	g.ids()
+	for _, t := range s.types {
+		if err := g.methods(t); err != nil {
+			return nil, fmt.Errorf("type %v: %w", t.name, err)
+		}
+	}
+	for _, decl := range s.file.Decls {
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					g.imports[x.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	out := new(bytes.Buffer)
+	_, _ = fmt.Fprintf(out, "// Code generated by vortexgen. DO NOT EDIT.\n\npackage %v\n\n", pkg)
+	out.WriteString(g.importDecl())
+	out.Write(body.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+// importDecl returns the import declaration of the generated file, holding the imports of the schema that
+// are used and the proto package.
+func (g *generator) importDecl() string {
+	// Imports of the standard library are grouped before other imports, like goimports does.
+	var std, other []string
+	if g.imports["proto"] {
+		other = append(other, strconv.Quote("github.com/vortex-service/vortex/vortex/proto"))
+	}
+	for _, spec := range g.s.file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if !g.imports[name] || name == "proto" {
+			continue
+		}
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, g.s.source(spec, nil))
+		} else {
+			std = append(std, g.s.source(spec, nil))
+		}
+	}
+	imports := strings.Join(std, "\n")
+	if len(std) > 0 && len(other) > 0 {
+		imports += "\n\n"
+	}
+	return "import (\n" + imports + strings.Join(other, "\n") + "\n)\n\n"
+}
+
+// ids writes the constants holding the IDs of the packets in the schema.
+func (g *generator) ids() {
+	packets := false
+	for _, t := range g.s.types {
+		packets = packets || t.packet
+	}
+	if !packets {
+		return
+	}
+	g.buf.WriteString("const (\n")
+	for _, t := range g.s.types {
+		if t.packet {
+			_, _ = fmt.Fprintf(&g.buf, "\tID%v uint32 = %v\n", t.name, t.id)
+		}
+	}
+	g.buf.WriteString(")\n\n")
+}
+
+// methods writes the ID method of a packet and the Marshal method of a struct type.
+func (g *generator) methods(t *typeDecl) error {
+	if _, ok := t.spec.Type.(*ast.StructType); !ok {
+		return nil
+	}
+	recv := string(unicode.ToLower([]rune(t.name)[0]))
+	if recv == "i" {
+		// The parameter of Marshal is named io.
+		recv = "x"
+	}
+	if t.packet {
+		_, _ = fmt.Fprintf(&g.buf, "// ID ...\nfunc (%v *%v) ID() uint32 {\n\treturn ID%v\n}\n\n", recv, t.name, t.name)
+	}
+	_, _ = fmt.Fprintf(&g.buf, "// Marshal ...\nfunc (%v *%v) Marshal(io proto.IO) {\n", recv, t.name)
+	for _, f := range t.fields {
+		stmt, err := g.marshal("&"+recv+"."+f.name, f.typ, f.opts, 0)
+		if err != nil {
+			return fmt.Errorf("field %v: %w", f.name, err)
+		}
+		g.buf.WriteString(stmt + "\n")
+	}
+	g.buf.WriteString("}\n\n")
+	return nil
+}
+
+// marshal returns the statements that marshal the value that the expression ptr points to, which is of the
+// type passed. depth is the number of loops the statements are nested in.
+func (g *generator) marshal(ptr string, typ ast.Expr, opts proto.TagOptions, depth int) (string, error) {
+	if opts.Optional {
+		if _, ok := typ.(*ast.StarExpr); !ok {
+			return "", fmt.Errorf("optional is only supported for pointers, not %v", types.ExprString(typ))
+		}
+	}
+	if _, ok := typ.(*ast.ArrayType); !ok && opts.Length != "" {
+		return "", fmt.Errorf("len is only supported for slices, not %v", types.ExprString(typ))
+	}
+	if _, ok := typ.(*ast.MapType); !ok && opts.Sorted {
+		return "", fmt.Errorf("sorted is only supported for maps, not %v", types.ExprString(typ))
+	}
+
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if decl, ok := g.s.byName[t.Name]; ok {
+			if basic, ok := decl.spec.Type.(*ast.Ident); ok {
+				// A type with a basic underlying type, such as an enum, is marshaled as its underlying type.
+				if method, ok := g.primitive(basic, opts.Varint); ok {
+					return fmt.Sprintf("io.%v((*%v)(%v))", method, basic.Name, ptr), nil
+				}
+			}
+		}
+		if method, ok := g.primitive(t, opts.Varint); ok {
+			return fmt.Sprintf("io.%v(%v)", method, ptr), nil
+		} else if opts.Varint {
+			return "", fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t.Name)
+		}
+		return value(ptr) + ".Marshal(io)", nil
+	case *ast.SelectorExpr:
+		method, ok := selectors[types.ExprString(t)]
+		if ok && method == "Duration" {
+			// Durations are always marshaled as varints, so the varint option does not change them.
+			return fmt.Sprintf("io.Duration(%v)", ptr), nil
+		}
+		if opts.Varint {
+			return "", fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", types.ExprString(t))
+		}
+		if ok {
+			return fmt.Sprintf("io.%v(%v)", method, ptr), nil
+		}
+		return value(ptr) + ".Marshal(io)", nil
+	case *ast.StarExpr:
+		if !opts.Optional {
+			return "", fmt.Errorf("pointer %v requires the optional tag option", types.ExprString(t))
+		}
+		elem, err := g.marshal(deref(ptr), t.X, proto.TagOptions{Varint: opts.Varint}, depth)
+		if err != nil {
+			return "", err
+		}
+		v := deref(ptr)
+		return fmt.Sprintf("{\nset := %v != nil\nio.Bool(&set)\nif set {\nif %v == nil {\n%v = new(%v)\n}\n%v\n}\n}", v, v, v, types.ExprString(t.X), elem), nil
+	case *ast.ArrayType:
+		if t.Len != nil {
+			i := string(rune('i' + depth))
+			elem, err := g.marshal("&"+deref(ptr)+"["+i+"]", t.Elt, proto.TagOptions{Varint: opts.Varint}, depth+1)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("for %v := range %v {\n%v\n}", i, deref(ptr), elem), nil
+		}
+		return g.slice(ptr, t, opts, depth)
+	case *ast.MapType:
+		return g.mapOf(ptr, t, opts, depth)
+	case *ast.IndexExpr:
+		if types.ExprString(t.X) != "proto.Optional" {
+			return "", fmt.Errorf("unsupported type %v", types.ExprString(t))
+		}
+		if method, ok := g.method(t.Index, opts.Varint); ok {
+			return fmt.Sprintf("proto.OptionalFunc(io, %v, io.%v)", ptr, method), nil
+		}
+		if g.marshaler(t.Index) {
+			return fmt.Sprintf("proto.OptionalMarshaler(io, %v)", ptr), nil
+		}
+		elem, err := g.marshal("v", t.Index, proto.TagOptions{Varint: opts.Varint}, depth)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("proto.OptionalFunc(io, %v, func(v *%v) {\n%v\n})", ptr, types.ExprString(t.Index), elem), nil
+	}
+	return "", fmt.Errorf("unsupported type %v", types.ExprString(typ))
+}
+
+// slice returns the statements that marshal a slice, using the generic helpers of the proto package where
+// possible.
+func (g *generator) slice(ptr string, t *ast.ArrayType, opts proto.TagOptions, depth int) (string, error) {
+	length := opts.Length
+	if length == "" {
+		length = "varuint32"
+	}
+	if elem, ok := t.Elt.(*ast.Ident); ok && (elem.Name == "byte" || elem.Name == "uint8") && length == "varuint32" {
+		return fmt.Sprintf("io.ByteSlice(%v)", ptr), nil
+	}
+	helpers := sliceHelpers[length]
+	if g.marshaler(t.Elt) && !opts.Varint {
+		return fmt.Sprintf("proto.%v(io, %v)", helpers[0], ptr), nil
+	}
+	if method, ok := g.method(t.Elt, opts.Varint); ok && helpers[1] != "" {
+		return fmt.Sprintf("proto.%v(io, %v, io.%v)", helpers[1], ptr, method), nil
+	}
+
+	// Other slices have their length prefix written explicitly and every element marshaled in a closure.
+	elem, err := g.marshal("v", t.Elt, proto.TagOptions{Varint: opts.Varint}, depth)
+	if err != nil {
+		return "", err
+	}
+	typ, method := lengthTypes[length][0], lengthTypes[length][1]
+	prefix, l := fmt.Sprintf("l := %v(len(%v))\nio.%v(&l)\n", typ, deref(ptr), method), "l"
+	if typ != "uint32" {
+		l = "uint32(l)"
+	}
+	return fmt.Sprintf("{\n%vproto.FuncSliceOfLen(io, %v, %v, func(v *%v) {\n%v\n})\n}", prefix, l, ptr, types.ExprString(t.Elt), elem), nil
+}
+
+// mapOf returns the statements that marshal a map using proto.Map, or proto.SortedMap if the sorted option
+// is set.
+func (g *generator) mapOf(ptr string, t *ast.MapType, opts proto.TagOptions, depth int) (string, error) {
+	key, err := g.function(t.Key, proto.TagOptions{}, depth)
+	if err != nil {
+		return "", err
+	}
+	val, err := g.function(t.Value, proto.TagOptions{Varint: opts.Varint}, depth)
+	if err != nil {
+		return "", err
+	}
+	helper := "Map"
+	if opts.Sorted {
+		helper = "SortedMap"
+	}
+	return fmt.Sprintf("proto.%v(io, %v, %v, %v)", helper, ptr, key, val), nil
+}
+
+// function returns an expression of a function that marshals the value that its pointer argument points to,
+// which is of the type passed.
+func (g *generator) function(typ ast.Expr, opts proto.TagOptions, depth int) (string, error) {
+	if method, ok := g.method(typ, opts.Varint); ok {
+		return "io." + method, nil
+	}
+	stmt, err := g.marshal("v", typ, opts, depth)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("func(v *%v) {\n%v\n}", types.ExprString(typ), stmt), nil
+}
+
+// method returns the method of proto.IO that a value of the type passed is marshaled with, if it is a basic
+// type or a type of another package with a method of its own.
+func (g *generator) method(typ ast.Expr, varint bool) (string, bool) {
+	if method, ok := g.primitive(typ, varint); ok {
+		return method, true
+	}
+	sel, ok := typ.(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+	method, ok := selectors[types.ExprString(sel)]
+	if varint && method != "Duration" {
+		return "", false
+	}
+	return method, ok
+}
+
+// primitive returns the method of proto.IO that a value of the type passed is marshaled with, if it is a
+// basic type.
+func (g *generator) primitive(typ ast.Expr, varint bool) (string, bool) {
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	if _, declared := g.s.byName[ident.Name]; declared {
+		return "", false
+	}
+	if varint {
+		method, ok := varints[ident.Name]
+		return method, ok
+	}
+	method, ok := primitives[ident.Name]
+	return method, ok
+}
+
+// marshaler checks if values of the type passed are marshaled with their Marshal method.
+func (g *generator) marshaler(typ ast.Expr) bool {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if _, ok := primitives[t.Name]; ok {
+			return false
+		}
+		if decl, ok := g.s.byName[t.Name]; ok {
+			_, ok := decl.spec.Type.(*ast.StructType)
+			return ok
+		}
+		return true
+	case *ast.SelectorExpr:
+		_, ok := selectors[types.ExprString(t)]
+		return !ok
+	}
+	return false
+}
+
+// deref returns an expression of the value that the pointer expression passed points to.
+func deref(ptr string) string {
+	if v, ok := strings.CutPrefix(ptr, "&"); ok {
+		return v
+	}
+	return "(*" + ptr + ")"
+}
+
+// value returns an expression that methods with a pointer receiver may be called on for the pointer
+// expression passed.
+func value(ptr string) string {
+	if v, ok := strings.CutPrefix(ptr, "&"); ok {
+		return v
+	}
+	return ptr
+}
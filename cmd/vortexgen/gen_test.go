@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGenerate checks that the ID constants and the ID and Marshal methods generated for a schema read and
+// write every field with the method of its type and tag options.
+func TestGenerate(t *testing.T) {
+	// Struct tags are quoted with ' in src, as it is a raw string itself.
+	src := `package packets
+
+import "image/color"
+
+// Entity is a thing in the world.
+type Entity struct {
+	Name     string
+	Colour   color.RGBA
+	Position int32 'vortex:"varint"'
+	Children []Entity
+	Scores   []int32 'vortex:"len=uint16"'
+	Parent   *Entity 'vortex:"optional"'
+}
+
+// Spawn spawns an entity.
+//
+//vortex:packet
+type Spawn struct {
+	Entity Entity
+}
+
+//vortex:packet 10
+type Despawn struct {
+	Name string
+}
+
+//vortex:packet
+type Ping struct{}
+`
+	s, err := parseSchema("packets.vortex", []byte(strings.ReplaceAll(src, "'", "`")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := generate(s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// Code generated by vortexgen. DO NOT EDIT.\n\npackage packets\n",
+		"import (\n\t\"image/color\"\n\n\t\"github.com/vortex-service/vortex/vortex/proto\"\n)",
+		"// Spawn spawns an entity.\n//\n//vortex:packet\ntype Spawn struct {",
+		"IDSpawn   uint32 = 3\n\tIDDespawn uint32 = 10\n\tIDPing    uint32 = 11\n",
+		"func (s *Spawn) ID() uint32 {\n\treturn IDSpawn\n}",
+		"func (s *Spawn) Marshal(io proto.IO) {\n\ts.Entity.Marshal(io)\n}",
+		"func (e *Entity) Marshal(io proto.IO) {\n" +
+			"\tio.String(&e.Name)\n" +
+			"\tio.RGBA(&e.Colour)\n" +
+			"\tio.Varint32(&e.Position)\n" +
+			"\tproto.Slice(io, &e.Children)\n" +
+			"\tproto.FuncSliceUint16Length(io, &e.Scores, io.Int32)\n",
+		"\t\tio.Bool(&set)\n\t\tif set {\n\t\t\tif e.Parent == nil {\n\t\t\t\te.Parent = new(Entity)\n\t\t\t}\n\t\t\te.Parent.Marshal(io)\n",
+		"func (p *Ping) Marshal(io proto.IO) {\n}",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain\n%v\n\ngenerated:\n%s", want, code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Command vortexgen generates packets from a schema file, so that packet definitions can be kept in one
+// place and shared by every service that uses them.
+//
+// The schema file holds the packets and the types they are composed of as Go type declarations. See the
+// documentation of schema for its format. vortexgen writes a Go file holding the declarations of the
+// schema, a constant with the ID of every packet, and the ID and Marshal methods of the packets and types.
+//
+// Usage:
+//
+//	vortexgen [-o output] [-package name] schema
+//
+// vortexgen is commonly run using go:generate:
+//
+//	//go:generate go run github.com/vortex-service/vortex/cmd/vortexgen -o packets_gen.go packets.vortex
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	output := flag.String("o", "", "file to write the generated code to, defaults to the schema file with a _gen.go suffix")
+	pkg := flag.String("package", "", "package name of the generated code, defaults to the package of the schema")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: vortexgen [-o output] [-package name] schema\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *output, *pkg); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "vortexgen:", err)
+		os.Exit(1)
+	}
+}
+
+// run generates the code of the schema file passed and writes it to output.
+func run(path, output, pkg string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s, err := parseSchema(path, src)
+	if err != nil {
+		return err
+	}
+	code, err := generate(s, pkg)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = strings.TrimSuffix(path, filepath.Ext(path)) + "_gen.go"
+	}
+	return os.WriteFile(output, code, 0644)
+}
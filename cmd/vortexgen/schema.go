@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// schema is a parsed schema file. A schema file is written in Go syntax and holds type declarations of
+// packets and the types they are composed of. Struct types marked with a //vortex:packet directive in their
+// doc comment are packets, which are optionally followed by their ID:
+//
+//	// ChatMessage is sent when a player writes a message in the chat.
+//	//
+//	//vortex:packet 10
+//	type ChatMessage struct {
+//		Message string
+//	}
+//
+// Packets without an explicit ID have the ID of the packet declared before them plus one, starting at
+// firstID. IDs must fit in a byte, may not be used by more than one packet, and may not be those reserved
+// for the packets of the protocol. Fields use the same vortex struct tags as proto.MarshalStruct.
+type schema struct {
+	fset *token.FileSet
+	src  []byte
+	file *ast.File
+
+	pkg   string
+	types []*typeDecl
+	// byName holds every type declared in the schema by its name.
+	byName map[string]*typeDecl
+}
+
+// typeDecl is a type declared in a schema.
+type typeDecl struct {
+	name string
+	// decl is the declaration the type was declared in, used to copy it to the generated file.
+	decl *ast.GenDecl
+	spec *ast.TypeSpec
+
+	packet bool
+	id     uint32
+	fields []*field
+}
+
+// field is a field of a struct type that is marshaled.
+type field struct {
+	name string
+	typ  ast.Expr
+	opts proto.TagOptions
+}
+
+// firstID is the ID of the first packet without an explicit ID. It follows the IDs of the packets built
+// into package packet, such as packet.Login.
+const firstID = packet.IDHeartbeat + 1
+
+// parseSchema parses the schema file with the name and contents passed.
+func parseSchema(name string, src []byte) (*schema, error) {
+	s := &schema{fset: token.NewFileSet(), src: src, byName: make(map[string]*typeDecl)}
+	f, err := parser.ParseFile(s.fset, name, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	s.file, s.pkg = f, f.Name.Name
+
+	nextID := firstID
+	packets := make(map[uint32]string)
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			return nil, fmt.Errorf("%v: only declarations of types and constants are allowed", s.fset.Position(decl.Pos()))
+		}
+		if gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			t := &typeDecl{name: spec.(*ast.TypeSpec).Name.Name, decl: gen, spec: spec.(*ast.TypeSpec)}
+			doc := t.spec.Doc
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+			if id, ok, err := packetDirective(doc); err != nil {
+				return nil, fmt.Errorf("%v: type %v: %w", s.fset.Position(spec.Pos()), t.name, err)
+			} else if ok {
+				if id < 0 {
+					id = int64(nextID)
+				}
+				if err := checkID(uint32(id)); err != nil {
+					return nil, fmt.Errorf("%v: type %v: %w", s.fset.Position(spec.Pos()), t.name, err)
+				}
+				if other, ok := packets[uint32(id)]; ok {
+					return nil, fmt.Errorf("%v: type %v: packet ID %v is already used by %v", s.fset.Position(spec.Pos()), t.name, id, other)
+				}
+				t.packet, t.id, nextID = true, uint32(id), uint32(id)+1
+				packets[t.id] = t.name
+			}
+			if err := s.parseFields(t); err != nil {
+				return nil, fmt.Errorf("%v: type %v: %w", s.fset.Position(spec.Pos()), t.name, err)
+			}
+			s.types = append(s.types, t)
+			s.byName[t.name] = t
+		}
+	}
+	return s, nil
+}
+
+// packetDirective finds the //vortex:packet directive in the doc comment passed. The ID is -1 if the
+// directive does not hold an ID.
+func packetDirective(doc *ast.CommentGroup) (id int64, ok bool, err error) {
+	if doc == nil {
+		return 0, false, nil
+	}
+	for _, c := range doc.List {
+		directive, found := strings.CutPrefix(c.Text, "//vortex:packet")
+		if !found {
+			continue
+		}
+		if directive = strings.TrimSpace(directive); directive == "" {
+			return -1, true, nil
+		}
+		id, err := strconv.ParseUint(directive, 10, 8)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid packet ID %q: IDs are numbers from %v to %v", directive, firstID, packet.IDBuiltIn-1)
+		}
+		return int64(id), true, nil
+	}
+	return 0, false, nil
+}
+
+// checkID checks if a packet of the schema may have the ID passed. IDs below firstID and from
+// packet.IDBuiltIn up to IDReserved are reserved for the protocol, and IDs above it do not fit in the byte
+// that IDs are encoded as.
+func checkID(id uint32) error {
+	if id < firstID || id >= packet.IDBuiltIn {
+		return fmt.Errorf("packet ID %v is reserved for the protocol or does not fit in a byte", id)
+	}
+	return nil
+}
+
+// parseFields parses the fields of a struct type. Types other than structs have no fields.
+func (s *schema) parseFields(t *typeDecl) error {
+	st, ok := t.spec.Type.(*ast.StructType)
+	if !ok {
+		if t.packet {
+			return fmt.Errorf("packets must be structs")
+		}
+		return nil
+	}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return fmt.Errorf("embedded fields are not supported")
+		}
+		var opts proto.TagOptions
+		if f.Tag != nil {
+			tag, _ := strconv.Unquote(f.Tag.Value)
+			v, _ := reflect.StructTag(tag).Lookup("vortex")
+			if v == "-" {
+				continue
+			}
+			var err error
+			if opts, err = proto.ParseTag(v); err != nil {
+				return fmt.Errorf("field %v: %w", f.Names[0].Name, err)
+			}
+		}
+		for _, name := range f.Names {
+			if name.IsExported() {
+				t.fields = append(t.fields, &field{name: name.Name, typ: f.Type, opts: opts})
+			}
+		}
+	}
+	return nil
+}
+
+// source returns the source of the node passed, including its doc comment if it has one.
+func (s *schema) source(n ast.Node, doc *ast.CommentGroup) string {
+	start := n.Pos()
+	if doc != nil {
+		start = doc.Pos()
+	}
+	return string(s.src[s.fset.Position(start).Offset:s.fset.Position(n.End()).Offset])
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPacketIDs checks that packets without an explicit ID are numbered after the built-in packets and the
+// packet declared before them, and that IDs that are reserved, do not fit in a byte or are used twice are
+// rejected.
+func TestPacketIDs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// directives holds the arguments of the //vortex:packet directives of the packets declared.
+		directives []string
+		ids        []uint32
+		err        string
+	}{
+		{"default", []string{"", ""}, []uint32{firstID, firstID + 1}, ""},
+		{"explicit", []string{"10", "", "20"}, []uint32{10, 11, 20}, ""},
+		{"last", []string{"239"}, []uint32{239}, ""},
+		{"login", []string{"0"}, nil, "reserved"},
+		{"heartbeat", []string{"2"}, nil, "reserved"},
+		{"built-in", []string{"240"}, nil, "reserved"},
+		{"IDReserved", []string{"255"}, nil, "reserved"},
+		{"beyond a byte", []string{"256"}, nil, "invalid packet ID"},
+		{"default beyond the last", []string{"239", ""}, nil, "reserved"},
+		{"not a number", []string{"x"}, nil, "invalid packet ID"},
+		{"duplicate", []string{"10", "10"}, nil, "already used by A"},
+		{"default duplicate", []string{"11", "10", ""}, nil, "already used by A"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := "package packets\n"
+			for i, directive := range tc.directives {
+				src += "\n//vortex:packet " + directive + "\ntype " + string(rune('A'+i)) + " struct{}\n"
+			}
+			s, err := parseSchema("packets.vortex", []byte(src))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, typ := range s.types {
+				if !typ.packet || typ.id != tc.ids[i] {
+					t.Errorf("%v: got packet %v with ID %v, want ID %v", typ.name, typ.packet, typ.id, tc.ids[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParseFields checks that the exported fields of struct types are parsed with their tag options, and
+// that invalid tags are rejected.
+func TestParseFields(t *testing.T) {
+	// Struct tags are quoted with ' in src, as it is a raw string itself.
+	src := `package packets
+
+type Entity struct {
+	Name     string
+	hidden   int32
+	Skipped  int32    'vortex:"-"'
+	X, Y     int32    'vortex:"varint"'
+	Children []Entity 'vortex:"len=uint16"'
+	Parent   *Entity  'vortex:"optional"'
+}
+`
+	s, err := parseSchema("packets.vortex", []byte(strings.ReplaceAll(src, "'", "`")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range s.byName["Entity"].fields {
+		got = append(got, f.name)
+	}
+	if want := "Name X Y Children Parent"; strings.Join(got, " ") != want {
+		t.Errorf("got fields %v, want %v", got, want)
+	}
+	fields := s.byName["Entity"].fields
+	if !fields[1].opts.Varint || !fields[2].opts.Varint || fields[3].opts.Length != "uint16" || !fields[4].opts.Optional {
+		t.Errorf("tag options not parsed: %+v %+v %+v %+v", fields[1].opts, fields[2].opts, fields[3].opts, fields[4].opts)
+	}
+
+	for _, src := range []string{
+		"type A struct {\n\tX int32 `vortex:\"fixed\"`\n}",
+		"type A struct {\n\tX []int32 `vortex:\"len=int64\"`\n}",
+		"type A struct {\n\tEntity\n}",
+		"//vortex:packet\ntype A int32",
+		"func A() {}",
+	} {
+		if _, err := parseSchema("packets.vortex", []byte("package packets\n\n"+src+"\n")); err == nil {
+			t.Errorf("%q: no error", src)
+		}
+	}
+}
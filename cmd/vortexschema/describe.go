@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// protoPath is the import path of the proto package.
+const protoPath = "github.com/vortex-service/vortex/vortex/proto"
+
+// generatedHeader is the comment that the files generated by vortexgen start with.
+const generatedHeader = "// Code generated by vortexgen. DO NOT EDIT."
+
+// describe describes the packets declared in the packages passed. The rules follow those of
+// proto.DescribeStruct, applied to the types found by the type checker. Instead of comparing fingerprints,
+// the Marshal method of a struct type is found to follow the rules of proto.MarshalStruct if it only calls
+// proto.MarshalStruct, or if it was generated by vortexgen. Packets of which the Marshal method does not are
+// skipped, and other types are described as the marshaler kind.
+func describe(pkgs []*pkg) (*packet.Description, error) {
+	d := &packet.Description{Packets: []packet.PacketDescription{}, Types: make(map[string]proto.StructDescription)}
+	if len(pkgs) == 0 {
+		return d, nil
+	}
+	ds := &describer{fset: pkgs[0].fset, types: d.Types, seen: make(map[*types.Named]bool), structRules: make(map[string]bool)}
+	for _, p := range pkgs {
+		ds.findMarshalers(p)
+	}
+	for _, p := range pkgs {
+		scope := p.types.Scope()
+		for _, name := range scope.Names() {
+			obj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || obj.IsAlias() {
+				continue
+			}
+			named, ok := obj.Type().(*types.Named)
+			if !ok || !isPacket(named) {
+				continue
+			}
+			id, err := packetID(p, named)
+			if err == nil && !ds.marshalsStruct(named) {
+				err = fmt.Errorf("Marshal method does not call proto.MarshalStruct and was not generated by vortexgen")
+			}
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "vortexschema: skipping %v: %v\n", named, err)
+				continue
+			}
+			s, err := ds.describeStruct(named)
+			if err != nil {
+				return nil, err
+			}
+			d.Packets = append(d.Packets, packet.PacketDescription{ID: id, StructDescription: s})
+		}
+	}
+	sort.Slice(d.Packets, func(i, j int) bool { return d.Packets[i].ID < d.Packets[j].ID })
+	return d, nil
+}
+
+// isPacket checks if the pointer of the named struct type passed implements packet.Packet.
+func isPacket(named *types.Named) bool {
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return false
+	}
+	return method(named, "ID") != nil && method(named, "Marshal") != nil
+}
+
+// method returns the method with the name passed of the pointer of the named type passed.
+func method(named *types.Named, name string) *types.Func {
+	set := types.NewMethodSet(types.NewPointer(named))
+	sel := set.Lookup(named.Obj().Pkg(), name)
+	if sel == nil {
+		return nil
+	}
+	return sel.Obj().(*types.Func)
+}
+
+// packetID returns the ID of a packet, which is found by evaluating the constant returned by its ID method.
+func packetID(p *pkg, named *types.Named) (uint32, error) {
+	fn := method(named, "ID")
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.FuncDecl)
+			if !ok || p.info.Defs[decl.Name] != fn || decl.Body == nil || len(decl.Body.List) != 1 {
+				continue
+			}
+			ret, ok := decl.Body.List[0].(*ast.ReturnStmt)
+			if !ok || len(ret.Results) != 1 {
+				break
+			}
+			if v := p.info.Types[ret.Results[0]].Value; v != nil {
+				if id, exact := constant.Uint64Val(constant.ToInt(v)); exact && id <= 0xffffffff {
+					return uint32(id), nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("ID method does not return a constant")
+}
+
+// describer builds the descriptions of struct types.
+type describer struct {
+	fset  *token.FileSet
+	types map[string]proto.StructDescription
+	seen  map[*types.Named]bool
+	// structRules holds, by the position of their name, whether the Marshal methods declared in the
+	// packages described follow the rules of proto.MarshalStruct. Positions are used rather than objects,
+	// as a package imported by another is type checked separately from the package itself.
+	structRules map[string]bool
+}
+
+// findMarshalers adds the Marshal methods declared in the package passed to the structRules of the
+// describer.
+func (d *describer) findMarshalers(p *pkg) {
+	for _, f := range p.files {
+		generated := len(f.Comments) > 0 && f.Comments[0].List[0].Text == generatedHeader
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Name.Name != "Marshal" {
+				continue
+			}
+			d.structRules[p.fset.Position(fn.Name.Pos()).String()] = generated || callsMarshalStruct(p, fn)
+		}
+	}
+}
+
+// callsMarshalStruct checks if the body of the Marshal method passed consists of a single call to
+// proto.MarshalStruct with its receiver.
+func callsMarshalStruct(p *pkg, fn *ast.FuncDecl) bool {
+	if fn.Body == nil || len(fn.Body.List) != 1 || len(fn.Recv.List[0].Names) != 1 {
+		return false
+	}
+	stmt, ok := fn.Body.List[0].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := stmt.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	obj, ok := p.info.Uses[sel.Sel].(*types.Func)
+	if !ok || obj.Pkg() == nil || obj.Pkg().Path() != protoPath || obj.Name() != "MarshalStruct" {
+		return false
+	}
+	arg, ok := call.Args[1].(*ast.Ident)
+	return ok && p.info.Uses[arg] == p.info.Defs[fn.Recv.List[0].Names[0]]
+}
+
+// marshalsStruct checks if the Marshal method of the named type passed follows the rules of
+// proto.MarshalStruct. Methods declared outside of the packages described are not known to follow them.
+func (d *describer) marshalsStruct(named *types.Named) bool {
+	fn := method(named, "Marshal")
+	if fn == nil {
+		return false
+	}
+	return d.structRules[d.fset.Position(fn.Pos()).String()]
+}
+
+// describeStruct describes the fields of the named struct type passed.
+func (d *describer) describeStruct(named *types.Named) (proto.StructDescription, error) {
+	d.seen[named] = true
+	st := named.Underlying().(*types.Struct)
+	s := proto.StructDescription{Name: named.Obj().Name(), Fields: []proto.FieldDescription{}}
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		tag, _ := reflect.StructTag(st.Tag(i)).Lookup("vortex")
+		if !field.Exported() || tag == "-" {
+			continue
+		}
+		opts, err := proto.ParseTag(tag)
+		if err == nil {
+			var typ proto.WireType
+			if typ, err = d.describe(field.Type(), opts); err == nil {
+				s.Fields = append(s.Fields, proto.FieldDescription{Name: field.Name(), Type: typ})
+				continue
+			}
+		}
+		return s, fmt.Errorf("describe %v: field %v: %w", named, field.Name(), err)
+	}
+	return s, nil
+}
+
+// describe returns the WireType of a value of the type passed, marshaled with the options passed.
+func (d *describer) describe(t types.Type, opts proto.TagOptions) (proto.WireType, error) {
+	_, pointer := t.Underlying().(*types.Pointer)
+	_, slice := t.Underlying().(*types.Slice)
+	_, isMap := t.Underlying().(*types.Map)
+	if opts.Optional && !pointer {
+		return proto.WireType{}, fmt.Errorf("optional is only supported for pointers, not %v", t)
+	}
+	if opts.Length != "" && !slice {
+		return proto.WireType{}, fmt.Errorf("len is only supported for slices, not %v", t)
+	}
+	if opts.Sorted && !isMap {
+		return proto.WireType{}, fmt.Errorf("sorted is only supported for maps, not %v", t)
+	}
+	if named, ok := t.(*types.Named); ok && method(named, "Marshal") != nil {
+		if opts != (proto.TagOptions{}) {
+			return proto.WireType{}, fmt.Errorf("tag options are not supported for %v, which implements Marshaler", t)
+		}
+		if _, ok := named.Underlying().(*types.Struct); ok && d.marshalsStruct(named) {
+			return d.describeStructType(named)
+		}
+		return proto.WireType{Kind: "marshaler", Type: named.Obj().Name()}, nil
+	}
+	if isNamed(t, "time", "Duration") {
+		// Durations are always marshaled as varints, so the varint option does not change them.
+		return proto.WireType{Kind: "duration"}, nil
+	}
+	if isNamed(t, "github.com/google/uuid", "UUID") {
+		if opts.Varint {
+			return proto.WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return proto.WireType{Kind: "beuuid"}, nil
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		kind, ok := basicKinds[u.Kind()]
+		if !ok {
+			return proto.WireType{}, fmt.Errorf("unsupported type %v", t)
+		}
+		if opts.Varint {
+			switch u.Kind() {
+			case types.Uint16, types.Int16, types.Uint32, types.Int32, types.Uint64, types.Int64:
+				kind = "var" + kind
+			default:
+				return proto.WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+			}
+		}
+		return proto.WireType{Kind: kind}, nil
+	case *types.Slice:
+		if b, ok := u.Elem().Underlying().(*types.Basic); ok && b.Kind() == types.Uint8 && (opts.Length == "" || opts.Length == "varuint32") {
+			return proto.WireType{Kind: "byteslice"}, nil
+		}
+		elem, err := d.describe(u.Elem(), proto.TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		length := opts.Length
+		if length == "" {
+			length = "varuint32"
+		}
+		return proto.WireType{Kind: "slice", Length: length, Elem: &elem}, nil
+	case *types.Array:
+		elem, err := d.describe(u.Elem(), proto.TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		return proto.WireType{Kind: "array", Len: int(u.Len()), Elem: &elem}, nil
+	case *types.Map:
+		if b, ok := u.Key().Underlying().(*types.Basic); opts.Sorted && (!ok || b.Info()&types.IsOrdered == 0) {
+			return proto.WireType{}, fmt.Errorf("sorted is only supported for maps with integer, float or string keys, not %v", t)
+		}
+		key, err := d.describe(u.Key(), proto.TagOptions{})
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		elem, err := d.describe(u.Elem(), proto.TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		return proto.WireType{Kind: "map", Key: &key, Elem: &elem, Sorted: opts.Sorted}, nil
+	case *types.Pointer:
+		if !opts.Optional {
+			return proto.WireType{}, fmt.Errorf("pointer %v requires the optional tag option", t)
+		}
+		elem, err := d.describe(u.Elem(), proto.TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		return proto.WireType{Kind: "optional", Elem: &elem}, nil
+	case *types.Struct:
+		named, ok := t.(*types.Named)
+		if !ok {
+			return proto.WireType{}, fmt.Errorf("unsupported anonymous struct %v", t)
+		}
+		if opts.Varint {
+			return proto.WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return d.describeStructType(named)
+	}
+	return proto.WireType{}, fmt.Errorf("unsupported type %v", t)
+}
+
+// describeStructType returns the WireType of a named struct type, adding its description to the types of
+// the describer if it is marshaled field by field.
+func (d *describer) describeStructType(named *types.Named) (proto.WireType, error) {
+	obj := named.Obj()
+	switch {
+	case obj.Pkg() != nil && obj.Pkg().Path() == "image/color" && obj.Name() == "RGBA":
+		return proto.WireType{Kind: "rgba"}, nil
+	case obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Time":
+		return proto.WireType{Kind: "time"}, nil
+	case obj.Pkg() != nil && obj.Pkg().Path() == "math/big" && obj.Name() == "Int":
+		return proto.WireType{Kind: "bigint"}, nil
+	case obj.Pkg() != nil && obj.Pkg().Path() == protoPath && obj.Name() == "Optional":
+		elem, err := d.describe(named.TypeArgs().At(0), proto.TagOptions{})
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		return proto.WireType{Kind: "optional", Elem: &elem}, nil
+	}
+	if !d.seen[named] {
+		s, err := d.describeStruct(named)
+		if err != nil {
+			return proto.WireType{}, err
+		}
+		d.types[s.Name] = s
+	}
+	return proto.WireType{Kind: "struct", Type: obj.Name()}, nil
+}
+
+// isNamed checks if t is the named type with the package path and name passed.
+func isNamed(t types.Type, path, name string) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == path && obj.Name() == name
+}
+
+// basicKinds maps the basic types supported to their kind in a proto.WireType.
+var basicKinds = map[types.BasicKind]string{
+	types.Bool:    "bool",
+	types.Uint8:   "uint8",
+	types.Int8:    "int8",
+	types.Uint16:  "uint16",
+	types.Int16:   "int16",
+	types.Uint32:  "uint32",
+	types.Int32:   "int32",
+	types.Uint64:  "uint64",
+	types.Int64:   "int64",
+	types.Float32: "float32",
+	types.Float64: "float64",
+	types.String:  "string",
+}
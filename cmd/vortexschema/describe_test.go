@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// TestDescribe checks that packets and types are only described field by field if their Marshal method
+// calls proto.MarshalStruct or was generated by vortexgen, and that packets with a Marshal method written by
+// hand are skipped.
+func TestDescribe(t *testing.T) {
+	pkgs, err := load([]string{"./testdata/packets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := describe(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pk := range d.Packets {
+		names = append(names, pk.Name)
+	}
+	if len(names) != 2 || names[0] != "Move" || names[1] != "Spawn" {
+		t.Fatalf("described packets %v, want Move and Spawn", names)
+	}
+
+	want := map[string]proto.WireType{
+		"Position": {Kind: "struct", Type: "Position"},
+		"Colour":   {Kind: "marshaler", Type: "Colour"},
+		"Flags":    {Kind: "marshaler", Type: "Flags"},
+	}
+	for _, f := range d.Packets[0].Fields {
+		if w, ok := want[f.Name]; ok && f.Type != w {
+			t.Errorf("field %v: got %+v, want %+v", f.Name, f.Type, w)
+		}
+	}
+	if _, ok := d.Types["Position"]; !ok {
+		t.Error("Position not described")
+	}
+	if _, ok := d.Types["Colour"]; ok {
+		t.Error("Colour, which has a Marshal method written by hand, described field by field")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// pkg is a type checked package.
+type pkg struct {
+	fset  *token.FileSet
+	files []*ast.File
+	types *types.Package
+	info  *types.Info
+}
+
+// load type checks the packages matched by the patterns passed, which are resolved using go list.
+func load(patterns []string) ([]*pkg, error) {
+	cmd := exec.Command("go", append([]string{"list", "-f", "{{.ImportPath}}\t{{.Dir}}\t{{join .GoFiles \"\\t\"}}"}, patterns...)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	imp := importer.ForCompiler(fset, "source", nil)
+	var pkgs []*pkg
+	for _, line := range strings.Split(string(bytes.TrimSpace(out)), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) < 3 {
+			// Packages without Go files have nothing to describe.
+			continue
+		}
+		p := &pkg{fset: fset, info: &types.Info{
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Defs:  make(map[*ast.Ident]types.Object),
+			Uses:  make(map[*ast.Ident]types.Object),
+		}}
+		for _, name := range parts[2:] {
+			f, err := parser.ParseFile(fset, filepath.Join(parts[1], name), nil, parser.ParseComments)
+			if err != nil {
+				return nil, err
+			}
+			p.files = append(p.files, f)
+		}
+		conf := types.Config{Importer: imp}
+		if p.types, err = conf.Check(parts[0], fset, p.files, p.info); err != nil {
+			return nil, fmt.Errorf("type check %v: %w", parts[0], err)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,61 @@
+// Command vortexschema writes a JSON description of the packets declared in a set of Go packages, so that
+// codecs for the packets can be generated in other languages, such as TypeScript.
+//
+// Usage:
+//
+//	vortexschema [-o output] packages
+//
+// Packages are passed as accepted by go list, such as ./packets/... A packet is any type in the packages of
+// which the pointer has an ID method returning a constant and a Marshal method. The output is a
+// packet.Description encoded as JSON, which describes the fields of the packets as marshaled by
+// proto.MarshalStruct. Only packets and types of which the Marshal method calls proto.MarshalStruct or was
+// generated by vortexgen are described field by field. Other packets are skipped with a warning, and fields
+// of other types are described as the marshaler kind.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	output := flag.String("o", "", "file to write the description to, defaults to standard output")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: vortexschema [-o output] packages\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Args(), *output); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "vortexschema:", err)
+		os.Exit(1)
+	}
+}
+
+// run describes the packets in the packages passed and writes the description to output.
+func run(patterns []string, output string) error {
+	pkgs, err := load(patterns)
+	if err != nil {
+		return err
+	}
+	d, err := describe(pkgs)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(output, b, 0644)
+}
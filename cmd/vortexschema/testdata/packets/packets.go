@@ -0,0 +1,68 @@
+// Package packets holds packets marshaled in every way that vortexschema distinguishes.
+package packets
+
+import "github.com/vortex-service/vortex/vortex/proto"
+
+// Flags is a non-struct type marshaled by its own Marshal method.
+type Flags uint32
+
+// Marshal ...
+func (f *Flags) Marshal(io proto.IO) {
+	io.Varuint32((*uint32)(f))
+}
+
+// Position is marshaled with proto.MarshalStruct.
+type Position struct {
+	X, Y int32 `vortex:"varint"`
+}
+
+// Marshal ...
+func (p *Position) Marshal(io proto.IO) {
+	proto.MarshalStruct(io, p)
+}
+
+// Colour has a Marshal method written by hand.
+type Colour struct {
+	R, G, B uint8
+}
+
+// Marshal ...
+func (c *Colour) Marshal(io proto.IO) {
+	io.Uint8(&c.R)
+	if c.R != 0 {
+		io.Uint8(&c.G)
+	}
+}
+
+// Move is a packet marshaled with proto.MarshalStruct.
+type Move struct {
+	Position Position
+	Colour   Colour
+	Flags    Flags
+	Path     []Position
+}
+
+// ID ...
+func (*Move) ID() uint32 {
+	return 10
+}
+
+// Marshal ...
+func (m *Move) Marshal(io proto.IO) {
+	proto.MarshalStruct(io, m)
+}
+
+// Chat is a packet of which the Marshal method is written by hand.
+type Chat struct {
+	Message string
+}
+
+// ID ...
+func (*Chat) ID() uint32 {
+	return 11
+}
+
+// Marshal ...
+func (c *Chat) Marshal(io proto.IO) {
+	io.String(&c.Message)
+}
@@ -1,67 +1,41 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
+	"log/slog"
+	"os"
 
-	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex"
 	"github.com/vortex-service/vortex/vortex/proto"
 	"github.com/vortex-service/vortex/vortex/proto/packet"
 )
 
 func main() {
-	c, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/ws", nil)
-	if err != nil {
-		panic(err)
-	}
-
-	defer c.Close()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
 
-	login := &packet.Login{
-		Service: "oauth-service",
-		Token:   "super-secret-token",
+	d := vortex.Dialer{
+		Logger:      log,
+		Packets:     []packet.Packet{&pongPacket{}},
+		SentPackets: []packet.Packet{&pingPacket{}},
 	}
-
-	buf := bytes.NewBuffer(make([]byte, 0, 1024))
-
-	w := proto.NewWriter(buf, 1)
-	id := uint32(100)
-	w.Varuint32(&id)
-	login.Marshal(w)
-
-	fmt.Printf("SENDING LOGIN PACKET %v\n", buf.Bytes())
-
-	err = c.WriteMessage(websocket.BinaryMessage, buf.Bytes())
+	c, err := d.Dial("ws://localhost:8080/ws", "oauth-service", "super-secret-token")
 	if err != nil {
 		panic(err)
 	}
+	defer c.Close()
 
-	ping := &pingPacket{}
-	buf.Reset()
-	w = proto.NewWriter(buf, 1)
-	id = ping.ID()
-	w.Varuint32(&id)
-	ping.Marshal(w)
-
-	fmt.Printf("SENDING PING PACKET %v\n", buf.Bytes())
-
-	err = c.WriteMessage(websocket.BinaryMessage, buf.Bytes())
-	if err != nil {
+	if err := c.WritePacket(&pingPacket{}, false); err != nil {
 		panic(err)
 	}
 
-	go func() {
-		for {
-			_, msg, err := c.ReadMessage()
-			if err != nil {
-				fmt.Println("Error reading message:", err)
-			}
-
-			fmt.Println(msg)
+	for {
+		pk, err := c.ReadPacket()
+		if err != nil {
+			log.Error("read packet", "err", err)
+			return
 		}
-	}()
-
-	select {}
+		fields, _ := proto.EncodeJSON(pk)
+		log.Info("received packet", "packet", pk.ID(), "fields", string(fields))
+	}
 }
 
 type pingPacket struct{}
@@ -71,3 +45,11 @@ func (p *pingPacket) ID() uint32 {
 }
 
 func (p *pingPacket) Marshal(proto.IO) {}
+
+type pongPacket struct{}
+
+func (p *pongPacket) ID() uint32 {
+	return 101
+}
+
+func (p *pongPacket) Marshal(proto.IO) {}
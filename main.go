@@ -12,6 +12,7 @@ func main() {
 		Token: "TOKEN123",
 	})
 	s.RegisterPackets(&pingPacket{})
+	s.RegisterSentPackets(&pongPacket{})
 	s.RegisterHandler(&Handler{})
 	s.Start()
 }
@@ -0,0 +1,109 @@
+package vortex
+
+import (
+	"net"
+	"net/http"
+)
+
+// ConnectionLimits holds the maximum numbers of connections that a service keeps open at once. Limits left
+// 0 are not applied. The HTTP statuses below apply to websocket connections accepted by Start, while
+// connections accepted by Serve are closed with the close code 1013 (try again later) instead.
+type ConnectionLimits struct {
+	// Total is the maximum number of connections of the service. Further websocket upgrades are refused
+	// with HTTP status 503 Service Unavailable.
+	Total int
+	// PerIP is the maximum number of connections from the same remote IP address. Further websocket
+	// upgrades are refused with HTTP status 429 Too Many Requests. The address is that of the network
+	// connection, so peers behind the same proxy share the limit.
+	PerIP int
+	// PerIdentity is the maximum number of connections logged in with the same identity. Further logins
+	// are rejected with packet.AuthResponseTooManyConnections.
+	PerIdentity int
+}
+
+// admit reserves a connection of the remote address passed against the ConnectionLimits of the service
+// before upgrading it to a websocket connection. If the connection may not be accepted, false is returned
+// with the HTTP status to refuse it with. release must be called with the address once an admitted
+// connection is closed, or when upgrading it fails.
+func (v *Vortex) admit(addr string) (int, bool) {
+	if v.draining.Load() {
+		return http.StatusServiceUnavailable, false
+	}
+	ip := remoteIP(addr)
+
+	v.connsMu.Lock()
+	defer v.connsMu.Unlock()
+	if v.connLimits.Total > 0 && v.admitted >= v.connLimits.Total {
+		return http.StatusServiceUnavailable, false
+	}
+	if v.connLimits.PerIP > 0 && v.ips[ip] >= v.connLimits.PerIP {
+		return http.StatusTooManyRequests, false
+	}
+	v.admitted++
+	v.ips[ip]++
+	return 0, true
+}
+
+// release releases the connection of the remote address passed reserved using admit.
+func (v *Vortex) release(addr string) {
+	ip := remoteIP(addr)
+
+	v.connsMu.Lock()
+	defer v.connsMu.Unlock()
+	v.admitted--
+	if v.ips[ip]--; v.ips[ip] <= 0 {
+		delete(v.ips, ip)
+	}
+}
+
+// admitIdentity reserves a connection logging in with the identity passed against the PerIdentity limit.
+// False is returned if the limit is reached. The identity is released when the connection is closed.
+func (v *Vortex) admitIdentity(identity string) bool {
+	v.connsMu.Lock()
+	defer v.connsMu.Unlock()
+	if v.connLimits.PerIdentity > 0 && v.identities[identity] >= v.connLimits.PerIdentity {
+		return false
+	}
+	v.identities[identity]++
+	return true
+}
+
+// releaseIdentity releases a connection logged in with the identity passed.
+func (v *Vortex) releaseIdentity(identity string) {
+	v.connsMu.Lock()
+	defer v.connsMu.Unlock()
+	if v.identities[identity]--; v.identities[identity] <= 0 {
+		delete(v.identities, identity)
+	}
+}
+
+// remoteIP returns the IP of a remote address in the host:port form. The address is returned as-is if it
+// has no port.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// SetDraining sets whether the service is draining. A draining service refuses new connections with HTTP
+// status 503 Service Unavailable, and rejects logins of connections accepted earlier with
+// packet.AuthResponseDraining, while connections that are already logged in are served until they are
+// closed. This allows taking a node out of service without disconnecting its peers.
+func (v *Vortex) SetDraining(draining bool) {
+	v.draining.Store(draining)
+	v.log.Info("draining changed", "draining", draining)
+}
+
+// Draining checks if the service is draining. See SetDraining.
+func (v *Vortex) Draining() bool {
+	return v.draining.Load()
+}
+
+// Conns returns the number of connections open, including those that have not logged in yet.
+func (v *Vortex) Conns() int {
+	v.connsMu.Lock()
+	defer v.connsMu.Unlock()
+	return len(v.conns)
+}
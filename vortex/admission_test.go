@@ -0,0 +1,110 @@
+package vortex
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/vortex-service/vortex/vortex/auth"
+)
+
+// TestAdmit checks that connections are admitted until the Total or PerIP limit is reached, and refused
+// with the status of the limit afterwards or while the service is draining.
+func TestAdmit(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limits   ConnectionLimits
+		draining bool
+		// admitted holds the addresses of the connections admitted before addr.
+		admitted []string
+		addr     string
+		status   int
+		ok       bool
+	}{
+		{"no limits", ConnectionLimits{}, false, []string{"10.0.0.1:1", "10.0.0.1:2"}, "10.0.0.1:3", 0, true},
+		{"below total", ConnectionLimits{Total: 2}, false, []string{"10.0.0.1:1"}, "10.0.0.2:1", 0, true},
+		{"total", ConnectionLimits{Total: 2}, false, []string{"10.0.0.1:1", "10.0.0.2:1"}, "10.0.0.3:1", http.StatusServiceUnavailable, false},
+		{"per IP", ConnectionLimits{PerIP: 1}, false, []string{"10.0.0.1:1"}, "10.0.0.1:2", http.StatusTooManyRequests, false},
+		{"per IP other IP", ConnectionLimits{PerIP: 1}, false, []string{"10.0.0.1:1"}, "10.0.0.2:1", 0, true},
+		{"per IP without port", ConnectionLimits{PerIP: 1}, false, []string{"10.0.0.1"}, "10.0.0.1:2", http.StatusTooManyRequests, false},
+		{"total before per IP", ConnectionLimits{Total: 1, PerIP: 1}, false, []string{"10.0.0.1:1"}, "10.0.0.1:2", http.StatusServiceUnavailable, false},
+		{"draining", ConnectionLimits{}, true, nil, "10.0.0.1:1", http.StatusServiceUnavailable, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewService("test", auth.Auth{}, WithLogger(testLogger()), WithConnectionLimits(tc.limits))
+			for _, addr := range tc.admitted {
+				if _, ok := v.admit(addr); !ok {
+					t.Fatalf("%v not admitted", addr)
+				}
+			}
+			v.SetDraining(tc.draining)
+			if status, ok := v.admit(tc.addr); status != tc.status || ok != tc.ok {
+				t.Errorf("got status %v and %v, want status %v and %v", status, ok, tc.status, tc.ok)
+			}
+		})
+	}
+}
+
+// TestRelease checks that releasing a connection makes room for another one under the Total and PerIP
+// limits, and that IPs without connections are forgotten.
+func TestRelease(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		limits ConnectionLimits
+		// After admitting the connections of admitted, addr must be refused until released is released.
+		admitted []string
+		released string
+		addr     string
+	}{
+		{"total", ConnectionLimits{Total: 2}, []string{"10.0.0.1:1", "10.0.0.2:1"}, "10.0.0.1:1", "10.0.0.3:1"},
+		{"per IP", ConnectionLimits{PerIP: 2}, []string{"10.0.0.1:1", "10.0.0.1:2"}, "10.0.0.1:1", "10.0.0.1:3"},
+		{"per IP other port", ConnectionLimits{PerIP: 1}, []string{"10.0.0.1:1"}, "10.0.0.1:2", "10.0.0.1:3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewService("test", auth.Auth{}, WithLogger(testLogger()), WithConnectionLimits(tc.limits))
+			for _, addr := range tc.admitted {
+				if _, ok := v.admit(addr); !ok {
+					t.Fatalf("%v not admitted", addr)
+				}
+			}
+			if _, ok := v.admit(tc.addr); ok {
+				t.Fatalf("%v admitted beyond the limits", tc.addr)
+			}
+			v.release(tc.released)
+			if status, ok := v.admit(tc.addr); !ok {
+				t.Errorf("%v refused with status %v after a connection was released", tc.addr, status)
+			}
+		})
+	}
+
+	v := NewService("test", auth.Auth{}, WithLogger(testLogger()))
+	v.admit("10.0.0.1:1")
+	v.release("10.0.0.1:1")
+	if v.admitted != 0 || len(v.ips) != 0 {
+		t.Errorf("got %v connections admitted from IPs %v after releasing all of them", v.admitted, v.ips)
+	}
+}
+
+// TestAdmitIdentity checks that logins are admitted until the PerIdentity limit of their identity is
+// reached, and again once a connection of the identity is released.
+func TestAdmitIdentity(t *testing.T) {
+	v := NewService("test", auth.Auth{}, WithLogger(testLogger()), WithConnectionLimits(ConnectionLimits{PerIdentity: 2}))
+	for i, tc := range []struct {
+		identity string
+		release  bool
+		ok       bool
+	}{
+		{"a", false, true},
+		{"a", false, true},
+		{"a", false, false},
+		{"b", false, true},
+		{"a", true, true},
+		{"a", false, false},
+	} {
+		if tc.release {
+			v.releaseIdentity(tc.identity)
+		}
+		if ok := v.admitIdentity(tc.identity); ok != tc.ok {
+			t.Errorf("step %v: identity %v admitted %v, want %v", i, tc.identity, ok, tc.ok)
+		}
+	}
+}
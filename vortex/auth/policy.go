@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policy is a declarative access control policy deciding which identities may send which packets after
+// logging in. Policies are usually kept in a JSON file and loaded using LoadPolicy, so that they can be
+// audited without reading the code of the service:
+//
+//	{
+//		"roles": {"admin": ["lobby", "ops"]},
+//		"default": "deny",
+//		"packets": [
+//			{"type": "Kick", "allow": ["role:admin"]},
+//			{"id": 12, "allow": ["*"]}
+//		]
+//	}
+type Policy struct {
+	// Roles maps the name of every role to the identities holding it.
+	Roles map[string][]string `json:"roles,omitempty"`
+	// Default is the decision for packets without a rule: "allow" or "deny". If empty, such packets are
+	// denied.
+	Default string `json:"default,omitempty"`
+	// Packets holds the rules of the packets that identities may send. Every packet may have one rule at
+	// most.
+	Packets []PacketRule `json:"packets"`
+}
+
+// PacketRule holds the identities allowed to send a packet. The packet is either identified by its ID or by
+// the name of its Go type, so exactly one of ID and Type must be set.
+type PacketRule struct {
+	// ID is the ID of the packet.
+	ID *uint32 `json:"id,omitempty"`
+	// Type is the name of the Go type of the packet without its package, such as "Kick".
+	Type string `json:"type,omitempty"`
+	// Allow holds the identities allowed to send the packet. An entry "role:<name>" allows every identity
+	// holding the role, and "*" allows every identity. If empty, the packet is denied to every identity.
+	Allow []string `json:"allow"`
+}
+
+// LoadPolicy reads and validates the JSON Policy in the file at the path passed.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load policy: %w", err)
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("load policy %v: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParsePolicy parses and validates a JSON Policy. Unknown fields are rejected, so that misspelt keys do
+// not silently change the meaning of the policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	p := new(Policy)
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that the policy is well-formed: the default decision is valid, every rule identifies a
+// packet in exactly one way, no packet has several rules and every role allowed exists.
+func (p *Policy) Validate() error {
+	if p.Default != "" && p.Default != "allow" && p.Default != "deny" {
+		return fmt.Errorf("policy default %q: must be allow or deny", p.Default)
+	}
+	ids, types := make(map[uint32]bool), make(map[string]bool)
+	for i, rule := range p.Packets {
+		switch {
+		case rule.ID != nil && rule.Type != "":
+			return fmt.Errorf("policy rule %v: both id and type set", i)
+		case rule.ID != nil:
+			if ids[*rule.ID] {
+				return fmt.Errorf("policy rule %v: packet %v has several rules", i, *rule.ID)
+			}
+			ids[*rule.ID] = true
+		case rule.Type != "":
+			if types[rule.Type] {
+				return fmt.Errorf("policy rule %v: packet %v has several rules", i, rule.Type)
+			}
+			types[rule.Type] = true
+		default:
+			return fmt.Errorf("policy rule %v: neither id nor type set", i)
+		}
+		for _, entry := range rule.Allow {
+			if role, ok := strings.CutPrefix(entry, "role:"); ok {
+				if _, ok := p.Roles[role]; !ok {
+					return fmt.Errorf("policy rule %v: unknown role %q", i, role)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultAllows checks if packets without a rule are allowed.
+func (p *Policy) DefaultAllows() bool {
+	return p.Default == "allow"
+}
+
+// Allows checks if the identity passed is allowed by the Allow entries of a rule, either directly, through
+// a role it holds or through "*".
+func (p *Policy) Allows(rule PacketRule, identity string) bool {
+	for _, entry := range rule.Allow {
+		if entry == "*" || entry == identity {
+			return true
+		}
+		if role, ok := strings.CutPrefix(entry, "role:"); ok {
+			for _, holder := range p.Roles[role] {
+				if holder == identity {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParsePolicy checks that valid policies are parsed, with an empty Default denying packets without a
+// rule, and that malformed policies are rejected.
+func TestParsePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		policy       string
+		defaultAllow bool
+		err          string
+	}{
+		{"empty default", `{"packets": [{"id": 1, "allow": ["a"]}]}`, false, ""},
+		{"deny", `{"default": "deny", "packets": []}`, false, ""},
+		{"allow", `{"default": "allow", "packets": []}`, true, ""},
+		{"roles", `{"roles": {"admin": ["a"]}, "packets": [{"type": "Kick", "allow": ["role:admin"]}]}`, false, ""},
+		{"invalid default", `{"default": "yes", "packets": []}`, false, "must be allow or deny"},
+		{"unknown field", `{"defualt": "allow", "packets": []}`, false, "unknown field"},
+		{"id and type", `{"packets": [{"id": 1, "type": "Kick"}]}`, false, "both id and type set"},
+		{"neither id nor type", `{"packets": [{"allow": ["a"]}]}`, false, "neither id nor type set"},
+		{"duplicate id", `{"packets": [{"id": 1}, {"id": 1}]}`, false, "several rules"},
+		{"duplicate type", `{"packets": [{"type": "Kick"}, {"type": "Kick"}]}`, false, "several rules"},
+		{"unknown role", `{"packets": [{"id": 1, "allow": ["role:admin"]}]}`, false, "unknown role"},
+		{"not JSON", `default: allow`, false, "parse policy"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePolicy([]byte(tc.policy))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.DefaultAllows() != tc.defaultAllow {
+				t.Errorf("default allows: got %v, want %v", p.DefaultAllows(), tc.defaultAllow)
+			}
+		})
+	}
+}
+
+// TestPolicyAllows checks that a rule allows identities listed directly, through a role or through "*".
+func TestPolicyAllows(t *testing.T) {
+	p := &Policy{Roles: map[string][]string{"admin": {"a"}}}
+	for _, tc := range []struct {
+		allow    []string
+		identity string
+		allowed  bool
+	}{
+		{nil, "a", false},
+		{[]string{"a"}, "a", true},
+		{[]string{"a"}, "b", false},
+		{[]string{"*"}, "b", true},
+		{[]string{"role:admin"}, "a", true},
+		{[]string{"role:admin"}, "b", false},
+	} {
+		if allowed := p.Allows(PacketRule{Allow: tc.allow}, tc.identity); allowed != tc.allowed {
+			t.Errorf("%v allows %v: got %v, want %v", tc.allow, tc.identity, allowed, tc.allowed)
+		}
+	}
+}
+
+// TestLoadPolicy checks that a policy is loaded from a file, and that the path is part of the errors.
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(path, []byte(`{"default": "allow", "packets": [{"id": 1, "allow": ["*"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.DefaultAllows() || len(p.Packets) != 1 || *p.Packets[0].ID != 1 {
+		t.Errorf("got policy %+v", p)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"default": "yes"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicy(invalid); err == nil || !strings.Contains(err.Error(), invalid) {
+		t.Errorf("got error %v, want an error naming %v", err, invalid)
+	}
+	if _, err := LoadPolicy(filepath.Join(dir, "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want a not exist error", err)
+	}
+}
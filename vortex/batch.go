@@ -0,0 +1,96 @@
+package vortex
+
+import (
+	"time"
+
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+const (
+	// DefaultBatchSize is the size in bytes of the packets queued at which a batch is written immediately
+	// if no other size was set.
+	DefaultBatchSize = 64 * 1024
+)
+
+// WriteBatch writes the packets passed to the connection in a single message. Packets queued for the next
+// batch are written first, in the same message. If the peer does not support batches, the packets are
+// written in a message each.
+func (c *Conn) WriteBatch(pks ...packet.Packet) error {
+	if len(pks) == 0 {
+		return nil
+	}
+	if !c.supports(packet.CapabilityBatching) {
+		for _, pk := range pks {
+			if err := c.WritePacket(pk, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	frames := make([]frame, 0, len(pks))
+	for _, pk := range pks {
+		frames = append(frames, encodeFrame(header{}, pk))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.batchMu.Lock()
+	queued := c.takeBatch()
+	c.batchMu.Unlock()
+	return c.writeFrames(append(queued, frames...))
+}
+
+// Flush writes the packets queued for the next batch. Flush is called automatically at the batch delay, so
+// it only needs to be called to write queued packets sooner. Nothing is written if batching is not enabled.
+func (c *Conn) Flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.flush()
+}
+
+// flush writes the packets queued for the next batch. c.writeMu must be held.
+func (c *Conn) flush() error {
+	c.batchMu.Lock()
+	frames := c.takeBatch()
+	c.batchMu.Unlock()
+
+	if len(frames) == 0 {
+		return nil
+	}
+	return c.writeFrames(frames)
+}
+
+// takeBatch takes all frames queued for the next batch from the queue. c.batchMu must be held.
+func (c *Conn) takeBatch() []frame {
+	frames := c.batch
+	c.batch, c.batchSize = nil, 0
+	c.metrics.WriteQueueChanged(-len(frames))
+	return frames
+}
+
+// startBatching enables queueing packets written and writing them in batches. A batch is written every
+// delay, or as soon as at least size bytes of packets are queued.
+func (c *Conn) startBatching(delay time.Duration, size int) {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	c.batchMu.Lock()
+	c.batchLimit = size
+	c.batchMu.Unlock()
+
+	go func() {
+		t := time.NewTicker(delay)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := c.Flush(); err != nil {
+					c.log.Debug("flush batch", "err", err)
+				}
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package vortex
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vortex-service/vortex/vortex/metrics"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// discardConn is a MessageConn that keeps the last message written and never returns a message.
+type discardConn struct {
+	last []byte
+}
+
+func (c *discardConn) ReadMessage() ([]byte, error)    { return nil, net.ErrClosed }
+func (c *discardConn) WriteMessage(msg []byte) error   { c.last = msg; return nil }
+func (c *discardConn) WriteClose(payload []byte) error { return nil }
+func (c *discardConn) SetReadLimit(int64)              {}
+func (c *discardConn) SetReadDeadline(time.Time) error { return nil }
+func (c *discardConn) RemoteAddr() net.Addr            { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+func (c *discardConn) Close() error                    { return nil }
+
+// newTestConn creates a Conn writing to a discardConn that decodes payload packets.
+func newTestConn() (*Conn, *discardConn) {
+	dc := new(discardConn)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return newConn(dc, log, metrics.Nop{}, newPool(&payload{})), dc
+}
+
+// compressibleData returns n bytes of deterministic data that compresses about as well as typical packet
+// payloads, such as skin data, which consist of runs of a small set of values.
+func compressibleData(n int) []byte {
+	r := rand.New(rand.NewSource(int64(n)))
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		v, run := byte(r.Intn(16)), 1+r.Intn(8)
+		for i := 0; i < run && len(b) < n; i++ {
+			b = append(b, v)
+		}
+	}
+	return b
+}
+
+var benchmarkSizes = []int{64, 1024, 64 * 1024, 1024 * 1024}
+
+var benchmarkCompressions = []struct {
+	name string
+	alg  packet.Compression
+}{
+	{"none", nil},
+	{"flate", packet.FlateCompression},
+	{"snappy", packet.SnappyCompression},
+}
+
+// BenchmarkWriteCompressed measures the throughput of writing packets of different sizes without
+// compression and with every compression algorithm. Every packet is compressed, regardless of the
+// compression threshold, and ratio reports the size written relative to the size of the packet.
+func BenchmarkWriteCompressed(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		for _, comp := range benchmarkCompressions {
+			b.Run(fmt.Sprintf("%v/%v", comp.name, size), func(b *testing.B) {
+				c, dc := newTestConn()
+				if comp.alg != nil {
+					c.enableCompression(comp.alg, 0)
+				}
+				pk := &payload{Data: compressibleData(size)}
+
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := c.WritePacket(pk, false); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(dc.last))/float64(size), "ratio")
+			})
+		}
+	}
+}
+
+// BenchmarkReadCompressed measures the throughput of decoding packets of different sizes without
+// compression and with every compression algorithm.
+func BenchmarkReadCompressed(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		for _, comp := range benchmarkCompressions {
+			b.Run(fmt.Sprintf("%v/%v", comp.name, size), func(b *testing.B) {
+				c, dc := newTestConn()
+				if comp.alg != nil {
+					c.enableCompression(comp.alg, 0)
+				}
+				if err := c.WritePacket(&payload{Data: compressibleData(size)}, false); err != nil {
+					b.Fatal(err)
+				}
+				msg := dc.last
+
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.decodeFrame(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
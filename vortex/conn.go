@@ -1,14 +1,18 @@
 package vortex
 
 import (
-	"bytes"
-	"log"
-	"time"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/vortex-service/vortex/vortex/internal"
-	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/metrics"
 	"github.com/vortex-service/vortex/vortex/proto/packet"
+	"github.com/vortex-service/vortex/vortex/trace"
 )
 
 var upgrader = websocket.Upgrader{
@@ -16,36 +20,536 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// Conn is a connection to a peer of a Vortex service. A Conn is created for every connection accepted and
+// stays the same for the whole lifetime of that connection.
 type Conn struct {
-	conn *websocket.Conn
+	conn    MessageConn
+	id      uuid.UUID
+	log     *slog.Logger
+	metrics metrics.Collector
+	pool    pool
+	// zeroCopy is true if packets are decoded with zero-copy decoding.
+	zeroCopy bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+
+	batchMu    sync.Mutex
+	batch      []frame
+	batchSize  int
+	batchLimit int
+	pending    []received
+
+	requestsMu  sync.Mutex
+	requests    map[uint32]chan packet.Packet
+	nextRequest atomic.Uint32
+
+	client        bool
+	streamHandler StreamHandler
+	streamsMu     sync.Mutex
+	streams       map[uint32]*stream
+	nextStream    atomic.Uint32
+
+	mu                   sync.RWMutex
+	identity             string
+	loggedIn             bool
+	protocol             uint32
+	capabilities         uint32
+	compression          packet.Compression
+	compressionThreshold int
+
+	// encryptWith and decryptWith encrypt the messages written and decrypt those read once encryption was
+	// negotiated. encryptWith is only used with writeMu held, and decryptWith by the goroutine reading.
+	encryptWith, decryptWith *cipherState
+
+	// buckets and identityBucket hold the rate limit buckets of the connection and its identity. They are
+	// only used by the goroutine reading the connection of a service, after the connection logged in.
+	buckets        connBuckets
+	identityBucket *identityBucket
 }
 
 func NewConn(conn *websocket.Conn) *Conn {
-	return &Conn{conn: conn}
+	return newConn(websocketConn{conn}, slog.Default(), metrics.Nop{}, nil)
+}
+
+// newConn creates a Conn that decodes the packets in the pool passed and reports its metrics to the
+// metrics.Collector passed. Every line logged by the Conn carries its ID and remote address.
+func newConn(conn MessageConn, log *slog.Logger, m metrics.Collector, p pool) *Conn {
+	c := &Conn{conn: conn, id: uuid.New(), metrics: m, pool: p, requests: make(map[uint32]chan packet.Packet), streams: make(map[uint32]*stream)}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.log = log.With("conn", c.id.String(), "addr", conn.RemoteAddr().String())
+	c.metrics.ConnOpened()
+	return c
+}
+
+// ID returns the unique ID of the connection, assigned when it was accepted.
+func (c *Conn) ID() uuid.UUID {
+	return c.id
+}
+
+// Subprotocol returns the websocket subprotocol negotiated for the connection, or an empty string if none
+// was negotiated or the connection is not a websocket connection.
+func (c *Conn) Subprotocol() string {
+	if ws, ok := c.conn.(websocketConn); ok {
+		return ws.Subprotocol()
+	}
+	return ""
+}
+
+// RemoteAddr returns the remote network address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Identity returns the service name the connection logged in with. For connections accepted by a Vortex
+// service, this is the name the peer authenticated with. An empty string is returned if the connection has
+// not logged in yet.
+func (c *Conn) Identity() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.identity
+}
+
+// LoggedIn checks if the connection has successfully logged in.
+func (c *Conn) LoggedIn() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loggedIn
+}
+
+// Protocol returns the protocol version of the peer. It is 0 if the connection has not logged in yet.
+func (c *Conn) Protocol() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.protocol
+}
+
+// Capabilities returns the bitset of packet.Capability constants supported by both sides of the
+// connection. Features whose capability is not set are not used. It is 0 if the connection has not logged
+// in yet.
+func (c *Conn) Capabilities() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.capabilities
+}
+
+// supports checks if both sides of the connection support the capability passed.
+func (c *Conn) supports(capability uint32) bool {
+	return c.Capabilities()&capability != 0
+}
+
+// login marks the connection as authenticated with the identity passed, with the protocol version of the
+// peer and the capabilities supported by both sides.
+func (c *Conn) login(identity string, protocol, capabilities uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity, c.loggedIn = identity, true
+	c.protocol, c.capabilities = protocol, capabilities
+}
+
+// Context returns a context.Context that is cancelled when the connection is closed.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// enableCompression enables compressing frames of at least threshold bytes using the compression
+// algorithm passed, and decompressing frames received with it.
+func (c *Conn) enableCompression(alg packet.Compression, threshold int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compression, c.compressionThreshold = alg, threshold
+}
+
+// compressor returns the compression algorithm negotiated and the threshold above which frames are
+// compressed. A nil packet.Compression is returned if compression is not enabled.
+func (c *Conn) compressor() (packet.Compression, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.compression, c.compressionThreshold
+}
+
+// enableEncryption enables encrypting the messages written and decrypting those read with the ciphers
+// passed.
+func (c *Conn) enableEncryption(write, read *cipherState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.encryptWith, c.decryptWith = write, read
+}
+
+// ciphers returns the ciphers that messages written and read are encrypted and decrypted with. Both are nil
+// if encryption is not enabled.
+func (c *Conn) ciphers() (write, read *cipherState) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.encryptWith, c.decryptWith
+}
+
+// Encrypted checks if the frames sent over the connection are encrypted, which is the case if both sides
+// agreed on an encryption algorithm when logging in.
+func (c *Conn) Encrypted() bool {
+	write, _ := c.ciphers()
+	return write != nil
+}
+
+// Close writes any packets queued for the next batch and closes the underlying connection without sending
+// a close message.
+func (c *Conn) Close() error {
+	_ = c.Flush()
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.closeStreams()
+		c.metrics.ConnClosed()
+	})
+	return c.conn.Close()
+}
+
+// closeWithCode sends a close message with the websocket close code and text passed and closes the
+// connection.
+func (c *Conn) closeWithCode(code int, text string) error {
+	c.writeMu.Lock()
+	err := c.conn.WriteClose(websocket.FormatCloseMessage(code, text))
+	c.writeMu.Unlock()
+	if err != nil {
+		c.log.Debug("write close message", "err", err)
+	}
+	return c.Close()
+}
+
+// ReadPacket reads the next packet from the connection. Connections accepted by a Vortex service are read
+// by the service and their packets passed to its Handler, so ReadPacket should only be called on
+// connections returned by a Dialer. Responses to requests made using Request are also read by ReadPacket,
+// so it must be called continuously for Request to return.
+func (c *Conn) ReadPacket() (packet.Packet, error) {
+	_, pk, err := c.ReadPacketContext()
+	return pk, err
+}
+
+// ReadPacketContext reads the next packet from the connection, similarly to ReadPacket. The context
+// returned carries the trace context of the packet, if any, and may be passed to Reply if the packet is a
+// request.
+func (c *Conn) ReadPacketContext() (context.Context, packet.Packet, error) {
+	for {
+		h, pk, err := c.read()
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.flags&flagStream != 0 {
+			c.acceptStream(h.streamID, pk)
+			continue
+		}
+		if h.flags&flagResponse != 0 {
+			c.respond(h.requestID, pk)
+			continue
+		}
+		return c.context(h), pk, nil
+	}
+}
+
+// read reads the next packet from the connection with the header of the frame it was in. If a batch was
+// received, the packets in it are returned one by one by subsequent calls. Frames opening a stream are
+// returned with the packet the stream was opened with, to be passed to acceptStream, while other stream
+// frames received are passed to their stream.
+func (c *Conn) read() (header, packet.Packet, error) {
+	for {
+		if len(c.pending) == 0 {
+			msg, err := c.conn.ReadMessage()
+			if err != nil {
+				return header{}, nil, err
+			}
+			if _, read := c.ciphers(); read != nil {
+				if msg, err = read.open(msg); err != nil {
+					err = &DecodeError{Err: err}
+				}
+			}
+			if err == nil {
+				c.pending, err = c.decodeFrame(msg)
+			}
+			if err != nil {
+				id := err.(*DecodeError).PacketID
+				c.log.Warn("decode packet", "packet", id, "err", err)
+				c.metrics.DecodeError(id)
+				return header{}, nil, err
+			}
+		}
+		r := c.pending[0]
+		c.pending = c.pending[1:]
+		if r.h.flags&flagStream != 0 && r.h.streamOp != streamOpen {
+			c.handleStream(r)
+			continue
+		}
+		return r.h, r.pk, nil
+	}
+}
+
+// received is a frame received. It holds either a packet or, for stream operations other than
+// streamOpen, the data of the operation.
+type received struct {
+	h    header
+	pk   packet.Packet
+	data []byte
+}
+
+// decodeFrame decodes all frames in a message, which holds more than one frame if it is a batch.
+func (c *Conn) decodeFrame(msg []byte) ([]received, error) {
+	h, body, err := decodeHeader(msg)
+	if err == nil && h.flags&flagCompressed != 0 {
+		body, err = c.decompress(body)
+	}
+	if err != nil {
+		return nil, &DecodeError{Err: err}
+	}
+	if h.flags&flagBatch == 0 {
+		r, err := c.decodeBody(h, body, len(msg))
+		if err != nil {
+			return nil, err
+		}
+		return []received{r}, nil
+	}
+
+	entries, err := decodeBatch(body)
+	if err != nil {
+		return nil, &DecodeError{Err: err}
+	}
+	frames := make([]received, 0, len(entries))
+	for _, entry := range entries {
+		eh, body, err := decodeHeader(entry)
+		if err == nil && eh.flags&(flagBatch|flagCompressed) != 0 {
+			err = errors.New("batch entry may not be compressed or a batch")
+		}
+		if err != nil {
+			return nil, &DecodeError{Err: err}
+		}
+		r, err := c.decodeBody(eh, body, len(entry))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, r)
+	}
+	return frames, nil
+}
+
+// decodeBody decodes the body of a single frame with the header passed. size is the size of the frame as
+// received, reported to the metrics.Collector.
+func (c *Conn) decodeBody(h header, body []byte, size int) (received, error) {
+	if h.flags&flagStream != 0 && h.streamOp != streamOpen {
+		return received{h: h, data: body}, nil
+	}
+	pk, err := c.pool.decode(body, c.zeroCopy)
+	if err != nil {
+		return received{}, err
+	}
+	c.metrics.PacketReceived(pk.ID(), size)
+	return received{h: h, pk: pk}, nil
+}
+
+// decompress decompresses the plain frame of a compressed frame using the compression algorithm
+// negotiated.
+func (c *Conn) decompress(body []byte) ([]byte, error) {
+	alg, _ := c.compressor()
+	if alg == nil {
+		return nil, errors.New("compressed frame received without compression enabled")
+	}
+	return alg.Decompress(body)
+}
+
+// context returns a context.Context for a packet received with the header passed. It is derived from the
+// Context of the Conn and carries the trace context and request ID of the header, if any.
+func (c *Conn) context(h header) context.Context {
+	ctx := c.ctx
+	if h.flags&flagTrace != 0 {
+		ctx = trace.ContextWithSpanContext(ctx, h.span)
+	}
+	if h.flags&flagRequest != 0 {
+		ctx = context.WithValue(ctx, requestKey{}, h.requestID)
+	}
+	return ctx
 }
 
 func (c *Conn) WritePacket(pk packet.Packet, close bool) error {
-	buf := internal.BufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		internal.BufferPool.Put(buf)
-	}()
+	return c.write(header{}, pk, close)
+}
+
+// WritePacketContext writes a packet to the connection. If ctx carries a trace context, it is sent along
+// with the packet.
+func (c *Conn) WritePacketContext(ctx context.Context, pk packet.Packet) error {
+	return c.write(c.header(ctx), pk, false)
+}
+
+// header returns a frame header carrying the trace context of ctx, if any and if the peer supports it.
+func (c *Conn) header(ctx context.Context) header {
+	var h header
+	if sc, ok := trace.FromContext(ctx); ok && c.supports(packet.CapabilityTracing) {
+		h.flags, h.span = flagTrace, sc
+	}
+	return h
+}
+
+// write writes a packet with the frame header passed to the connection. If close is true, the frame is
+// written as the payload of a close message.
+func (c *Conn) write(h header, pk packet.Packet, close bool) error {
+	f := encodeFrame(h, pk)
+	if !close {
+		return c.writeFrame(f)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// Queued packets are written first, so that the close message is the last message sent.
+	if err := c.flush(); err != nil {
+		return err
+	}
+	// The payload of a close message is not encrypted: its first bytes are read as the close code by the
+	// peer, which must remain valid.
+	msg := f.bytes()
+	if err := c.conn.WriteClose(msg); err != nil {
+		c.log.Debug("write close message", "packet", pk.ID(), "err", err)
+		return err
+	}
+	c.metrics.PacketSent(pk.ID(), len(msg))
+	return nil
+}
+
+// writeFrame writes a frame to the connection. If batching is enabled, the frame is queued and written
+// with the next batch instead. A frame written on a lane of its own is written without waiting for the
+// other writes to the connection.
+func (c *Conn) writeFrame(f frame) error {
+	if lanes, lane, last, ok := c.frameLane(f); ok {
+		return c.writeLane(lanes, lane, last, f)
+	}
+
+	c.metrics.WriteQueueChanged(1)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.metrics.WriteQueueChanged(-1)
 
-	writer := proto.NewWriter(buf, 1)
-	pk.Marshal(writer)
+	c.batchMu.Lock()
+	if c.batchLimit > 0 {
+		c.batch = append(c.batch, f)
+		c.batchSize += len(f.body)
+		full := c.batchSize >= c.batchLimit
+		c.batchMu.Unlock()
 
-	msg := append([]byte{byte(pk.ID())}, buf.Bytes()...)
-	if close {
-		if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
-			log.Println("Error writing close control message:", err)
-			return err
+		c.metrics.WriteQueueChanged(1)
+		if full {
+			return c.flush()
 		}
-	} else {
-		if err := c.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
-			log.Println("Error writing message:", err)
-			return err
+		return nil
+	}
+	c.batchMu.Unlock()
+	return c.writeFrames([]frame{f})
+}
+
+// frameLane returns the lane that a frame is written on, and whether it is the last frame on that lane. If
+// the connection carries messages on lanes, the frame of a request, a response or a stream opened by this
+// side is written on a lane of its own, which keeps it from waiting for other messages. False is returned
+// for all other frames, and if frames are batched or encrypted, as they must then be read in the order they
+// were written.
+func (c *Conn) frameLane(f frame) (laneConn, uint64, bool, bool) {
+	lanes, ok := c.conn.(laneConn)
+	if !ok {
+		return nil, 0, false, false
+	}
+	if write, _ := c.ciphers(); write != nil {
+		return nil, 0, false, false
+	}
+	c.batchMu.Lock()
+	batching := c.batchLimit > 0
+	c.batchMu.Unlock()
+	if batching {
+		return nil, 0, false, false
+	}
+	lane, last := c.lane(f.h)
+	return lanes, lane, last, lane != 0
+}
+
+// writeLane writes a frame on a lane of the connection. c.writeMu is not held, so that frames on different
+// lanes are written concurrently with each other and with the frames on lane 0.
+func (c *Conn) writeLane(lanes laneConn, lane uint64, last bool, f frame) error {
+	frames := []frame{f}
+	msg, err := c.message(frames)
+	if err != nil {
+		return err
+	}
+	return c.sent(frames, msg, lanes.WriteMessageLane(lane, msg, last))
+}
+
+// writeFrames writes the frames passed in a single message on lane 0. c.writeMu must be held.
+func (c *Conn) writeFrames(frames []frame) error {
+	msg, err := c.message(frames)
+	if err != nil {
+		return err
+	}
+	msg = c.encrypt(msg)
+	return c.sent(frames, msg, c.conn.WriteMessage(msg))
+}
+
+// message returns the message holding the frames passed, as a batch if more than one frame is passed. The
+// message is compressed if compression is enabled and it is large enough.
+func (c *Conn) message(frames []frame) ([]byte, error) {
+	f := frames[0]
+	if len(frames) > 1 {
+		f = frame{h: header{flags: flagBatch}, body: encodeBatch(frames)}
+	}
+	if alg, threshold := c.compressor(); alg != nil && len(f.body) >= threshold {
+		compressed, err := alg.Compress(f.body)
+		if err != nil {
+			return nil, err
 		}
+		f.h.flags |= flagCompressed
+		f.body, f.msg = compressed, nil
 	}
+	return f.bytes(), nil
+}
 
+// sent reports the packets of the frames passed as sent in the message passed if the error returned by
+// writing it is nil, and logs the error otherwise. The error passed is returned.
+func (c *Conn) sent(frames []frame, msg []byte, err error) error {
+	if err != nil {
+		c.log.Debug("write packet", "packet", frames[0].id, "packets", len(frames), "err", err)
+		return err
+	}
+	if len(frames) == 1 {
+		if !frames[0].data {
+			c.metrics.PacketSent(frames[0].id, len(msg))
+		}
+		return nil
+	}
+	for _, f := range frames {
+		if !f.data {
+			c.metrics.PacketSent(f.id, len(f.body))
+		}
+	}
 	return nil
 }
+
+// lane returns the lane that a frame with the header passed is written on, and whether it is the last frame
+// on that lane. Requests and responses get a lane for each request, and streams opened by this side a lane
+// for each stream, which ends with the frame closing or resetting the stream. Other frames use lane 0.
+func (c *Conn) lane(h header) (uint64, bool) {
+	switch {
+	case h.flags&flagStream != 0:
+		if (h.streamID%2 == 1) != c.client {
+			// Frames of streams opened by the peer, which only grow or reset them, are left on lane 0.
+			return 0, false
+		}
+		return 3<<32 | uint64(h.streamID), h.streamOp == streamClose || h.streamOp == streamReset
+	case h.flags&flagRequest != 0:
+		return 1<<32 | uint64(h.requestID), true
+	case h.flags&flagResponse != 0:
+		return 2<<32 | uint64(h.requestID), true
+	}
+	return 0, false
+}
+
+// encrypt encrypts a message to be written if encryption is enabled, and returns it as-is otherwise.
+// c.writeMu must be held, so that messages are written in the order of their counters.
+func (c *Conn) encrypt(msg []byte) []byte {
+	if write, _ := c.ciphers(); write != nil {
+		return write.seal(msg)
+	}
+	return msg
+}
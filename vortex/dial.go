@@ -0,0 +1,258 @@
+package vortex
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/metrics"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// Dialer allows connecting to a Vortex service and logging in to it.
+type Dialer struct {
+	// Logger is used to log events and errors of the connection. Every line logged carries the service
+	// name logged in with. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Metrics is the metrics.Collector that metrics of the connection are reported to. If nil, metrics are
+	// discarded.
+	Metrics metrics.Collector
+	// Packets holds the packets that the service may send to the connection. Packets received that are
+	// not in Packets fail to decode. Logging in fails if any of them do not match the schema of the packet
+	// with the same ID that the service registered using Vortex.RegisterSentPackets.
+	Packets []packet.Packet
+	// SentPackets holds the packets that the connection sends to the service. Their schema is sent to the
+	// service when logging in, and the login fails if any of them do not match the packet with the same ID
+	// registered with the service. Packets not in SentPackets may still be sent, but are not checked.
+	SentPackets []packet.Packet
+	// StreamHandler handles streams opened by the service using Conn.OpenStream. If nil, streams opened by
+	// the service are reset.
+	StreamHandler StreamHandler
+	// Compression holds the compression algorithms supported, in order of preference. If the service
+	// supports one of them, frames of at least CompressionThreshold bytes are compressed with it after
+	// logging in. If empty, frames are not compressed.
+	Compression []packet.Compression
+	// CompressionThreshold is the size in bytes from which frames written are compressed. If 0,
+	// DefaultCompressionThreshold is used.
+	CompressionThreshold int
+	// Encryption holds the encryption algorithms supported, in order of preference. If the service
+	// supports one of them, every frame sent by either side after logging in is encrypted with it, using
+	// keys derived from an ephemeral X25519 key exchange that is bound to the token passed to Dial. The
+	// token itself is not sent, but proven with an HMAC over the public key, and the service proves its
+	// public key in turn, so parties observing or relaying the login, such as a proxy terminating TLS,
+	// learn neither the token nor the keys. Packets written as the payload of a close message are not
+	// encrypted. Conn.Encrypted reports if encryption was agreed on. If empty, frames are not encrypted and
+	// the token is sent in clear text.
+	Encryption []packet.Encryption
+	// BatchDelay enables coalescing the packets written after logging in into batches, which are written
+	// as a single message every BatchDelay, or as soon as at least BatchSize bytes of packets are queued.
+	// If 0, every packet is written immediately in a message of its own.
+	BatchDelay time.Duration
+	// BatchSize is the size in bytes of the packets queued at which a batch is written immediately. If 0,
+	// DefaultBatchSize is used.
+	BatchSize int
+	// ZeroCopyDecoding enables zero-copy decoding of the packets received. The strings and byte slices of
+	// packets decoded alias the message that the packet was received in, and may only be used until the
+	// next call of ReadPacket, or until the StreamHandler returns for packets opening a stream. They must be
+	// copied, for example using proto.Clone, to keep them beyond that.
+	ZeroCopyDecoding bool
+	// Transport is the Transport that the connection is dialed with. If nil, a websocket connection is
+	// dialed. Header, Subprotocols, ReadBufferSize, WriteBufferSize and EnableWebsocketCompression only
+	// apply to websocket connections dialed without a Transport.
+	Transport Transport
+	// Header holds the headers sent with the websocket upgrade request, such as those required by the
+	// service or an Origin.
+	Header http.Header
+	// Subprotocols holds the websocket subprotocols offered to the service, such as "vortex.v1", in order
+	// of preference. Conn.Subprotocol returns the one negotiated.
+	Subprotocols []string
+	// ReadBufferSize and WriteBufferSize are the sizes in bytes of the read and write buffers of the
+	// websocket connection. If 0, 4096 bytes are used.
+	ReadBufferSize, WriteBufferSize int
+	// ReadLimit is the maximum size in bytes of the messages that the connection accepts. Messages larger
+	// than the limit are refused before being read into memory, and the connection is closed. If 0, the
+	// size of websocket messages is not limited, while messages of TCPTransport, UnixTransport and
+	// QUICTransport are limited to DefaultSocketReadLimit.
+	ReadLimit int64
+	// EnableWebsocketCompression enables negotiating the websocket permessage-deflate extension with the
+	// service.
+	EnableWebsocketCompression bool
+}
+
+// Dial dials a Vortex service at the websocket URL passed, such as "ws://localhost:8080/ws", and logs in
+// with the service name and token passed using a zero Dialer.
+func Dial(addr, service, token string) (*Conn, error) {
+	return Dialer{}.Dial(addr, service, token)
+}
+
+// Dial dials a Vortex service at the address passed and logs in with the service name and token passed.
+// The address is a websocket URL, or an address in the format of d.Transport if set. A *LoginError is
+// returned if the service rejected the login.
+func (d Dialer) Dial(addr, service, token string) (*Conn, error) {
+	return d.DialContext(context.Background(), addr, service, token)
+}
+
+// DialContext dials a Vortex service at the address passed, similarly to Dial, and logs in with the service
+// name and token passed. The context passed is used for dialing and logging in, and a *LoginError is
+// returned if the service rejected the login. If packets in d.SentPackets do not match the schema of the
+// packets with the same IDs registered with the service, or packets in d.Packets do not match those the
+// service sends, a *SchemaError is returned instead.
+func (d Dialer) DialContext(ctx context.Context, addr, service, token string) (*Conn, error) {
+	log := d.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	m := d.Metrics
+	if m == nil {
+		m = metrics.Nop{}
+	}
+	conn, err := d.dial(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %v: %w", addr, err)
+	}
+	if d.ReadLimit > 0 {
+		conn.SetReadLimit(d.ReadLimit)
+	}
+	c := newConn(conn, log.With("service", service), m, newPool(append([]packet.Packet{&packet.AuthResponse{}, &packet.Throttle{}, &packet.Error{}}, d.Packets...)...))
+	c.client, c.streamHandler, c.zeroCopy = true, d.StreamHandler, d.ZeroCopyDecoding
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	login := &packet.Login{
+		Service:      service,
+		Token:        token,
+		Protocol:     packet.CurrentProtocol,
+		Capabilities: packet.Capabilities,
+		Schema:       newPool(d.SentPackets...).schema(),
+	}
+	for _, alg := range d.Compression {
+		login.Compression = append(login.Compression, alg.EncodeCompression())
+	}
+	var key *ecdh.PrivateKey
+	if len(d.Encryption) > 0 {
+		if key, err = newEncryptionKey(); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		login.PublicKey = key.PublicKey().Bytes()
+		for _, alg := range d.Encryption {
+			login.Encryption = append(login.Encryption, alg.EncodeEncryption())
+		}
+		// The token is proven rather than sent, as the login is never encrypted.
+		login.Token, login.TokenProof = "", tokenProof(token, login.PublicKey, login.Encryption)
+	}
+	if err := c.WritePacket(login, false); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	pk, err := c.ReadPacket()
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	resp, ok := pk.(*packet.AuthResponse)
+	if !ok {
+		_ = c.Close()
+		return nil, fmt.Errorf("expected auth response, got packet %v", pk.ID())
+	}
+	m.Login(resp.Code)
+	if resp.Code != packet.AuthResponseSuccess {
+		_ = c.Close()
+		if resp.Code == packet.AuthResponseIncompatibleSchema {
+			if err := newPool(d.SentPackets...).checkSchema(resp.Schema); err != nil {
+				return nil, err
+			}
+		}
+		return nil, &LoginError{Code: resp.Code, Protocol: resp.Protocol}
+	}
+	if err := c.pool.checkSchema(resp.Schema); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	c.login(service, resp.Protocol, resp.Capabilities&packet.Capabilities)
+
+	if resp.Compression != packet.CompressionAlgorithmNone && c.supports(packet.CapabilityCompression) {
+		alg, ok := d.compression(resp.Compression)
+		if !ok {
+			_ = c.Close()
+			return nil, fmt.Errorf("service picked compression algorithm %v that was not offered", resp.Compression)
+		}
+		threshold := d.CompressionThreshold
+		if threshold == 0 {
+			threshold = DefaultCompressionThreshold
+		}
+		c.enableCompression(alg, threshold)
+	}
+
+	if resp.Encryption != packet.EncryptionAlgorithmNone && c.supports(packet.CapabilityEncryption) {
+		alg, ok := d.encryption(resp.Encryption)
+		if !ok || key == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("service picked encryption algorithm %v that was not offered", resp.Encryption)
+		}
+		if !hmac.Equal(resp.KeyProof, keyProof(token, login.PublicKey, resp.PublicKey, resp.Encryption)) {
+			_ = c.Close()
+			return nil, fmt.Errorf("encryption: %w", errKeyProof)
+		}
+		write, read, err := deriveCiphers(alg, key, resp.PublicKey, login.PublicKey, resp.PublicKey, token, true)
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("encryption: %w", err)
+		}
+		c.enableEncryption(write, read)
+	}
+
+	if d.BatchDelay > 0 && c.supports(packet.CapabilityBatching) {
+		c.startBatching(d.BatchDelay, d.BatchSize)
+	}
+	c.log.Debug("logged in", "protocol", resp.Protocol, "capabilities", c.Capabilities(), "compression", resp.Compression, "encryption", resp.Encryption)
+	return c, nil
+}
+
+// dial dials a connection to the address passed using d.Transport, or a websocket connection if d.Transport
+// is nil.
+func (d Dialer) dial(ctx context.Context, addr string) (MessageConn, error) {
+	if d.Transport != nil {
+		return d.Transport.Dial(ctx, addr)
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = d.EnableWebsocketCompression
+	dialer.Subprotocols = d.Subprotocols
+	dialer.ReadBufferSize, dialer.WriteBufferSize = d.ReadBufferSize, d.WriteBufferSize
+	ws, resp, err := dialer.DialContext(ctx, addr, d.Header)
+	if err != nil {
+		if resp != nil {
+			// The service refused the upgrade, such as with 503 Service Unavailable if it is draining.
+			return nil, fmt.Errorf("%w: %v", err, resp.Status)
+		}
+		return nil, err
+	}
+	return websocketConn{ws}, nil
+}
+
+// encryption returns the encryption algorithm in d.Encryption with the ID passed.
+func (d Dialer) encryption(id uint16) (packet.Encryption, bool) {
+	for _, alg := range d.Encryption {
+		if alg.EncodeEncryption() == id {
+			return alg, true
+		}
+	}
+	return nil, false
+}
+
+// compression returns the compression algorithm in d.Compression with the ID passed.
+func (d Dialer) compression(id uint16) (packet.Compression, bool) {
+	for _, alg := range d.Compression {
+		if alg.EncodeCompression() == id {
+			return alg, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,158 @@
+package vortex
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+	"golang.org/x/crypto/hkdf"
+)
+
+// clientKeyInfo and serviceKeyInfo are the HKDF info strings of the keys derived for every direction.
+const (
+	clientKeyInfo  = "vortex client to service"
+	serviceKeyInfo = "vortex service to client"
+)
+
+// tokenProofLabel and keyProofLabel are the labels of the HMACs sent in packet.Login and packet.AuthResponse,
+// and saltLabel that of the HMAC used as HKDF salt, so that one may never be used as another.
+const (
+	tokenProofLabel = "vortex token proof"
+	keyProofLabel   = "vortex key proof"
+	saltLabel       = "vortex salt"
+)
+
+// errKeyProof is returned by Dial if the KeyProof of packet.AuthResponse does not prove that the public key
+// of the service was sent by a party knowing the token.
+var errKeyProof = errors.New("public key of the service not proven by the token")
+
+// tokenProof returns the TokenProof of a packet.Login offering the encryption algorithms passed with the
+// public key passed.
+func tokenProof(token string, publicKey []byte, algorithms []uint16) []byte {
+	return proof(token, tokenProofLabel, publicKey, algorithmIDs(algorithms...))
+}
+
+// keyProof returns the KeyProof of a packet.AuthResponse picking the encryption algorithm passed.
+func keyProof(token string, clientKey, serviceKey []byte, alg uint16) []byte {
+	return proof(token, keyProofLabel, clientKey, serviceKey, algorithmIDs(alg))
+}
+
+// proof returns an HMAC-SHA256 keyed with the token over the label and the data passed. Every piece of data
+// is prefixed with its length, so that different pieces can never lead to the same proof.
+func proof(token, label string, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(label))
+	for _, d := range data {
+		mac.Write(binary.AppendUvarint(nil, uint64(len(d))))
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// algorithmIDs encodes the IDs of encryption algorithms passed as big endian uint16s.
+func algorithmIDs(ids ...uint16) []byte {
+	b := make([]byte, 0, 2*len(ids))
+	for _, id := range ids {
+		b = binary.BigEndian.AppendUint16(b, id)
+	}
+	return b
+}
+
+// newEncryptionKey generates an ephemeral X25519 key for the key exchange of a connection.
+func newEncryptionKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// deriveCiphers performs the X25519 key exchange of a connection using the private key passed and the public
+// key of the peer, and derives the ciphers for both directions from the shared secret. clientKey and
+// serviceKey are the public keys sent in packet.Login and packet.AuthResponse. The token of the service is
+// mixed into the HKDF salt, so that only parties knowing it derive the same keys, even if they took part in
+// the key exchange. The cipher of the client is returned as write if client is true, and as read otherwise.
+func deriveCiphers(alg packet.Encryption, key *ecdh.PrivateKey, peer, clientKey, serviceKey []byte, token string, client bool) (write, read *cipherState, err error) {
+	pub, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("peer public key: %w", err)
+	}
+	secret, err := key.ECDH(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key exchange: %w", err)
+	}
+	salt := proof(token, saltLabel, clientKey, serviceKey)
+	newCipher := func(info string) (*cipherState, error) {
+		k := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), k); err != nil {
+			return nil, err
+		}
+		aead, err := alg.NewAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		if aead.NonceSize() != 12 {
+			return nil, fmt.Errorf("encryption algorithm %v: nonce size %v is not 12", alg.EncodeEncryption(), aead.NonceSize())
+		}
+		return &cipherState{aead: aead}, nil
+	}
+	clientCipher, err := newCipher(clientKeyInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	serviceCipher, err := newCipher(serviceKeyInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client {
+		return clientCipher, serviceCipher, nil
+	}
+	return serviceCipher, clientCipher, nil
+}
+
+// cipherState encrypts or decrypts the messages sent in one direction of a connection. An encrypted message
+// holds the counter of the message as a varuint64, followed by the message sealed with the cipher. The
+// nonce is the counter as a big endian uint64, prefixed with 4 zero bytes. Every direction of a connection
+// has a key and counter of its own, so nonces are never reused with the same key. As websocket messages
+// arrive in order, the counter of a message received must always follow that of the previous one.
+type cipherState struct {
+	aead    cipher.AEAD
+	counter uint64
+}
+
+// nonce returns the nonce of the message with the counter passed.
+func nonce(counter uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+// seal encrypts the next message to be sent.
+func (s *cipherState) seal(msg []byte) []byte {
+	out := make([]byte, 0, binary.MaxVarintLen64+len(msg)+s.aead.Overhead())
+	out = binary.AppendUvarint(out, s.counter)
+	out = s.aead.Seal(out, nonce(s.counter), msg, nil)
+	s.counter++
+	return out
+}
+
+// open decrypts the next message received. The message is decrypted in place. A *FrameOrderError is
+// returned if the counter of the message is not the one expected.
+func (s *cipherState) open(msg []byte) ([]byte, error) {
+	counter, n := binary.Uvarint(msg)
+	if n <= 0 {
+		return nil, errors.New("encrypted frame: invalid counter")
+	}
+	if counter != s.counter {
+		return nil, &FrameOrderError{Counter: counter, Expected: s.counter}
+	}
+	plain, err := s.aead.Open(msg[n:n], nonce(counter), msg[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted frame %v: %w", counter, err)
+	}
+	s.counter++
+	return plain, nil
+}
@@ -0,0 +1,215 @@
+package vortex
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/metrics"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// echoHandler is a Handler that replies to every request with the packet requested, and passes the data
+// read from every stream opened by a peer to streams.
+type echoHandler struct {
+	streams chan []byte
+}
+
+// HandlePacket ...
+func (echoHandler) HandlePacket(*Conn, packet.Packet) {}
+
+// HandlePacketContext ...
+func (echoHandler) HandlePacketContext(ctx context.Context, conn *Conn, pk packet.Packet) {
+	_ = conn.Reply(ctx, pk)
+}
+
+// HandleStream ...
+func (h echoHandler) HandleStream(_ *Conn, _ packet.Packet, r io.Reader) {
+	data, _ := io.ReadAll(r)
+	h.streams <- data
+}
+
+// newTestKey generates an encryption key or fails the test.
+func newTestKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := newEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestDeriveCiphers checks that a client and a service derive matching ciphers only if both know the same
+// token and took part in the same key exchange.
+func TestDeriveCiphers(t *testing.T) {
+	client, service, other := newTestKey(t), newTestKey(t), newTestKey(t)
+	clientKey, serviceKey := client.PublicKey().Bytes(), service.PublicKey().Bytes()
+
+	for _, tc := range []struct {
+		name string
+		// peer, clientKey and token are those the service derives its ciphers with.
+		peer, clientKey []byte
+		token           string
+		match           bool
+	}{
+		{"matching", clientKey, clientKey, "token", true},
+		{"mismatched token", clientKey, clientKey, "other", false},
+		{"mismatched key", other.PublicKey().Bytes(), other.PublicKey().Bytes(), "token", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			write, _, err := deriveCiphers(packet.AESGCMEncryption, client, serviceKey, clientKey, serviceKey, "token", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, read, err := deriveCiphers(packet.AESGCMEncryption, service, tc.peer, tc.clientKey, serviceKey, tc.token, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := read.open(write.seal([]byte("frame")))
+			if tc.match && (err != nil || string(plain) != "frame") {
+				t.Errorf("opened %q with error %v, want %q", plain, err, "frame")
+			} else if !tc.match && err == nil {
+				t.Error("frame sealed with the ciphers of the client opened by the service")
+			}
+		})
+	}
+}
+
+// TestProofs checks that the proofs of Login and AuthResponse change with every input they cover.
+func TestProofs(t *testing.T) {
+	a, b := newTestKey(t).PublicKey().Bytes(), newTestKey(t).PublicKey().Bytes()
+	algs := []uint16{packet.EncryptionAlgorithmAESGCM, packet.EncryptionAlgorithmChaCha20Poly1305}
+
+	proof := tokenProof("token", a, algs)
+	for name, other := range map[string][]byte{
+		"token":      tokenProof("other", a, algs),
+		"public key": tokenProof("token", b, algs),
+		"algorithms": tokenProof("token", a, algs[:1]),
+		"key proof":  keyProof("token", a, nil, algs[0]),
+	} {
+		if bytes.Equal(proof, other) {
+			t.Errorf("token proof does not depend on the %v", name)
+		}
+	}
+
+	proof = keyProof("token", a, b, algs[0])
+	for name, other := range map[string][]byte{
+		"token":       keyProof("other", a, b, algs[0]),
+		"client key":  keyProof("token", b, b, algs[0]),
+		"service key": keyProof("token", a, a, algs[0]),
+		"algorithm":   keyProof("token", a, b, algs[1]),
+	} {
+		if bytes.Equal(proof, other) {
+			t.Errorf("key proof does not depend on the %v", name)
+		}
+	}
+}
+
+// TestEncryptedLoginProof checks that the service only accepts logins offering encryption with a TokenProof
+// of its token over the public key sent, and proves its own public key in the AuthResponse.
+func TestEncryptedLoginProof(t *testing.T) {
+	v := NewService("test", auth.Auth{Token: "token"}, WithLogger(testLogger()), WithEncryption(packet.AESGCMEncryption))
+	url := serveTest(t, v)
+	key, other := newTestKey(t).PublicKey().Bytes(), newTestKey(t).PublicKey().Bytes()
+	algs := []uint16{packet.EncryptionAlgorithmAESGCM}
+
+	for _, tc := range []struct {
+		name  string
+		login packet.Login
+		code  uint32
+	}{
+		{"proof", packet.Login{PublicKey: key, TokenProof: tokenProof("token", key, algs)}, packet.AuthResponseSuccess},
+		{"token instead of proof", packet.Login{Token: "token", PublicKey: key}, packet.AuthResponseInvalidToken},
+		{"wrong token", packet.Login{PublicKey: key, TokenProof: tokenProof("other", key, algs)}, packet.AuthResponseInvalidToken},
+		{"replaced public key", packet.Login{PublicKey: other, TokenProof: tokenProof("token", key, algs)}, packet.AuthResponseInvalidToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pk := tc.login
+			pk.Service, pk.Protocol, pk.Capabilities, pk.Encryption = "peer", packet.CurrentProtocol, packet.Capabilities, algs
+			resp := login(t, url, &pk)
+			if resp.Code != tc.code {
+				t.Fatalf("got code %v, want %v", resp.Code, tc.code)
+			}
+			if tc.code == packet.AuthResponseSuccess && !bytes.Equal(resp.KeyProof, keyProof("token", key, resp.PublicKey, resp.Encryption)) {
+				t.Error("public key of the service not proven")
+			}
+		})
+	}
+}
+
+// TestEncryption checks that packets are exchanged encrypted with every algorithm after a Dialer logs in
+// with the token of the service, and that logging in with another token fails.
+func TestEncryption(t *testing.T) {
+	for _, alg := range []packet.Encryption{packet.AESGCMEncryption, packet.ChaCha20Poly1305Encryption} {
+		v := NewService("test", auth.Auth{Token: "token"}, WithLogger(testLogger()), WithEncryption(alg))
+		v.RegisterPackets(&payload{})
+		v.RegisterSentPackets(&payload{})
+		v.RegisterHandler(echoHandler{})
+		url := serveTest(t, v)
+		d := Dialer{Logger: testLogger(), Encryption: []packet.Encryption{alg}, Packets: []packet.Packet{&payload{}}}
+
+		c, err := d.Dial(url, "peer", "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				if _, err := c.ReadPacket(); err != nil {
+					return
+				}
+			}
+		}()
+		if !c.Encrypted() {
+			t.Errorf("algorithm %v: not encrypted", alg.EncodeEncryption())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		resp, err := c.Request(ctx, &payload{Data: []byte("encrypted")})
+		cancel()
+		if err != nil || string(resp.(*payload).Data) != "encrypted" {
+			t.Errorf("algorithm %v: got response %#v with error %v", alg.EncodeEncryption(), resp, err)
+		}
+		_ = c.Close()
+
+		var loginErr *LoginError
+		if _, err := d.Dial(url, "peer", "other"); !errors.As(err, &loginErr) || loginErr.Code != packet.AuthResponseInvalidToken {
+			t.Errorf("algorithm %v: dial with another token: got error %v, want invalid token", alg.EncodeEncryption(), err)
+		}
+	}
+}
+
+// TestEncryptionTokenNotSent checks that a Dialer offering encryption sends a proof of its token instead
+// of the token itself.
+func TestEncryptionTokenNotSent(t *testing.T) {
+	logins := make(chan *packet.Login, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Upgrade(w, r, nil, 0, 0)
+		if err != nil {
+			return
+		}
+		c := newConn(websocketConn{ws}, testLogger(), metrics.Nop{}, newPool(&packet.Login{}))
+		defer c.Close()
+		pk, _ := c.ReadPacket()
+		l, _ := pk.(*packet.Login)
+		logins <- l
+	}))
+	defer srv.Close()
+
+	d := Dialer{Logger: testLogger(), Encryption: []packet.Encryption{packet.AESGCMEncryption}}
+	_, _ = d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "peer", "secret-token")
+	l := <-logins
+	if l == nil {
+		t.Fatal("no login read")
+	}
+	if l.Token != "" || !bytes.Equal(l.TokenProof, tokenProof("secret-token", l.PublicKey, l.Encryption)) {
+		t.Errorf("got token %q and proof %x, want only a proof", l.Token, l.TokenProof)
+	}
+}
@@ -0,0 +1,135 @@
+package vortex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// errNotLoggedIn is passed to an ErrorHandler when a peer sends a packet other than packet.Login before it
+// has logged in.
+var errNotLoggedIn = errors.New("packet received before login")
+
+// errAlreadyLoggedIn is passed to an ErrorHandler when a peer that has already logged in sends another
+// packet.Login.
+var errAlreadyLoggedIn = errors.New("login received from connection that is already logged in")
+
+// CloseError is passed as reason to a DisconnectHandler if the connection was closed with a websocket close
+// message. Code holds the close code sent by the peer.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+// Error ...
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("connection closed with code %v: %v", e.Code, e.Text)
+}
+
+// DecodeError is returned when a message received could not be decoded into a packet. The connection is
+// closed when a DecodeError occurs.
+type DecodeError struct {
+	PacketID uint32
+	Err      error
+}
+
+// Error ...
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode packet %v: %v", e.PacketID, e.Err)
+}
+
+// Unwrap ...
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// FrameOrderError is the Err of a DecodeError if an encrypted frame was received with another counter than
+// the one expected. A counter lower than expected means the frame was replayed, and a higher one that frames
+// were reordered or dropped.
+type FrameOrderError struct {
+	Counter, Expected uint64
+}
+
+// Error ...
+func (e *FrameOrderError) Error() string {
+	if e.Counter < e.Expected {
+		return fmt.Sprintf("replayed encrypted frame: counter %v, expected %v", e.Counter, e.Expected)
+	}
+	return fmt.Sprintf("reordered encrypted frame: counter %v, expected %v", e.Counter, e.Expected)
+}
+
+// RateLimitError is passed as reason to a DisconnectHandler if the connection was closed because it sent a
+// packet exceeding a RateLimit with the RateLimitDisconnect action. Scope is the limit of RateLimits that
+// was exceeded: "packet", "connection", "identity" or "global".
+type RateLimitError struct {
+	PacketID uint32
+	Scope    string
+}
+
+// Error ...
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("packet %v exceeded %v rate limit", e.PacketID, e.Scope)
+}
+
+// LoginError is passed as reason to a DisconnectHandler if the peer was disconnected because its login was
+// rejected, and returned by a Dialer if the service rejected the login. Code is one of the
+// packet.AuthResponse codes.
+type LoginError struct {
+	Code uint32
+	// Protocol is the protocol version of the service. It is only set for errors returned by a Dialer.
+	Protocol uint32
+}
+
+// Error ...
+func (e *LoginError) Error() string {
+	if e.Code == packet.AuthResponseIncompatibleProtocol && e.Protocol != 0 {
+		return fmt.Sprintf("login rejected: incompatible protocol: service implements %v, connection %v", e.Protocol, packet.CurrentProtocol)
+	}
+	return fmt.Sprintf("login rejected with code %v", e.Code)
+}
+
+// SchemaError is returned by a Dialer, and passed as reason to a DisconnectHandler, if packets registered
+// on both sides of a connection with the same ID have a different schema.
+type SchemaError struct {
+	Mismatches []SchemaMismatch
+}
+
+// SchemaMismatch is a packet of which the schema does not match the schema of the peer.
+type SchemaMismatch struct {
+	// Local is the schema of the packet on this side of the connection, and Remote its schema on the side
+	// of the peer.
+	Local, Remote packet.Schema
+}
+
+// Error ...
+func (e *SchemaError) Error() string {
+	b := new(strings.Builder)
+	b.WriteString("incompatible packet schema: ")
+	for i, m := range e.Mismatches {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		_, _ = fmt.Fprintf(b, "%v (packet %v", m.Local.Name, m.Local.PacketID)
+		if m.Remote.Name != m.Local.Name {
+			_, _ = fmt.Fprintf(b, ", %v on peer", m.Remote.Name)
+		}
+		if m.Local.Version != m.Remote.Version {
+			_, _ = fmt.Fprintf(b, "): version %v, peer version %v", m.Local.Version, m.Remote.Version)
+		} else {
+			b.WriteString("): fields differ")
+		}
+	}
+	return b.String()
+}
+
+// StreamResetError is returned when reading from or writing to a stream that was reset by the peer.
+type StreamResetError struct {
+	Reason string
+}
+
+// Error ...
+func (e *StreamResetError) Error() string {
+	return "stream reset by peer: " + e.Reason
+}
@@ -0,0 +1,229 @@
+package vortex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+	"github.com/vortex-service/vortex/vortex/trace"
+)
+
+// A frame is a single websocket message. A plain frame holds the ID of a packet as a single byte, followed
+// by the encoded packet. A frame carrying extensions starts with frameExtended and a byte of flags, which
+// is followed by the fields of every extension set, in the order of the flags, and then by a plain frame.
+// Peers that do not send extensions only send plain frames, so frameExtended is never a packet ID.
+const frameExtended = byte(packet.IDReserved)
+
+const (
+	// flagTrace is set if the frame carries a W3C traceparent and tracestate, both written as strings.
+	flagTrace byte = 1 << iota
+	// flagRequest is set if the packet is a request that expects a response. The ID of the request is
+	// written as a varuint32.
+	flagRequest
+	// flagResponse is set if the packet is a response to a request. The ID of the request responded to is
+	// written as a varuint32.
+	flagResponse
+	// flagCompressed is set if the plain frame following the header is compressed with the compression
+	// algorithm negotiated during login.
+	flagCompressed
+	// flagBatch is set if the frame is a batch of frames instead of a plain frame. A batch holds the number
+	// of frames as a varuint32, followed by every frame prefixed with its length as a varuint32. The frames
+	// in a batch may carry extensions of their own, but may not be compressed or be a batch themselves.
+	flagBatch
+	// flagStream is set if the frame belongs to a stream. The ID of the stream is written as a varuint32,
+	// followed by the stream operation as a byte. Only frames with streamOpen hold a plain frame: the
+	// frames of other operations hold the data of the operation instead.
+	flagStream
+)
+
+const (
+	// streamOpen opens a stream. The frame holds the packet the stream was opened with.
+	streamOpen byte = iota
+	// streamData holds a chunk of the data of a stream.
+	streamData
+	// streamWindow grows the window of a stream by the number of bytes written as a varuint32.
+	streamWindow
+	// streamClose closes a stream after all of its data was sent.
+	streamClose
+	// streamReset aborts a stream. The frame holds the reason as a string.
+	streamReset
+)
+
+// frame is a frame to be written, consisting of its header and its plain frame.
+type frame struct {
+	h    header
+	id   uint32
+	body []byte
+	// msg holds the encoded frame if it was already encoded, of which body is the tail.
+	msg []byte
+	// data is true if body holds the data of a stream operation rather than a plain frame.
+	data bool
+}
+
+// encodeFrame encodes a packet into a frame with the header passed. The size of the packet is computed
+// before encoding it, so that the header, the packet ID and the packet are all encoded into a single buffer
+// of the exact size needed, which is then written without copying it again.
+func encodeFrame(h header, pk packet.Packet) frame {
+	size := h.size()
+	msg := h.append(make([]byte, 0, size+1+proto.Size(pk, 1)))
+	msg = append(msg, byte(pk.ID()))
+
+	// The buffer writes into msg, which has enough capacity left for the packet.
+	buf := bytes.NewBuffer(msg)
+	pk.Marshal(proto.NewWriter(buf, 1))
+	msg = buf.Bytes()
+
+	return frame{h: h, id: pk.ID(), body: msg[size:], msg: msg}
+}
+
+// append appends the encoded frame to b and returns the result.
+func (f frame) append(b []byte) []byte {
+	return append(f.h.append(b), f.body...)
+}
+
+// bytes returns the encoded frame. Frames produced by encodeFrame are already encoded and returned as-is.
+func (f frame) bytes() []byte {
+	if f.msg != nil {
+		return f.msg
+	}
+	return f.append(nil)
+}
+
+// encodeBatch encodes the frames passed into the body of a batch frame.
+func encodeBatch(frames []frame) []byte {
+	entries := make([][]byte, len(frames))
+	size := 5
+	for i, f := range frames {
+		entries[i] = f.bytes()
+		size += 5 + len(entries[i])
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	_ = proto.WriteVaruint32(buf, uint32(len(frames)))
+	for _, entry := range entries {
+		_ = proto.WriteVaruint32(buf, uint32(len(entry)))
+		buf.Write(entry)
+	}
+	return buf.Bytes()
+}
+
+// decodeBatch decodes the body of a batch frame into the frames it holds.
+func decodeBatch(body []byte) ([][]byte, error) {
+	buf := bytes.NewReader(body)
+	var count uint32
+	if err := proto.Varuint32(buf, &count); err != nil {
+		return nil, fmt.Errorf("batch: read count: %w", err)
+	}
+	if int(count) > len(body) {
+		return nil, fmt.Errorf("batch: count %v exceeds size of %v bytes", count, len(body))
+	}
+	frames := make([][]byte, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var l uint32
+		if err := proto.Varuint32(buf, &l); err != nil {
+			return nil, fmt.Errorf("batch: read length of frame %v: %w", i, err)
+		}
+		if int(l) > buf.Len() {
+			return nil, fmt.Errorf("batch: length %v of frame %v exceeds remaining %v bytes", l, i, buf.Len())
+		}
+		off := len(body) - buf.Len()
+		frames = append(frames, body[off:off+int(l)])
+		_, _ = buf.Seek(int64(l), io.SeekCurrent)
+	}
+	return frames, nil
+}
+
+// header holds the extensions of a frame.
+type header struct {
+	flags     byte
+	span      trace.SpanContext
+	requestID uint32
+	streamID  uint32
+	streamOp  byte
+}
+
+// size returns the number of bytes that the header encodes to.
+func (h header) size() int {
+	if h.flags == 0 {
+		return 0
+	}
+	// The header starts with frameExtended and the flags.
+	s := proto.NewSizeCounter(1)
+	if h.flags&flagTrace != 0 {
+		parent := h.span.TraceParent()
+		s.String(&parent)
+		s.String(&h.span.State)
+	}
+	if h.flags&(flagRequest|flagResponse) != 0 {
+		s.Varuint32(&h.requestID)
+	}
+	if h.flags&flagStream != 0 {
+		s.Varuint32(&h.streamID)
+		s.Uint8(&h.streamOp)
+	}
+	return 2 + s.Size()
+}
+
+// append appends the encoded header to b and returns the result. Nothing is appended if no flags are set.
+func (h header) append(b []byte) []byte {
+	if h.flags == 0 {
+		return b
+	}
+	buf := bytes.NewBuffer(b)
+	buf.WriteByte(frameExtended)
+	buf.WriteByte(h.flags)
+	w := proto.NewWriter(buf, 1)
+	if h.flags&flagTrace != 0 {
+		parent := h.span.TraceParent()
+		w.String(&parent)
+		w.String(&h.span.State)
+	}
+	if h.flags&(flagRequest|flagResponse) != 0 {
+		w.Varuint32(&h.requestID)
+	}
+	if h.flags&flagStream != 0 {
+		w.Varuint32(&h.streamID)
+		w.Uint8(&h.streamOp)
+	}
+	return buf.Bytes()
+}
+
+// decodeHeader decodes the header of the frame passed and returns it with the plain frame that follows it.
+// Frames without extensions are returned as-is with an empty header.
+func decodeHeader(msg []byte) (h header, body []byte, err error) {
+	if len(msg) == 0 || msg[0] != frameExtended {
+		return h, msg, nil
+	}
+	if len(msg) < 2 {
+		return h, nil, errors.New("frame header: missing flags")
+	}
+	h.flags = msg[1]
+	buf := bytes.NewReader(msg[2:])
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("frame header: %v", r)
+		}
+	}()
+	r := proto.NewReader(buf, 1, false)
+	if h.flags&flagTrace != 0 {
+		var parent, state string
+		r.String(&parent)
+		r.String(&state)
+		if h.span, err = trace.Parse(parent, state); err != nil {
+			// An invalid trace context is dropped rather than failing the whole frame.
+			h.flags &^= flagTrace
+			err = nil
+		}
+	}
+	if h.flags&(flagRequest|flagResponse) != 0 {
+		r.Varuint32(&h.requestID)
+	}
+	if h.flags&flagStream != 0 {
+		r.Varuint32(&h.streamID)
+		r.Uint8(&h.streamOp)
+	}
+	return h, msg[len(msg)-buf.Len():], nil
+}
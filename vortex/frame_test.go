@@ -0,0 +1,85 @@
+package vortex
+
+import (
+	"testing"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// movement is a small packet of the kind sent at high rates, used by the benchmarks of the package.
+type movement struct {
+	EntityID uint64
+	X, Y, Z  float32
+	OnGround bool
+	Name     string
+}
+
+// ID ...
+func (m *movement) ID() uint32 {
+	return 101
+}
+
+// Marshal ...
+func (m *movement) Marshal(io proto.IO) {
+	io.Varuint64(&m.EntityID)
+	io.Float32(&m.X)
+	io.Float32(&m.Y)
+	io.Float32(&m.Z)
+	io.Bool(&m.OnGround)
+	io.String(&m.Name)
+}
+
+// BenchmarkEncodeFrame measures encoding a small and a large packet into a frame, which sizes the packet
+// using a proto.SizeCounter and encodes it into a single buffer of the exact size.
+func BenchmarkEncodeFrame(b *testing.B) {
+	b.Run("small", func(b *testing.B) {
+		pk := &movement{EntityID: 12345, X: 1.5, Y: 64, Z: -3.25, OnGround: true, Name: "player"}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encodeFrame(header{}, pk)
+		}
+	})
+	b.Run("small/request", func(b *testing.B) {
+		pk := &movement{EntityID: 12345, X: 1.5, Y: 64, Z: -3.25, OnGround: true, Name: "player"}
+		h := header{flags: flagRequest, requestID: 300}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encodeFrame(h, pk)
+		}
+	})
+	b.Run("large", func(b *testing.B) {
+		pk := &payload{Data: compressibleData(256 * 1024)}
+		b.SetBytes(int64(len(pk.Data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encodeFrame(header{}, pk)
+		}
+	})
+}
+
+// BenchmarkWritePacket measures writing a small and a large packet to a connection, from encoding the frame
+// to passing it to the MessageConn.
+func BenchmarkWritePacket(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		pk   interface {
+			ID() uint32
+			Marshal(proto.IO)
+		}
+		size int
+	}{
+		{"small", &movement{EntityID: 12345, X: 1.5, Y: 64, Z: -3.25, OnGround: true, Name: "player"}, 0},
+		{"large", &payload{Data: compressibleData(256 * 1024)}, 256 * 1024},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			c, _ := newTestConn()
+			b.SetBytes(int64(bc.size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := c.WritePacket(bc.pk, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
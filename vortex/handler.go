@@ -1,8 +1,12 @@
 package vortex
 
 import (
-	"fmt"
+	"context"
+	"crypto/hmac"
+	"io"
+	"strings"
 
+	"github.com/gorilla/websocket"
 	"github.com/vortex-service/vortex/vortex/proto/packet"
 )
 
@@ -10,21 +14,192 @@ type Handler interface {
 	HandlePacket(conn *Conn, pk packet.Packet)
 }
 
-func (s *Vortex) handleLogin(c *Conn, pk *packet.Login) {
-	resp := &packet.AuthResponse{}
-	var closed bool
-	if s.auth.Token == pk.Token {
-		resp.Code = packet.AuthResponseSuccess
-		closed = false
-		s.connsMu.Lock()
-		defer s.connsMu.Unlock()
-	} else {
-		resp.Code = packet.AuthResponseInvalidToken
-		closed = true
+// ContextHandler may be implemented by a Handler to receive a context.Context with every packet. If
+// implemented, HandlePacketContext is called instead of HandlePacket. ctx carries the trace context of the
+// packet and, if the packet is a request, may be passed to Conn.Reply to respond to it. It is cancelled
+// when the connection is closed. Packets are handled one at a time by the goroutine reading the connection,
+// so a handler that makes a request to the peer using Conn.Request must do so from a goroutine of its own.
+type ContextHandler interface {
+	HandlePacketContext(ctx context.Context, conn *Conn, pk packet.Packet)
+}
+
+// StreamHandler may be implemented by a Handler to accept streams opened by peers using Conn.OpenStream.
+// HandleStream is called in a goroutine of its own for every stream, with the packet the stream was opened
+// with. Reads from r block until data is received, and return io.EOF once the peer closed the stream or a
+// *StreamResetError if it was reset. If HandleStream returns before reading r to the end, the stream is
+// reset. Streams opened by peers are reset immediately if the Handler does not implement StreamHandler.
+type StreamHandler interface {
+	HandleStream(conn *Conn, meta packet.Packet, r io.Reader)
+}
+
+// ConnectHandler may be implemented by a Handler to be notified when a new connection is accepted. The peer
+// has not logged in yet when HandleConnect is called.
+type ConnectHandler interface {
+	HandleConnect(conn *Conn)
+}
+
+// LoginHandler may be implemented by a Handler to be notified when a peer has successfully logged in.
+// identity is the service name the peer authenticated with.
+type LoginHandler interface {
+	HandleLogin(conn *Conn, identity string)
+}
+
+// DisconnectHandler may be implemented by a Handler to be notified when a connection is closed. reason
+// holds the cause: a *CloseError if the peer closed the connection, a *DecodeError if a packet could not
+// be decoded, a *LoginError if the login was rejected or the network error that ended the connection,
+// such as a timeout.
+type DisconnectHandler interface {
+	HandleDisconnect(conn *Conn, reason error)
+}
+
+// ErrorHandler may be implemented by a Handler to be notified of errors that do not close the connection,
+// such as failing to write a response or receiving packets before login.
+type ErrorHandler interface {
+	HandleError(conn *Conn, err error)
+}
+
+func (v *Vortex) handleLogin(c *Conn, pk *packet.Login) error {
+	if c.LoggedIn() {
+		c.log.Warn("login received from connection that is already logged in", "identity", c.Identity())
+		v.handleError(c, errAlreadyLoggedIn)
+		return nil
 	}
+	if pk.Protocol < packet.MinimumProtocol || pk.Protocol > packet.CurrentProtocol {
+		c.log.Warn("login with incompatible protocol", "identity", pk.Service, "protocol", pk.Protocol)
+		return v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseIncompatibleProtocol})
+	}
+	if !v.validToken(pk) {
+		return v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseInvalidToken, Protocol: pk.Protocol})
+	}
+	if err := v.packets.checkSchema(pk.Schema); err != nil {
+		c.log.Warn("login with incompatible packet schema", "identity", pk.Service, "err", err)
+		_ = v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseIncompatibleSchema, Schema: v.packets.schema(), Protocol: pk.Protocol})
+		return err
+	}
+
+	resp := &packet.AuthResponse{
+		Code:         packet.AuthResponseSuccess,
+		Protocol:     pk.Protocol,
+		Capabilities: pk.Capabilities & packet.Capabilities,
+		Schema:       v.sent.schema(),
+	}
+	var alg packet.Compression
+	if resp.Capabilities&packet.CapabilityCompression != 0 {
+		if alg = v.negotiateCompression(pk.Compression); alg != nil {
+			resp.Compression = alg.EncodeCompression()
+		}
+	}
+	var write, read *cipherState
+	if resp.Capabilities&packet.CapabilityEncryption != 0 {
+		if enc := v.negotiateEncryption(pk.Encryption); enc != nil {
+			key, err := newEncryptionKey()
+			if err != nil {
+				return err
+			}
+			resp.Encryption, resp.PublicKey = enc.EncodeEncryption(), key.PublicKey().Bytes()
+			resp.KeyProof = keyProof(v.auth.Token, pk.PublicKey, resp.PublicKey, resp.Encryption)
+			if write, read, err = deriveCiphers(enc, key, pk.PublicKey, pk.PublicKey, resp.PublicKey, v.auth.Token, false); err != nil {
+				c.log.Warn("login with invalid public key", "identity", pk.Service, "err", err)
+				return v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseInvalidPublicKey, Protocol: pk.Protocol})
+			}
+		}
+	}
+
+	if v.draining.Load() {
+		c.log.Info("login while draining", "identity", pk.Service)
+		return v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseDraining, Protocol: pk.Protocol})
+	}
+	if !v.admitIdentity(pk.Service) {
+		c.log.Warn("login exceeds connections per identity", "identity", pk.Service, "limit", v.connLimits.PerIdentity)
+		return v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseTooManyConnections, Protocol: pk.Protocol})
+	}
+
+	v.metrics.Login(resp.Code)
+	if err := c.WritePacket(resp, false); err != nil {
+		v.handleError(c, err)
+	}
+
+	if h, ok := v.handler.(StreamHandler); ok {
+		c.streamHandler = h
+	}
+	// The identity is cloned, as it aliases the message of the login if zero-copy decoding is enabled.
+	c.login(strings.Clone(pk.Service), pk.Protocol, resp.Capabilities)
+	c.identityBucket = v.limiter.acquire(c.Identity())
+	if alg != nil {
+		c.enableCompression(alg, v.compressionThreshold)
+	}
+	if write != nil {
+		c.enableEncryption(write, read)
+	}
+	if v.batchDelay > 0 && c.supports(packet.CapabilityBatching) {
+		c.startBatching(v.batchDelay, v.batchSize)
+	}
+	c.log.Info("logged in", "identity", pk.Service, "protocol", pk.Protocol, "capabilities", resp.Capabilities, "compression", resp.Compression, "encryption", resp.Encryption)
+	if h, ok := v.handler.(LoginHandler); ok {
+		h.HandleLogin(c, c.Identity())
+	}
+	return nil
+}
+
+// rejectLogin responds to a login with the AuthResponse passed and closes the connection. A *LoginError
+// with the code of the response is returned. Logins rejected because the service is draining or has too
+// many connections are closed with the close code 1013 (try again later), and others with 1008 (policy
+// violation). The Protocol of the response is set to CurrentProtocol if it is 0.
+func (v *Vortex) rejectLogin(c *Conn, resp *packet.AuthResponse) error {
+	if resp.Protocol == 0 {
+		resp.Protocol = packet.CurrentProtocol
+	}
+	v.metrics.Login(resp.Code)
+	if err := c.WritePacket(resp, false); err != nil {
+		v.handleError(c, err)
+	}
+	c.log.Warn("login rejected", "code", resp.Code)
+	code := websocket.ClosePolicyViolation
+	if resp.Code == packet.AuthResponseDraining || resp.Code == packet.AuthResponseTooManyConnections {
+		code = websocket.CloseTryAgainLater
+	}
+	_ = c.closeWithCode(code, "login rejected")
+	return &LoginError{Code: resp.Code}
+}
+
+// negotiateCompression returns the first compression algorithm in the preferences passed that the service
+// supports, or nil if none of them are supported.
+func (v *Vortex) negotiateCompression(preferences []uint16) packet.Compression {
+	for _, id := range preferences {
+		for _, alg := range v.compression {
+			if alg.EncodeCompression() == id {
+				return alg
+			}
+		}
+	}
+	return nil
+}
+
+// validToken checks if the login passed holds the token of the service, or, if it offers encryption, a
+// TokenProof of it.
+func (v *Vortex) validToken(pk *packet.Login) bool {
+	if len(pk.Encryption) > 0 {
+		return hmac.Equal(pk.TokenProof, tokenProof(v.auth.Token, pk.PublicKey, pk.Encryption))
+	}
+	return pk.Token == v.auth.Token
+}
+
+// negotiateEncryption returns the first encryption algorithm in the preferences passed that the service
+// supports, or nil if none of them are supported.
+func (v *Vortex) negotiateEncryption(preferences []uint16) packet.Encryption {
+	for _, id := range preferences {
+		for _, alg := range v.encryption {
+			if alg.EncodeEncryption() == id {
+				return alg
+			}
+		}
+	}
+	return nil
+}
 
-	err := c.WritePacket(pk, closed)
-	if err != nil {
-		fmt.Println(err)
+// handleError passes err to the Handler if it implements ErrorHandler.
+func (v *Vortex) handleError(c *Conn, err error) {
+	if h, ok := v.handler.(ErrorHandler); ok {
+		h.HandleError(c, err)
 	}
 }
@@ -0,0 +1,47 @@
+package vortex
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/metrics"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// login dials the URL passed without a Dialer, writes the Login passed and returns the AuthResponse read.
+func login(t *testing.T, url string, pk *packet.Login) *packet.AuthResponse {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConn(websocketConn{ws}, testLogger(), metrics.Nop{}, newPool(&packet.AuthResponse{}))
+	defer c.Close()
+	if err := c.WritePacket(pk, false); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.(*packet.AuthResponse)
+}
+
+// TestLoginProtocols checks that peers may log in with every protocol version from 1 to CurrentProtocol, and
+// get an AuthResponse of their version, while other versions are rejected.
+func TestLoginProtocols(t *testing.T) {
+	v := NewService("test", auth.Auth{Token: "token"}, WithLogger(testLogger()))
+	url := serveTest(t, v)
+
+	for protocol := uint32(0); protocol <= packet.CurrentProtocol+1; protocol++ {
+		wantCode, wantProtocol := uint32(packet.AuthResponseSuccess), protocol
+		if protocol == 0 || protocol > packet.CurrentProtocol {
+			wantCode, wantProtocol = packet.AuthResponseIncompatibleProtocol, packet.CurrentProtocol
+		}
+		resp := login(t, url, &packet.Login{Service: "peer", Token: "token", Protocol: protocol})
+		if resp.Code != wantCode || resp.Protocol != wantProtocol {
+			t.Errorf("protocol %v: got code %v and protocol %v, want code %v and protocol %v", protocol, resp.Code, resp.Protocol, wantCode, wantProtocol)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package metrics implements collecting metrics of Vortex services and connections.
+package metrics
+
+import (
+	"time"
+)
+
+// Collector collects metrics of the connections of a Vortex service or Dialer. Implementations must be safe
+// for concurrent use, as the methods are called from the goroutines of all connections.
+type Collector interface {
+	// ConnOpened is called when a connection is accepted or dialed.
+	ConnOpened()
+	// ConnClosed is called when a connection is closed.
+	ConnClosed()
+	// Login is called when a service responds to a login, with code being one of the packet.AuthResponse
+	// codes.
+	Login(code uint32)
+	// PacketReceived is called for every packet received. size is the size of the message in bytes.
+	PacketReceived(id uint32, size int)
+	// PacketSent is called for every packet written. size is the size of the message in bytes.
+	PacketSent(id uint32, size int)
+	// PacketHandled is called after a Handler has handled a packet, with d being the time it took.
+	PacketHandled(id uint32, d time.Duration)
+	// DecodeError is called when a message could not be decoded into a packet with the ID passed.
+	DecodeError(id uint32)
+	// RateLimited is called when a packet with the ID passed exceeded a rate limit of the service. action is
+	// the action taken, such as "drop" or "disconnect".
+	RateLimited(id uint32, action string)
+	// WriteQueueChanged is called when the number of writes waiting to be written to a connection changes.
+	// delta is positive when a write is queued and negative when it is done.
+	WriteQueueChanged(delta int)
+}
+
+// Nop is a Collector that discards all metrics. It is used when no Collector is set.
+type Nop struct{}
+
+// ConnOpened ...
+func (Nop) ConnOpened() {}
+
+// ConnClosed ...
+func (Nop) ConnClosed() {}
+
+// Login ...
+func (Nop) Login(uint32) {}
+
+// PacketReceived ...
+func (Nop) PacketReceived(uint32, int) {}
+
+// PacketSent ...
+func (Nop) PacketSent(uint32, int) {}
+
+// PacketHandled ...
+func (Nop) PacketHandled(uint32, time.Duration) {}
+
+// DecodeError ...
+func (Nop) DecodeError(uint32) {}
+
+// RateLimited ...
+func (Nop) RateLimited(uint32, string) {}
+
+// WriteQueueChanged ...
+func (Nop) WriteQueueChanged(int) {}
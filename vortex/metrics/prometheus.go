@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the buckets of the handler latency histogram used by
+// NewPrometheus.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Prometheus is a Collector that keeps metrics in memory and serves them in the Prometheus text exposition
+// format. Prometheus implements http.Handler, and a Vortex service with a Prometheus collector serves it on
+// /metrics at the address set using vortex.WithMetricsAddr.
+type Prometheus struct {
+	namespace string
+	buckets   []float64
+
+	conns      atomic.Int64
+	writeQueue atomic.Int64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+
+	mu           sync.Mutex
+	logins       map[uint32]uint64
+	packetsIn    map[uint32]uint64
+	packetsOut   map[uint32]uint64
+	decodeErrors map[uint32]uint64
+	rateLimited  map[rateLimitKey]uint64
+	latency      map[uint32]*histogram
+}
+
+// NewPrometheus creates a Prometheus collector with all metric names prefixed with the namespace passed,
+// such as "vortex". If namespace is empty, metric names are not prefixed. The handler latency histograms
+// use DefaultBuckets.
+func NewPrometheus(namespace string) *Prometheus {
+	return &Prometheus{
+		namespace:    namespace,
+		buckets:      DefaultBuckets,
+		logins:       make(map[uint32]uint64),
+		packetsIn:    make(map[uint32]uint64),
+		packetsOut:   make(map[uint32]uint64),
+		decodeErrors: make(map[uint32]uint64),
+		rateLimited:  make(map[rateLimitKey]uint64),
+		latency:      make(map[uint32]*histogram),
+	}
+}
+
+// rateLimitKey is the key of the packets that exceeded a rate limit, counted by packet ID and action.
+type rateLimitKey struct {
+	id     uint32
+	action string
+}
+
+// histogram is a cumulative histogram of durations in seconds.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// ConnOpened ...
+func (p *Prometheus) ConnOpened() {
+	p.conns.Add(1)
+}
+
+// ConnClosed ...
+func (p *Prometheus) ConnClosed() {
+	p.conns.Add(-1)
+}
+
+// Login ...
+func (p *Prometheus) Login(code uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.logins[code]++
+}
+
+// PacketReceived ...
+func (p *Prometheus) PacketReceived(id uint32, size int) {
+	p.bytesIn.Add(uint64(size))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.packetsIn[id]++
+}
+
+// PacketSent ...
+func (p *Prometheus) PacketSent(id uint32, size int) {
+	p.bytesOut.Add(uint64(size))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.packetsOut[id]++
+}
+
+// PacketHandled ...
+func (p *Prometheus) PacketHandled(id uint32, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.latency[id]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.latency[id] = h
+	}
+	s := d.Seconds()
+	for i, upper := range p.buckets {
+		if s <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+// DecodeError ...
+func (p *Prometheus) DecodeError(id uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decodeErrors[id]++
+}
+
+// RateLimited ...
+func (p *Prometheus) RateLimited(id uint32, action string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rateLimited[rateLimitKey{id: id, action: action}]++
+}
+
+// WriteQueueChanged ...
+func (p *Prometheus) WriteQueueChanged(delta int) {
+	p.writeQueue.Add(int64(delta))
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = p.Write(w)
+}
+
+// Write writes all metrics in the Prometheus text exposition format to the io.Writer passed.
+func (p *Prometheus) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+
+	p.header(b, "connections_active", "gauge", "Number of open connections.")
+	p.sample(b, "connections_active", "", float64(p.conns.Load()))
+	p.header(b, "write_queue_depth", "gauge", "Number of packets waiting to be written.")
+	p.sample(b, "write_queue_depth", "", float64(p.writeQueue.Load()))
+	p.header(b, "received_bytes_total", "counter", "Total bytes of packets received.")
+	p.sample(b, "received_bytes_total", "", float64(p.bytesIn.Load()))
+	p.header(b, "sent_bytes_total", "counter", "Total bytes of packets sent.")
+	p.sample(b, "sent_bytes_total", "", float64(p.bytesOut.Load()))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.header(b, "logins_total", "counter", "Total logins by response code.")
+	for _, code := range sortedKeys(p.logins) {
+		p.sample(b, "logins_total", label("code", code), float64(p.logins[code]))
+	}
+	p.header(b, "received_packets_total", "counter", "Total packets received by packet ID.")
+	for _, id := range sortedKeys(p.packetsIn) {
+		p.sample(b, "received_packets_total", label("packet", id), float64(p.packetsIn[id]))
+	}
+	p.header(b, "sent_packets_total", "counter", "Total packets sent by packet ID.")
+	for _, id := range sortedKeys(p.packetsOut) {
+		p.sample(b, "sent_packets_total", label("packet", id), float64(p.packetsOut[id]))
+	}
+	p.header(b, "decode_errors_total", "counter", "Total messages that failed to decode by packet ID.")
+	for _, id := range sortedKeys(p.decodeErrors) {
+		p.sample(b, "decode_errors_total", label("packet", id), float64(p.decodeErrors[id]))
+	}
+	p.header(b, "rate_limited_packets_total", "counter", "Total packets that exceeded a rate limit by packet ID and action.")
+	keys := make([]rateLimitKey, 0, len(p.rateLimited))
+	for k := range p.rateLimited {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].id < keys[j].id || keys[i].id == keys[j].id && keys[i].action < keys[j].action
+	})
+	for _, k := range keys {
+		p.sample(b, "rate_limited_packets_total", label("packet", k.id)+`,action="`+k.action+`"`, float64(p.rateLimited[k]))
+	}
+	p.header(b, "handle_duration_seconds", "histogram", "Time taken to handle packets by packet ID.")
+	for _, id := range sortedKeys(p.latency) {
+		h, l := p.latency[id], label("packet", id)
+		for i, upper := range p.buckets {
+			p.sample(b, "handle_duration_seconds_bucket", l+`,le="`+strconv.FormatFloat(upper, 'g', -1, 64)+`"`, float64(h.counts[i]))
+		}
+		p.sample(b, "handle_duration_seconds_bucket", l+`,le="+Inf"`, float64(h.count))
+		p.sample(b, "handle_duration_seconds_sum", l, h.sum)
+		p.sample(b, "handle_duration_seconds_count", l, float64(h.count))
+	}
+	return b.Flush()
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func (p *Prometheus) header(w io.Writer, name, typ, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", p.name(name), help, p.name(name), typ)
+}
+
+// sample writes a single sample of a metric with the labels passed.
+func (p *Prometheus) sample(w io.Writer, name, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	_, _ = fmt.Fprintf(w, "%v%v %v\n", p.name(name), labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// name returns the full name of a metric, prefixed with the namespace if set.
+func (p *Prometheus) name(name string) string {
+	if p.namespace == "" {
+		return name
+	}
+	return p.namespace + "_" + name
+}
+
+// label formats a label with the name and value passed.
+func label(name string, v uint32) string {
+	return name + `="` + strconv.FormatUint(uint64(v), 10) + `"`
+}
+
+// sortedKeys returns the keys of the map passed in ascending order.
+func sortedKeys[V any](m map[uint32]V) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
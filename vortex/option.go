@@ -0,0 +1,205 @@
+package vortex
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/metrics"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+	"github.com/vortex-service/vortex/vortex/trace"
+)
+
+// Option configures a Vortex service created using NewService.
+type Option func(v *Vortex)
+
+// WithLogger sets the logger used by the service and the connections it accepts. Every line logged carries
+// the name of the service. By default, slog.Default() is used.
+func WithLogger(log *slog.Logger) Option {
+	return func(v *Vortex) {
+		v.log = log
+	}
+}
+
+// WithMetrics sets the metrics.Collector that metrics of the service and its connections are reported to.
+// If the collector also implements http.Handler, such as *metrics.Prometheus, it may be served using
+// WithMetricsAddr. By default, metrics are discarded.
+func WithMetrics(c metrics.Collector) Option {
+	return func(v *Vortex) {
+		v.metrics = c
+	}
+}
+
+// WithMetricsAddr sets the TCP address, such as "127.0.0.1:9090", that Start serves the metrics.Collector of
+// the service on under /metrics, if it implements http.Handler. The metrics hold the packets and identities
+// of the peers of the service, so they are never served on the address that peers connect to, and should
+// only be served on an address that peers cannot reach. By default, metrics are not served.
+func WithMetricsAddr(addr string) Option {
+	return func(v *Vortex) {
+		v.metricsAddr = addr
+	}
+}
+
+// WithTracer sets the trace.Tracer used to start a span for every packet handled. Without a Tracer, the
+// trace context received with a packet is still passed to the Handler unchanged.
+func WithTracer(t trace.Tracer) Option {
+	return func(v *Vortex) {
+		v.tracer = t
+	}
+}
+
+// DefaultCompressionThreshold is the size in bytes from which frames are compressed if compression was
+// negotiated and no other threshold was set.
+const DefaultCompressionThreshold = 256
+
+// WithCompression sets the compression algorithms supported by the service. During login, the first
+// algorithm in the preferences of the peer that is also passed here is picked, and frames of at least the
+// compression threshold are compressed with it from then on. By default, frames are not compressed.
+func WithCompression(algorithms ...packet.Compression) Option {
+	return func(v *Vortex) {
+		v.compression = algorithms
+	}
+}
+
+// WithCompressionThreshold sets the size in bytes from which frames written by the service are compressed
+// if compression was negotiated. Smaller frames are sent uncompressed. By default,
+// DefaultCompressionThreshold is used.
+func WithCompressionThreshold(n int) Option {
+	return func(v *Vortex) {
+		v.compressionThreshold = n
+	}
+}
+
+// WithEncryption enables encrypting the frames of connections with one of the algorithms passed,
+// independently of any TLS between the service and its peers. During login, the first algorithm in the
+// preferences of the peer that is also passed here is picked, and every frame sent by either side after
+// the login is encrypted with keys derived from an ephemeral X25519 key exchange. The key exchange is bound
+// to the token of the service: peers offering encryption send an HMAC of the token over their public key
+// instead of the token itself, the service proves its public key with an HMAC of the token over both keys,
+// and the token is mixed into the keys derived. Parties observing or relaying the login without knowing the
+// token, such as a proxy terminating TLS, thus learn neither the token nor the keys, and cannot replace the
+// public keys without the login failing. Peers not offering encryption, including those of protocol
+// versions before packet.ProtocolEncryption, still send the token in clear text. Packets written as the
+// payload of a close message are not encrypted. By default, frames are not encrypted.
+func WithEncryption(algorithms ...packet.Encryption) Option {
+	return func(v *Vortex) {
+		v.encryption = algorithms
+	}
+}
+
+// WithRateLimits sets the rate limits applied to the packets that connections send after logging in. The
+// limits may be replaced later using Vortex.SetRateLimits. By default, packets are not rate limited.
+func WithRateLimits(limits RateLimits) Option {
+	return func(v *Vortex) {
+		v.limiter.set(limits)
+	}
+}
+
+// WithConnectionLimits sets the maximum numbers of connections that the service keeps open in total, per
+// remote IP and per identity. By default, the number of connections is not limited.
+func WithConnectionLimits(limits ConnectionLimits) Option {
+	return func(v *Vortex) {
+		v.connLimits = limits
+	}
+}
+
+// WithAllowedOrigins sets the origins that browsers may open connections to the service from, such as
+// "https://example.com". Upgrade requests with an Origin header that does not match any of them, compared
+// case-insensitively, are refused with HTTP status 403 Forbidden. "*" allows every origin. Requests without
+// an Origin header, which are not sent by browsers, are always allowed. By default, only requests with an
+// Origin of the same host as the request are allowed.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(v *Vortex) {
+		v.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			for _, allowed := range origins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+}
+
+// WithRequiredHeaders sets headers that upgrade requests must carry. For every header with values, the
+// request must carry the header with one of the values, and for every header without values, it must carry
+// the header with any value. Requests missing a required header are refused with HTTP status 403
+// Forbidden before being upgraded.
+func WithRequiredHeaders(headers http.Header) Option {
+	return func(v *Vortex) {
+		v.requiredHeaders = headers
+	}
+}
+
+// WithSubprotocols sets the websocket subprotocols supported by the service, such as "vortex.v1", in order
+// of preference. The first subprotocol supported that the peer offers is negotiated and returned by
+// Conn.Subprotocol, and upgrade requests not offering any of them are refused with HTTP status 400 Bad
+// Request. By default, no subprotocol is negotiated.
+func WithSubprotocols(protocols ...string) Option {
+	return func(v *Vortex) {
+		v.upgrader.Subprotocols = protocols
+	}
+}
+
+// WithBufferSizes sets the sizes in bytes of the read and write buffers of the websocket connections
+// accepted. Messages larger than the buffers are still read and written, in several parts. If a size is
+// 0, the buffers of the HTTP server are reused. By default, both buffers are 1024 bytes.
+func WithBufferSizes(read, write int) Option {
+	return func(v *Vortex) {
+		v.upgrader.ReadBufferSize, v.upgrader.WriteBufferSize = read, write
+	}
+}
+
+// WithWriteBufferPool sets the pool that the write buffers of websocket connections are taken from while a
+// message is written, such as a *sync.Pool. Pooling write buffers saves memory for services with many
+// connections that rarely write. By default, every connection holds a write buffer of its own.
+func WithWriteBufferPool(pool websocket.BufferPool) Option {
+	return func(v *Vortex) {
+		v.upgrader.WriteBufferPool = pool
+	}
+}
+
+// WithReadLimit sets the maximum size in bytes of the messages that connections accept. Messages larger than
+// the limit are refused while reading their header, before the message is read into memory, and the
+// connection is closed with the close code 1009 (message too big). By default, the size of websocket
+// messages is not limited, while messages of TCPTransport, UnixTransport and QUICTransport are limited to
+// DefaultSocketReadLimit.
+func WithReadLimit(n int64) Option {
+	return func(v *Vortex) {
+		v.readLimit = n
+	}
+}
+
+// WithWebsocketCompression enables negotiating the websocket permessage-deflate extension with peers that
+// support it. It compresses every message on the websocket level, independently of WithCompression.
+func WithWebsocketCompression() Option {
+	return func(v *Vortex) {
+		v.upgrader.EnableCompression = true
+	}
+}
+
+// WithBatching enables coalescing the packets written to a connection after it logged in into batches,
+// which are written as a single message every delay, or as soon as at least size bytes of packets are
+// queued. If size is 0, DefaultBatchSize is used. By default, every packet is written immediately in a
+// message of its own.
+func WithBatching(delay time.Duration, size int) Option {
+	return func(v *Vortex) {
+		v.batchDelay, v.batchSize = delay, size
+	}
+}
+
+// WithZeroCopyDecoding enables zero-copy decoding of the packets received. The strings and byte slices of
+// packets decoded are not copied, but alias the message that the packet was received in, which saves an
+// allocation for every one of them. They may only be used until the Handler that the packet was passed to
+// returns, and must be copied, for example using proto.Clone, to keep them beyond that.
+func WithZeroCopyDecoding() Option {
+	return func(v *Vortex) {
+		v.zeroCopy = true
+	}
+}
@@ -0,0 +1,116 @@
+package vortex
+
+import (
+	"fmt"
+
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// policy is an auth.Policy with the rules of its packets indexed by packet ID, with rules of packets
+// identified by type resolved to the ID of the packet registered with that type.
+type policy struct {
+	*auth.Policy
+	rules map[uint32]auth.PacketRule
+}
+
+// allows checks if the identity passed may send the packet with the ID passed.
+func (p *policy) allows(identity string, id uint32) bool {
+	rule, ok := p.rules[id]
+	if !ok {
+		return p.DefaultAllows()
+	}
+	return p.Allows(rule, identity)
+}
+
+// SetPolicy sets the auth.Policy deciding which identities may send which packets after logging in.
+// Packets denied by the policy are logged and not passed to the Handler, and a packet.Error with the code
+// packet.ErrorPermissionDenied is sent to the peer in their place. Streams opened with a packet that is
+// denied are reset instead. SetPolicy may be called at any time,
+// such as when the file of the policy changes, and applies to connections that are already open. An error
+// is returned, and the current policy kept, if the policy is invalid or one of its rules names a packet that
+// is not registered, so SetPolicy must be called after RegisterPackets. Passing nil removes the policy, so
+// that every packet is allowed, which is the default.
+func (v *Vortex) SetPolicy(p *auth.Policy) error {
+	if p == nil {
+		v.policy.Store(nil)
+		return nil
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	compiled := &policy{Policy: p, rules: make(map[uint32]auth.PacketRule, len(p.Packets))}
+	for _, rule := range p.Packets {
+		id, err := v.resolveRule(rule)
+		if err != nil {
+			return err
+		}
+		if _, ok := compiled.rules[id]; ok {
+			return fmt.Errorf("policy rule of packet %v: packet has several rules", id)
+		}
+		compiled.rules[id] = rule
+	}
+	v.policy.Store(compiled)
+	return nil
+}
+
+// resolveRule returns the ID of the registered packet that a rule of a policy applies to.
+func (v *Vortex) resolveRule(rule auth.PacketRule) (uint32, error) {
+	if rule.ID != nil {
+		if _, ok := v.packets[*rule.ID]; !ok {
+			return 0, fmt.Errorf("policy rule of packet %v: no packet registered with the ID", *rule.ID)
+		}
+		return *rule.ID, nil
+	}
+	var (
+		id    uint32
+		found bool
+	)
+	for pid, t := range v.packets {
+		if t.Name() != rule.Type {
+			continue
+		}
+		if found {
+			return 0, fmt.Errorf("policy rule of packet %v: packets %v and %v have the type name", rule.Type, id, pid)
+		}
+		id, found = pid, true
+	}
+	if !found {
+		return 0, fmt.Errorf("policy rule of packet %v: no packet registered with the type", rule.Type)
+	}
+	return id, nil
+}
+
+// allows checks if the policy of the service allows the connection to send the packet with the ID passed,
+// logging the packet if it is denied.
+func (v *Vortex) allows(c *Conn, id uint32) bool {
+	p := v.policy.Load()
+	if p == nil || p.allows(c.Identity(), id) {
+		return true
+	}
+	c.log.Warn("packet denied by policy", "identity", c.Identity(), "packet", id)
+	return false
+}
+
+// authorize checks if the policy of the service allows the connection to send the packet passed. A
+// packet.Error is written to peers supporting it for packets that are denied, as the response if the packet
+// is a request.
+func (v *Vortex) authorize(c *Conn, h header, pk packet.Packet) bool {
+	if v.allows(c, pk.ID()) {
+		return true
+	}
+	if !c.supports(packet.CapabilityErrors) {
+		return false
+	}
+	resp := &packet.Error{PacketID: pk.ID(), Code: packet.ErrorPermissionDenied, Message: "permission denied"}
+	var err error
+	if h.flags&flagRequest != 0 {
+		err = c.Reply(c.context(h), resp)
+	} else {
+		err = c.WritePacket(resp, false)
+	}
+	if err != nil {
+		v.handleError(c, err)
+	}
+	return false
+}
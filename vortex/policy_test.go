@@ -0,0 +1,86 @@
+package vortex
+
+import (
+	"testing"
+
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// kick is a packet registered next to payload by the policy tests.
+type kick struct{}
+
+// ID ...
+func (*kick) ID() uint32 { return 101 }
+
+// Marshal ...
+func (*kick) Marshal(proto.IO) {}
+
+// TestPolicyAllows checks which identities the rules and default of a policy allow to send a packet, with
+// rules identifying packets by ID or by type.
+func TestPolicyAllows(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		identity string
+		id       uint32
+		allowed  bool
+	}{
+		{"empty default denies", `{"packets": []}`, "a", 100, false},
+		{"default deny", `{"default": "deny", "packets": []}`, "a", 100, false},
+		{"default allow", `{"default": "allow", "packets": []}`, "a", 100, true},
+		{"identity", `{"packets": [{"id": 100, "allow": ["a"]}]}`, "a", 100, true},
+		{"other identity", `{"packets": [{"id": 100, "allow": ["a"]}]}`, "b", 100, false},
+		{"wildcard", `{"packets": [{"id": 100, "allow": ["*"]}]}`, "b", 100, true},
+		{"role", `{"roles": {"admin": ["a"]}, "packets": [{"id": 100, "allow": ["role:admin"]}]}`, "a", 100, true},
+		{"without role", `{"roles": {"admin": ["a"]}, "packets": [{"id": 100, "allow": ["role:admin"]}]}`, "b", 100, false},
+		{"empty allow", `{"default": "allow", "packets": [{"id": 100, "allow": []}]}`, "a", 100, false},
+		{"type", `{"packets": [{"type": "kick", "allow": ["a"]}]}`, "a", 101, true},
+		{"type other packet", `{"packets": [{"type": "kick", "allow": ["a"]}]}`, "a", 100, false},
+		{"rule overrides default", `{"default": "allow", "packets": [{"type": "kick", "allow": ["a"]}]}`, "b", 101, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := auth.ParsePolicy([]byte(tc.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := NewService("test", auth.Auth{}, WithLogger(testLogger()))
+			v.RegisterPackets(&payload{}, &kick{})
+			if err := v.SetPolicy(p); err != nil {
+				t.Fatal(err)
+			}
+			if allowed := v.policy.Load().allows(tc.identity, tc.id); allowed != tc.allowed {
+				t.Errorf("identity %v may send packet %v: got %v, want %v", tc.identity, tc.id, allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+// TestSetPolicyInvalid checks that policies with rules of packets that are not registered or that resolve to
+// the same packet are rejected, keeping the current policy, and that nil removes the policy.
+func TestSetPolicyInvalid(t *testing.T) {
+	v := NewService("test", auth.Auth{}, WithLogger(testLogger()))
+	v.RegisterPackets(&payload{}, &kick{})
+	current := &auth.Policy{Default: "allow"}
+	if err := v.SetPolicy(current); err != nil {
+		t.Fatal(err)
+	}
+
+	id, unknown := uint32(100), uint32(50)
+	for name, p := range map[string]*auth.Policy{
+		"unknown ID":   {Packets: []auth.PacketRule{{ID: &unknown}}},
+		"unknown type": {Packets: []auth.PacketRule{{Type: "unknown"}}},
+		"same packet":  {Packets: []auth.PacketRule{{ID: &id}, {Type: "payload"}}},
+		"invalid":      {Default: "maybe"},
+	} {
+		if err := v.SetPolicy(p); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+		if v.policy.Load().Policy != current {
+			t.Errorf("%v: current policy replaced", name)
+		}
+	}
+	if err := v.SetPolicy(nil); err != nil || v.policy.Load() != nil {
+		t.Errorf("got policy %v with error %v after removing it", v.policy.Load(), err)
+	}
+}
@@ -0,0 +1,127 @@
+package vortex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// pool holds the packet types that may be decoded by a connection, indexed by their ID.
+type pool map[uint32]reflect.Type
+
+// newPool creates a pool with the packets passed registered.
+func newPool(packets ...packet.Packet) pool {
+	p := make(pool)
+	p.register(packets...)
+	return p
+}
+
+// register adds the types of the packets passed to the pool. It panics if a packet has an ID that is
+// reserved, see checkID.
+func (p pool) register(packets ...packet.Packet) {
+	for _, pk := range packets {
+		t := reflect.TypeOf(pk).Elem()
+		if err := checkID(pk.ID(), t); err != nil {
+			panic(fmt.Errorf("register packet %v: %w", t, err))
+		}
+		p[pk.ID()] = t
+	}
+}
+
+// packetPkg is the path of package packet, which holds the packets built into the protocol.
+var packetPkg = reflect.TypeOf(packet.Login{}).PkgPath()
+
+// checkID checks if a packet of the type passed may have the ID passed. IDs are encoded as a single byte
+// in which IDReserved marks extensions, and the IDs of the packets of package packet, including those
+// from IDBuiltIn up, may only be used by those packets.
+func checkID(id uint32, t reflect.Type) error {
+	switch {
+	case id >= packet.IDReserved:
+		return fmt.Errorf("ID %v is reserved or does not fit in a byte", id)
+	case (id <= packet.IDHeartbeat || id >= packet.IDBuiltIn) && t.PkgPath() != packetPkg:
+		return fmt.Errorf("ID %v is reserved for the packets built into the protocol", id)
+	}
+	return nil
+}
+
+// decode decodes a message into a new packet of the ID found in its first byte. A *DecodeError is returned
+// if the packet is unknown or its Marshal method fails to read it. If zeroCopy is true, the strings and
+// byte slices of the packet alias msg.
+func (p pool) decode(msg []byte, zeroCopy bool) (pk packet.Packet, err error) {
+	if len(msg) < 1 {
+		return nil, &DecodeError{Err: errors.New("empty message")}
+	}
+	id := uint32(msg[0])
+
+	t, ok := p[id]
+	if !ok {
+		return nil, &DecodeError{PacketID: id, Err: errors.New("unknown packet ID")}
+	}
+	pk = reflect.New(t).Interface().(packet.Packet)
+
+	defer func() {
+		if r := recover(); r != nil {
+			pk, err = nil, &DecodeError{PacketID: id, Err: fmt.Errorf("%v", r)}
+		}
+	}()
+	if zeroCopy {
+		pk.Marshal(proto.NewZeroCopyReader(msg[1:], 1, false))
+	} else {
+		pk.Marshal(proto.NewReader(bytes.NewReader(msg[1:]), 1, false))
+	}
+	return pk, nil
+}
+
+// schema returns the Schema of every packet in the pool, sorted by packet ID. Built-in packets are left
+// out, as they are covered by the protocol version.
+func (p pool) schema() []packet.Schema {
+	schema := make([]packet.Schema, 0, len(p))
+	for id, t := range p {
+		if builtIn(id) {
+			continue
+		}
+		schema = append(schema, packet.SchemaOf(reflect.New(t).Interface().(packet.Packet)))
+	}
+	sort.Slice(schema, func(i, j int) bool { return schema[i].PacketID < schema[j].PacketID })
+	return schema
+}
+
+// checkSchema compares the schema of the peer passed with the schema of the packets in the pool. Packets
+// registered on only one of both sides are not compared. A *SchemaError holding every packet that does not
+// match is returned if any of them are incompatible.
+func (p pool) checkSchema(remote []packet.Schema) error {
+	var mismatches []SchemaMismatch
+	for _, r := range remote {
+		t, ok := p[r.PacketID]
+		if !ok || builtIn(r.PacketID) {
+			continue
+		}
+		if local := packet.SchemaOf(reflect.New(t).Interface().(packet.Packet)); !local.Compatible(r) {
+			mismatches = append(mismatches, SchemaMismatch{Local: local, Remote: r})
+		}
+	}
+	if len(mismatches) > 0 {
+		return &SchemaError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// builtIn checks if the ID passed is that of a packet built into the protocol, such as packet.Login or
+// packet.Throttle.
+func builtIn(id uint32) bool {
+	return id == packet.IDLogin || id == packet.IDAuthResponse || id >= packet.IDBuiltIn
+}
+
+// packets returns a new packet of every type in the pool.
+func (p pool) packets() []packet.Packet {
+	packets := make([]packet.Packet, 0, len(p))
+	for _, t := range p {
+		packets = append(packets, reflect.New(t).Interface().(packet.Packet))
+	}
+	return packets
+}
@@ -0,0 +1,49 @@
+package vortex
+
+import (
+	"testing"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// idPacket is a packet without fields with the ID it holds.
+type idPacket uint32
+
+// ID ...
+func (pk *idPacket) ID() uint32 { return uint32(*pk) }
+
+// Marshal ...
+func (*idPacket) Marshal(proto.IO) {}
+
+// TestRegisterIDs checks that registering a packet panics if its ID is reserved for the protocol or does
+// not fit in a byte, unless it is the built-in packet with that ID.
+func TestRegisterIDs(t *testing.T) {
+	id := func(id uint32) packet.Packet { pk := idPacket(id); return &pk }
+	for _, tc := range []struct {
+		name   string
+		pk     packet.Packet
+		panics bool
+	}{
+		{"Login", &packet.Login{}, false},
+		{"AuthResponse", &packet.AuthResponse{}, false},
+		{"Heartbeat", &packet.Heartbeat{}, false},
+		{"Throttle", &packet.Throttle{}, false},
+		{"first ID", id(packet.IDHeartbeat + 1), false},
+		{"last ID", id(packet.IDBuiltIn - 1), false},
+		{"ID of Login", id(packet.IDLogin), true},
+		{"ID of Heartbeat", id(packet.IDHeartbeat), true},
+		{"ID of Throttle", id(packet.IDThrottle), true},
+		{"IDReserved", id(packet.IDReserved), true},
+		{"beyond a byte", id(0x100 + 100), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tc.panics {
+					t.Errorf("panicked with %v, want panic %v", r, tc.panics)
+				}
+			}()
+			newPool(tc.pk)
+		})
+	}
+}
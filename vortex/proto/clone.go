@@ -0,0 +1,19 @@
+package proto
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// Clone returns a deep copy of the value that m points to, which holds no references to the memory of m. It
+// may be used to keep a value decoded by a zero-copy Reader beyond the lifetime of the byte slice it was
+// read from. The copy is made by writing m and reading it back into a new value of the same type, so it
+// holds exactly the fields that m marshals.
+func Clone[M Marshaler](m M) M {
+	buf := bytes.NewBuffer(make([]byte, 0, Size(m, 0)))
+	m.Marshal(NewWriter(buf, 0))
+
+	c := reflect.New(reflect.TypeOf(m).Elem()).Interface().(M)
+	c.Marshal(NewReader(bytes.NewReader(buf.Bytes()), 0, false))
+	return c
+}
@@ -0,0 +1,249 @@
+package proto
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// WireType describes how a value is encoded by MarshalStruct, so that codecs for it may be written in other
+// languages.
+type WireType struct {
+	// Kind is the name of the IO method the value is read/written with in lower case, such as "varuint32",
+	// "string", "byteslice", "time" or "bigint", or one of the following:
+	//
+	//	slice      The length of the slice prefixed as Length, followed by every element as Elem.
+	//	array      Len elements as Elem.
+	//	map        The number of entries as a varuint32, followed by the key of every entry as Key and
+	//	           its value as Elem. The entries are in ascending order of their keys if Sorted is true.
+	//	optional   A bool that is true if the value is set, followed by the value as Elem if it is.
+	//	struct     The fields of the struct type Type, described in the types of the description.
+	//	marshaler  A type named Type that is marshaled by a Marshal method that does not follow the rules
+	//	           of MarshalStruct. Its encoding is not described.
+	Kind string `json:"kind"`
+	// Length is the kind of the length prefix of a slice.
+	Length string `json:"length,omitempty"`
+	// Len is the number of elements of an array.
+	Len int `json:"len,omitempty"`
+	// Key is the type of the keys of a map.
+	Key *WireType `json:"key,omitempty"`
+	// Elem is the type of the elements of a slice or array, of the values of a map, or of the value of an
+	// optional.
+	Elem *WireType `json:"elem,omitempty"`
+	// Sorted is true if the entries of a map are written in ascending order of their keys.
+	Sorted bool `json:"sorted,omitempty"`
+	// Type is the name of a struct or marshaler type.
+	Type string `json:"type,omitempty"`
+}
+
+// FieldDescription describes a field of a struct.
+type FieldDescription struct {
+	Name string   `json:"name"`
+	Type WireType `json:"type"`
+}
+
+// StructDescription describes a struct type as marshaled by MarshalStruct. Its fields are in the order in
+// which they are marshaled.
+type StructDescription struct {
+	Name   string             `json:"name"`
+	Fields []FieldDescription `json:"fields"`
+}
+
+// DescribeStruct describes the struct type passed as marshaled by MarshalStruct. The fields of t that are
+// of a type implementing Marshaler are described field by field if MarshalsStruct reports that the type
+// follows the rules of MarshalStruct, as is the case for types using MarshalStruct and types generated by
+// vortexgen, and as the marshaler kind otherwise. The descriptions of the struct types that the fields of t
+// refer to are added to types by their name.
+func DescribeStruct(t reflect.Type, types map[string]StructDescription) (StructDescription, error) {
+	if t.Kind() != reflect.Struct {
+		return StructDescription{}, fmt.Errorf("describe %v: not a struct", t)
+	}
+	d := &describer{types: types, seen: make(map[reflect.Type]bool)}
+	return d.describeStruct(t)
+}
+
+// describer builds the descriptions of struct types.
+type describer struct {
+	types map[string]StructDescription
+	// seen holds the struct types that are or were described, so that types referring to themselves do not
+	// recurse endlessly.
+	seen map[reflect.Type]bool
+}
+
+// describeStruct describes the fields of the struct type passed.
+func (d *describer) describeStruct(t reflect.Type) (StructDescription, error) {
+	d.seen[t] = true
+	s := StructDescription{Name: t.Name(), Fields: []FieldDescription{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _ := field.Tag.Lookup("vortex")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		opts, err := ParseTag(tag)
+		if err == nil {
+			var typ WireType
+			if typ, err = d.describe(field.Type, opts); err == nil {
+				s.Fields = append(s.Fields, FieldDescription{Name: field.Name, Type: typ})
+				continue
+			}
+		}
+		return s, fmt.Errorf("describe %v: field %v: %w", t, field.Name, err)
+	}
+	return s, nil
+}
+
+// describe returns the WireType of a value of the type passed, marshaled with the options passed. It
+// follows the same rules as planBuilder.codec.
+func (d *describer) describe(t reflect.Type, opts TagOptions) (WireType, error) {
+	if opts.Optional && t.Kind() != reflect.Pointer {
+		return WireType{}, fmt.Errorf("optional is only supported for pointers, not %v", t)
+	}
+	if opts.Length != "" && t.Kind() != reflect.Slice {
+		return WireType{}, fmt.Errorf("len is only supported for slices, not %v", t)
+	}
+	if opts.Sorted && t.Kind() != reflect.Map {
+		return WireType{}, fmt.Errorf("sorted is only supported for maps, not %v", t)
+	}
+	if ptr := reflect.PointerTo(t); ptr.Implements(marshalerType) && !ptr.Implements(optionalType) {
+		if opts != (TagOptions{}) {
+			return WireType{}, fmt.Errorf("tag options are not supported for %v, which implements Marshaler", t)
+		}
+		if t.Kind() == reflect.Struct && MarshalsStruct(reflect.New(t).Interface().(Marshaler)) {
+			return d.describeStructType(t)
+		}
+		return WireType{Kind: "marshaler", Type: t.Name()}, nil
+	}
+
+	switch t {
+	case durationType:
+		return WireType{Kind: "duration"}, nil
+	case timeType:
+		if opts.Varint {
+			return WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return WireType{Kind: "time"}, nil
+	case bigIntType:
+		if opts.Varint {
+			return WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return WireType{Kind: "bigint"}, nil
+	case uuidType:
+		if opts.Varint {
+			return WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return WireType{Kind: "beuuid"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Uint8, reflect.Int8, reflect.Float32, reflect.Float64, reflect.String:
+		if opts.Varint {
+			return WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return WireType{Kind: t.Kind().String()}, nil
+	case reflect.Uint16, reflect.Int16, reflect.Uint32, reflect.Int32, reflect.Uint64, reflect.Int64:
+		if opts.Varint {
+			return WireType{Kind: "var" + t.Kind().String()}, nil
+		}
+		return WireType{Kind: t.Kind().String()}, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && (opts.Length == "" || opts.Length == "varuint32") {
+			return WireType{Kind: "byteslice"}, nil
+		}
+		elem, err := d.describe(t.Elem(), TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return WireType{}, err
+		}
+		length := opts.Length
+		if length == "" {
+			length = "varuint32"
+		}
+		return WireType{Kind: "slice", Length: length, Elem: &elem}, nil
+	case reflect.Array:
+		elem, err := d.describe(t.Elem(), TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return WireType{}, err
+		}
+		return WireType{Kind: "array", Len: t.Len(), Elem: &elem}, nil
+	case reflect.Map:
+		if opts.Sorted && !orderedKind(t.Key().Kind()) {
+			return WireType{}, fmt.Errorf("sorted is only supported for maps with integer, float or string keys, not %v", t)
+		}
+		key, err := d.describe(t.Key(), TagOptions{})
+		if err != nil {
+			return WireType{}, err
+		}
+		elem, err := d.describe(t.Elem(), TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return WireType{}, err
+		}
+		return WireType{Kind: "map", Key: &key, Elem: &elem, Sorted: opts.Sorted}, nil
+	case reflect.Pointer:
+		if !opts.Optional {
+			return WireType{}, fmt.Errorf("pointer %v requires the optional tag option", t)
+		}
+		elem, err := d.describe(t.Elem(), TagOptions{Varint: opts.Varint})
+		if err != nil {
+			return WireType{}, err
+		}
+		return WireType{Kind: "optional", Elem: &elem}, nil
+	case reflect.Struct:
+		if opts.Varint {
+			return WireType{}, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return d.describeStructType(t)
+	}
+	return WireType{}, fmt.Errorf("unsupported type %v", t)
+}
+
+// describeStructType returns the WireType of a struct type marshaled by MarshalStruct, adding its
+// description to the types of the describer if it is marshaled field by field.
+func (d *describer) describeStructType(t reflect.Type) (WireType, error) {
+	switch {
+	case t == rgbaType:
+		return WireType{Kind: "rgba"}, nil
+	case reflect.PointerTo(t).Implements(optionalType):
+		_, val := reflect.New(t).Interface().(optionalValue).optional()
+		elem, err := d.describe(reflect.TypeOf(val).Elem(), TagOptions{})
+		if err != nil {
+			return WireType{}, err
+		}
+		return WireType{Kind: "optional", Elem: &elem}, nil
+	}
+	if !d.seen[t] {
+		s, err := d.describeStruct(t)
+		if err != nil {
+			return WireType{}, err
+		}
+		d.types[s.Name] = s
+	}
+	return WireType{Kind: "struct", Type: t.Name()}, nil
+}
+
+// MarshalsStruct checks if the Marshal method of v, which must point to a struct, reads/writes the same
+// fields as MarshalStruct, by comparing the fingerprints of both. Fields that the Marshal method only
+// marshals depending on the values of other fields are only compared as far as the value of v leads to them
+// being marshaled.
+func MarshalsStruct(v Marshaler) (ok bool) {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Pointer || val.Elem().Kind() != reflect.Struct || val.IsNil() {
+		return false
+	}
+	defer func() {
+		// The plan of a struct that MarshalStruct does not support cannot be built.
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	planOf(val.Elem().Type())
+	return Fingerprint(v) == Fingerprint(structMarshaler{v: v})
+}
+
+// structMarshaler is a Marshaler that marshals the struct that v points to using MarshalStruct.
+type structMarshaler struct {
+	v any
+}
+
+// Marshal ...
+func (s structMarshaler) Marshal(io IO) {
+	MarshalStruct(io, s.v)
+}
@@ -0,0 +1,68 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+)
+
+// handWritten is a struct with a Marshal method written by hand, which does not follow the rules of
+// MarshalStruct.
+type handWritten struct {
+	A, B int32
+}
+
+// Marshal ...
+func (h *handWritten) Marshal(io IO) {
+	io.Varint32(&h.A)
+}
+
+// described holds a field for every way in which a type implementing Marshaler is described.
+type described struct {
+	Rules       elemB
+	Struct      structSliceA
+	HandWritten handWritten
+	Flags       flags
+	Optional    Optional[elemB]
+}
+
+// TestDescribeMarshalers checks that types implementing Marshaler are only described field by field if
+// MarshalsStruct reports that they follow the rules of MarshalStruct.
+func TestDescribeMarshalers(t *testing.T) {
+	for _, tc := range []struct {
+		v    Marshaler
+		want bool
+	}{
+		// elemA marshals its int32 field as a varint without the varint option.
+		{&elemA{}, false},
+		{&elemB{}, true},
+		{&structSliceA{}, true},
+		{&tagged{}, true},
+		{&handWritten{}, false},
+		{new(flags), false},
+	} {
+		if got := MarshalsStruct(tc.v); got != tc.want {
+			t.Errorf("MarshalsStruct(%T): got %v, want %v", tc.v, got, tc.want)
+		}
+	}
+
+	types := make(map[string]StructDescription)
+	s, err := DescribeStruct(reflect.TypeOf(described{}), types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []WireType{
+		{Kind: "struct", Type: "elemB"},
+		{Kind: "struct", Type: "structSliceA"},
+		{Kind: "marshaler", Type: "handWritten"},
+		{Kind: "marshaler", Type: "flags"},
+		{Kind: "optional", Elem: &WireType{Kind: "struct", Type: "elemB"}},
+	}
+	for i, f := range s.Fields {
+		if !reflect.DeepEqual(f.Type, want[i]) {
+			t.Errorf("field %v: got %+v, want %+v", f.Name, f.Type, want[i])
+		}
+	}
+	if _, ok := types["handWritten"]; ok {
+		t.Error("handWritten described field by field")
+	}
+}
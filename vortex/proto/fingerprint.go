@@ -0,0 +1,198 @@
+package proto
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"image/color"
+	"math/big"
+	"reflect"
+	"time"
+	"unsafe"
+
+	"github.com/google/uuid"
+)
+
+// Fingerprint returns a hash of the layout of the fields that m encodes, derived from the sequence of IO
+// methods that m.Marshal calls. Changing the type, order or number of fields marshaled changes the
+// fingerprint, while the values held by m do not affect it. The elements of slices, the entries of maps and
+// the values of Optionals read/written using the functions of this package or MarshalStruct are part of the
+// fingerprint once, regardless of the number of elements or whether the value is set. Other fields that
+// are only marshaled conditionally are only part of the fingerprint if m.Marshal calls them for the value
+// passed, which is usually the zero value of the type.
+func Fingerprint(m Marshaler) (fingerprint uint64) {
+	f := &fingerprinter{h: fnv.New64a()}
+	defer func() {
+		// Marshal implementations may validate values while marshaling. Such a panic ends the fingerprint at
+		// the field that caused it, which still changes whenever the fields before it change.
+		_ = recover()
+		fingerprint = f.h.Sum64()
+	}()
+	m.Marshal(f)
+	return
+}
+
+// fingerprinter is an IO that records the sequence of methods called on it in a hash, without reading or
+// writing any data.
+type fingerprinter struct {
+	h hash.Hash64
+	// elements holds the types of the elements being added to the hash.
+	elements map[reflect.Type]bool
+	// unions holds the UnionRegistries of which the types are being added to the hash.
+	unions map[any]bool
+}
+
+// field adds a field of the type passed to the hash.
+func (f *fingerprinter) field(typ string) {
+	_, _ = f.h.Write([]byte(typ))
+	_, _ = f.h.Write([]byte{0})
+}
+
+// fingerprintElement adds the element of a slice or the value of an Optional of type T to the hash by
+// calling marshal once for a zero T, so that the fingerprint changes if the fields of the element change.
+// An element of a type already being added, such as a type holding a slice of itself, is added without its
+// fields.
+func fingerprintElement[T any](f *fingerprinter, marshal func(*T)) {
+	f.element(reflect.TypeOf((*T)(nil)).Elem(), func(p unsafe.Pointer) { marshal((*T)(p)) })
+}
+
+// fingerprintEntry adds the key and value of an entry of a map of type M to the hash of a fingerprinter as a
+// single element, like fingerprintElement does for slices.
+func fingerprintEntry[K comparable, V any, M ~map[K]V](f *fingerprinter, x *M, k func(*K), v func(*V)) {
+	f.element(reflect.TypeOf(x).Elem(), func(unsafe.Pointer) {
+		var key K
+		var val V
+		k(&key)
+		v(&val)
+	})
+}
+
+// element adds an element of the type passed to the hash like fingerprintElement, calling marshal with a
+// pointer to a new zero value of the type.
+func (f *fingerprinter) element(t reflect.Type, marshal func(p unsafe.Pointer)) {
+	f.field("element")
+	if f.elements[t] {
+		f.field("recursive element")
+		return
+	}
+	if f.elements == nil {
+		f.elements = make(map[reflect.Type]bool)
+	}
+	f.elements[t] = true
+	defer delete(f.elements, t)
+
+	marshal(reflect.New(t).UnsafePointer())
+	f.field("end element")
+}
+
+// Uint16 ...
+func (f *fingerprinter) Uint16(*uint16) { f.field("uint16") }
+
+// Int16 ...
+func (f *fingerprinter) Int16(*int16) { f.field("int16") }
+
+// Uint32 ...
+func (f *fingerprinter) Uint32(*uint32) { f.field("uint32") }
+
+// Int32 ...
+func (f *fingerprinter) Int32(*int32) { f.field("int32") }
+
+// BEInt32 ...
+func (f *fingerprinter) BEInt32(*int32) { f.field("beint32") }
+
+// Uint64 ...
+func (f *fingerprinter) Uint64(*uint64) { f.field("uint64") }
+
+// Int64 ...
+func (f *fingerprinter) Int64(*int64) { f.field("int64") }
+
+// Float32 ...
+func (f *fingerprinter) Float32(*float32) { f.field("float32") }
+
+// Uint8 ...
+func (f *fingerprinter) Uint8(*uint8) { f.field("uint8") }
+
+// Int8 ...
+func (f *fingerprinter) Int8(*int8) { f.field("int8") }
+
+// Bool ...
+func (f *fingerprinter) Bool(*bool) { f.field("bool") }
+
+// Varint64 ...
+func (f *fingerprinter) Varint64(*int64) { f.field("varint64") }
+
+// Varuint64 ...
+func (f *fingerprinter) Varuint64(*uint64) { f.field("varuint64") }
+
+// Varint32 ...
+func (f *fingerprinter) Varint32(*int32) { f.field("varint32") }
+
+// Varuint32 ...
+func (f *fingerprinter) Varuint32(*uint32) { f.field("varuint32") }
+
+// String ...
+func (f *fingerprinter) String(*string) { f.field("string") }
+
+// StringUTF ...
+func (f *fingerprinter) StringUTF(*string) { f.field("stringutf") }
+
+// ByteSlice ...
+func (f *fingerprinter) ByteSlice(*[]byte) { f.field("byteslice") }
+
+// ByteFloat ...
+func (f *fingerprinter) ByteFloat(*float32) { f.field("bytefloat") }
+
+// Bytes ...
+func (f *fingerprinter) Bytes(*[]byte) { f.field("bytes") }
+
+// RGB ...
+func (f *fingerprinter) RGB(*color.RGBA) { f.field("rgb") }
+
+// RGBA ...
+func (f *fingerprinter) RGBA(*color.RGBA) { f.field("rgba") }
+
+// VarRGBA ...
+func (f *fingerprinter) VarRGBA(*color.RGBA) { f.field("varrgba") }
+
+// Varint16 ...
+func (f *fingerprinter) Varint16(*int16) { f.field("varint16") }
+
+// Varuint16 ...
+func (f *fingerprinter) Varuint16(*uint16) { f.field("varuint16") }
+
+// Float64 ...
+func (f *fingerprinter) Float64(*float64) { f.field("float64") }
+
+// Duration ...
+func (f *fingerprinter) Duration(*time.Duration) { f.field("duration") }
+
+// Time ...
+func (f *fingerprinter) Time(*time.Time) { f.field("time") }
+
+// BigInt ...
+func (f *fingerprinter) BigInt(*big.Int) { f.field("bigint") }
+
+// UUID ...
+func (f *fingerprinter) UUID(*uuid.UUID) { f.field("uuid") }
+
+// BEUUID ...
+func (f *fingerprinter) BEUUID(*uuid.UUID) { f.field("beuuid") }
+
+// ShieldID returns 0, as the fingerprint of a value does not depend on a connection.
+func (f *fingerprinter) ShieldID() int32 { return 0 }
+
+// LimitUint32 ...
+func (f *fingerprinter) LimitUint32(uint32, uint32) {}
+
+// LimitInt32 ...
+func (f *fingerprinter) LimitInt32(int32, int32, int32) {}
+
+// UnknownEnumOption panics, ending the fingerprint.
+func (f *fingerprinter) UnknownEnumOption(value any, enum string) {
+	panic(fmt.Errorf("unknown value '%v' for enum type '%v'", value, enum))
+}
+
+// InvalidValue panics, ending the fingerprint.
+func (f *fingerprinter) InvalidValue(value any, forField, reason string) {
+	panic(fmt.Errorf("invalid value '%v' for %v: %v", value, forField, reason))
+}
@@ -0,0 +1,114 @@
+package proto
+
+import "testing"
+
+// elemA and elemB are elements with fields of a different type.
+type elemA struct{ X int32 }
+type elemB struct{ X string }
+
+// Marshal ...
+func (e *elemA) Marshal(io IO) { io.Varint32(&e.X) }
+
+// Marshal ...
+func (e *elemB) Marshal(io IO) { io.String(&e.X) }
+
+// slicesA and slicesB hold slices of elemA and elemB, read/written using Slice.
+type slicesA struct{ L []elemA }
+type slicesB struct{ L []elemB }
+
+// Marshal ...
+func (s *slicesA) Marshal(io IO) { Slice(io, &s.L) }
+
+// Marshal ...
+func (s *slicesB) Marshal(io IO) { Slice(io, &s.L) }
+
+// funcSliceA and funcSliceB hold slices read/written using FuncSlice with different functions.
+type funcSliceA struct{ L []int32 }
+type funcSliceB struct{ L []string }
+
+// Marshal ...
+func (s *funcSliceA) Marshal(io IO) { FuncSlice(io, &s.L, io.Varint32) }
+
+// Marshal ...
+func (s *funcSliceB) Marshal(io IO) { FuncSlice(io, &s.L, io.String) }
+
+// optionalA and optionalB hold Optionals of elemA and elemB.
+type optionalA struct{ O Optional[elemA] }
+type optionalB struct{ O Optional[elemB] }
+
+// Marshal ...
+func (o *optionalA) Marshal(io IO) { OptionalMarshaler(io, &o.O) }
+
+// Marshal ...
+func (o *optionalB) Marshal(io IO) { OptionalMarshaler(io, &o.O) }
+
+// mapA and mapB hold maps with values of elemA and elemB, read/written using Map and SortedMap.
+type mapA struct{ M map[string]elemA }
+type mapB struct{ M map[string]elemB }
+
+// Marshal ...
+func (m *mapA) Marshal(io IO) {
+	Map(io, &m.M, io.String, func(v *elemA) { v.Marshal(io) })
+}
+
+// Marshal ...
+func (m *mapB) Marshal(io IO) {
+	SortedMap(io, &m.M, io.String, func(v *elemB) { v.Marshal(io) })
+}
+
+// structMapA and structMapB hold maps with keys of a different type, marshaled using MarshalStruct.
+type structMapA struct{ M map[int32]string }
+type structMapB struct{ M map[string]string }
+
+// Marshal ...
+func (m *structMapA) Marshal(io IO) { MarshalStruct(io, m) }
+
+// Marshal ...
+func (m *structMapB) Marshal(io IO) { MarshalStruct(io, m) }
+
+// tree holds a slice of itself.
+type tree struct {
+	Name     string
+	Children []tree
+}
+
+// Marshal ...
+func (t *tree) Marshal(io IO) {
+	io.String(&t.Name)
+	Slice(io, &t.Children)
+}
+
+// TestFingerprintElements checks that the fingerprint of a value changes if the type of the elements of its
+// slices, maps or Optionals changes, even though the zero value holds no elements, and that it does not
+// depend on the elements that a value holds.
+func TestFingerprintElements(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b Marshaler
+	}{
+		{"Slice", &slicesA{}, &slicesB{}},
+		{"FuncSlice", &funcSliceA{}, &funcSliceB{}},
+		{"OptionalMarshaler", &optionalA{}, &optionalB{}},
+		{"Map", &mapA{}, &mapB{}},
+		{"MarshalStruct map", &structMapA{}, &structMapB{}},
+	} {
+		if Fingerprint(tc.a) == Fingerprint(tc.b) {
+			t.Errorf("%v: elements of a different type have the same fingerprint %x", tc.name, Fingerprint(tc.a))
+		}
+	}
+
+	empty, full := &slicesA{}, &slicesA{L: []elemA{{1}, {2}}}
+	if Fingerprint(empty) != Fingerprint(full) {
+		t.Errorf("fingerprint depends on the number of elements: %x and %x", Fingerprint(empty), Fingerprint(full))
+	}
+	if Fingerprint(&mapA{}) != Fingerprint(&mapA{M: map[string]elemA{"a": {1}, "b": {2}}}) {
+		t.Error("fingerprint depends on the number of map entries")
+	}
+	if Fingerprint(&optionalA{}) != Fingerprint(&optionalA{O: Option(elemA{1})}) {
+		t.Error("fingerprint depends on whether an Optional is set")
+	}
+	// A type holding a slice of itself must not recurse forever.
+	if Fingerprint(&tree{}) == Fingerprint(&slicesA{}) {
+		t.Error("recursive type has the fingerprint of a different type")
+	}
+}
@@ -1,9 +1,21 @@
 package proto
 
 import (
+	"cmp"
+	"fmt"
 	"image/color"
+	"math"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// IO is implemented by every type that values are read from or written to, most notably Reader and Writer.
+// A Marshal method implemented using only the methods of IO works for all of them. Values are passed using
+// a pointer, so that the same Marshal method reads into the value when passed a Reader and writes it when
+// passed a Writer.
 type IO interface {
 	Uint16(x *uint16)
 	Int16(x *int16)
@@ -28,6 +40,26 @@ type IO interface {
 	RGB(x *color.RGBA)
 	RGBA(x *color.RGBA)
 	VarRGBA(x *color.RGBA)
+	Varint16(x *int16)
+	Varuint16(x *uint16)
+	Float64(x *float64)
+	Duration(x *time.Duration)
+	Time(x *time.Time)
+	BigInt(x *big.Int)
+	UUID(x *uuid.UUID)
+	BEUUID(x *uuid.UUID)
+
+	// ShieldID returns the shield ID of the connection the value is read from or written to.
+	ShieldID() int32
+	// LimitUint32 panics if value exceeds max while reading. It does nothing when writing.
+	LimitUint32(value uint32, max uint32)
+	// LimitInt32 panics if value is lower than min or exceeds max while reading. It does nothing when
+	// writing.
+	LimitInt32(value int32, min, max int32)
+	// UnknownEnumOption panics with an error indicating that value is not a known option of enum.
+	UnknownEnumOption(value any, enum string)
+	// InvalidValue panics with an error indicating that value is not valid for the field forField.
+	InvalidValue(value any, forField, reason string)
 }
 
 // Marshaler is a type that can be written to or read from an IO.
@@ -107,13 +139,109 @@ func FuncIOSliceUint32Length[T any, S ~*[]T](r IO, x S, f func(IO, *T)) {
 
 const maxSliceLength = 1024
 
+// Map reads/writes a map with a varuint32 length prefix, followed by the key and value of every entry using
+// the functions k and v. The order of the entries written is random. SortedMap should be used if the
+// encoding must be deterministic, such as when it is hashed or compared.
+func Map[K comparable, V any, M ~map[K]V](r IO, x *M, k func(*K), v func(*V)) {
+	count := uint32(len(*x))
+	r.Varuint32(&count)
+	if f, ok := r.(*fingerprinter); ok {
+		fingerprintEntry(f, x, k, v)
+		return
+	}
+	if decoding(r, count) {
+		mapOfLen(r, count, x, k, v)
+		return
+	}
+	for key, val := range *x {
+		k(&key)
+		v(&val)
+	}
+}
+
+// SortedMap reads/writes a map like Map, but writes its entries in ascending order of their keys, so that
+// the same map is always encoded to the same bytes. Maps written using SortedMap may be read using Map and
+// vice versa.
+func SortedMap[K cmp.Ordered, V any, M ~map[K]V](r IO, x *M, k func(*K), v func(*V)) {
+	count := uint32(len(*x))
+	r.Varuint32(&count)
+	if f, ok := r.(*fingerprinter); ok {
+		fingerprintEntry(f, x, k, v)
+		return
+	}
+	if decoding(r, count) {
+		mapOfLen(r, count, x, k, v)
+		return
+	}
+	keys := make([]K, 0, len(*x))
+	for key := range *x {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		val := (*x)[key]
+		k(&key)
+		v(&val)
+	}
+}
+
+// mapOfLen reads l entries into a new map.
+func mapOfLen[K comparable, V any, M ~map[K]V](r IO, l uint32, x *M, k func(*K), v func(*V)) {
+	*x = make(M, l)
+	for i := uint32(0); i < l; i++ {
+		var key K
+		var val V
+		k(&key)
+		v(&val)
+		(*x)[key] = val
+	}
+}
+
+// limitUint32 returns an error if value exceeds max.
+func limitUint32(value uint32, max uint32) error {
+	if max == math.MaxUint32 {
+		// Account for 0-1 overflowing into max.
+		max = 0
+	}
+	if value > max {
+		return fmt.Errorf("uint32 %v exceeds maximum of %v", value, max)
+	}
+	return nil
+}
+
+// limitInt32 returns an error if value is lower than min or exceeds max.
+func limitInt32(value int32, min, max int32) error {
+	if value < min {
+		return fmt.Errorf("int32 %v exceeds minimum of %v", value, min)
+	} else if value > max {
+		return fmt.Errorf("int32 %v exceeds maximum of %v", value, max)
+	}
+	return nil
+}
+
+// decoding checks if the IO passed decodes values, in which case a slice of length l must be allocated
+// before its elements are read into it. If the IO is a Reader with limits enabled, it panics if l exceeds
+// the maximum length of a slice.
+func decoding(r IO, l uint32) bool {
+	switch r := r.(type) {
+	case *Reader:
+		if r.limitsEnabled && l > maxSliceLength {
+			r.panicf("slice length was too long: length of %v", l)
+		}
+		return true
+	case *JSONReader:
+		return true
+	}
+	return false
+}
+
 // SliceOfLen reads/writes the elements of a slice of type T with length l.
 func SliceOfLen[T any, S ~*[]T, A PtrMarshaler[T]](r IO, l uint32, x S) {
-	rd, reader := r.(*Reader)
-	if reader {
-		if rd.limitsEnabled && l > maxSliceLength {
-			rd.panicf("slice length was too long: length of %v", l)
-		}
+	if f, ok := r.(*fingerprinter); ok {
+		fingerprintElement(f, func(v *T) { A(v).Marshal(r) })
+		return
+	}
+	if decoding(r, l) {
 		*x = make([]T, l)
 	}
 
@@ -124,10 +252,11 @@ func SliceOfLen[T any, S ~*[]T, A PtrMarshaler[T]](r IO, l uint32, x S) {
 
 // FuncSliceOfLen reads/writes the elements of a slice of type T with length l using func f.
 func FuncSliceOfLen[T any, S ~*[]T](r IO, l uint32, x S, f func(*T)) {
-	rd, reader := r.(*Reader)
-	if reader {
-		if rd.limitsEnabled && l > maxSliceLength {
-		}
+	if fp, ok := r.(*fingerprinter); ok {
+		fingerprintElement(fp, f)
+		return
+	}
+	if decoding(r, l) {
 		*x = make([]T, l)
 	}
 
@@ -171,9 +300,24 @@ func (o Optional[T]) Value() (T, bool) {
 	return o.val, o.set
 }
 
+// optionalValue is implemented by a pointer to any Optional[T], so that MarshalStruct can marshal Optional
+// fields without knowing T.
+type optionalValue interface {
+	optional() (set *bool, val any)
+}
+
+// optional returns pointers to the fields of the Optional. val is a *T.
+func (o *Optional[T]) optional() (set *bool, val any) {
+	return &o.set, &o.val
+}
+
 // OptionalFunc reads/writes an Optional[T].
 func OptionalFunc[T any](r IO, x *Optional[T], f func(*T)) any {
 	r.Bool(&x.set)
+	if fp, ok := r.(*fingerprinter); ok {
+		fingerprintElement(fp, f)
+		return x
+	}
 	if x.set {
 		f(&x.val)
 	}
@@ -183,6 +327,10 @@ func OptionalFunc[T any](r IO, x *Optional[T], f func(*T)) any {
 // OptionalFuncIO reads/writes an Optional[T].
 func OptionalFuncIO[T any](r IO, x *Optional[T], f func(IO, *T)) any {
 	r.Bool(&x.set)
+	if fp, ok := r.(*fingerprinter); ok {
+		fingerprintElement(fp, func(v *T) { f(r, v) })
+		return x
+	}
 	if x.set {
 		f(r, &x.val)
 	}
@@ -192,6 +340,10 @@ func OptionalFuncIO[T any](r IO, x *Optional[T], f func(IO, *T)) any {
 // OptionalMarshaler reads/writes an Optional assuming *T implements Marshaler.
 func OptionalMarshaler[T any, A PtrMarshaler[T]](r IO, x *Optional[T]) {
 	r.Bool(&x.set)
+	if f, ok := r.(*fingerprinter); ok {
+		fingerprintElement(f, func(v *T) { A(v).Marshal(r) })
+		return
+	}
 	if x.set {
 		A(&x.val).Marshal(r)
 	}
@@ -0,0 +1,269 @@
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// conformance is a value with a field for every method of IO that reads or writes a value, and a few fields
+// read and written using the helpers built on top of them.
+type conformance struct {
+	U8     uint8
+	I8     int8
+	B      bool
+	U16    uint16
+	I16    int16
+	U32    uint32
+	I32    int32
+	BEI32  int32
+	U64    uint64
+	I64    int64
+	F32    float32
+	VI64   int64
+	VU64   uint64
+	VI32   int32
+	VU32   uint32
+	VI16   int16
+	VU16   uint16
+	F64    float64
+	S      string
+	SUTF   string
+	BS     []byte
+	BF     float32
+	RGB    color.RGBA
+	RGBA   color.RGBA
+	VRGBA  color.RGBA
+	Dur    time.Duration
+	Time   time.Time
+	Big    *big.Int
+	UUID   uuid.UUID
+	BEUUID uuid.UUID
+	Slice  []string
+	Map    map[string]int32
+	Opt    Optional[uint32]
+	Rest   []byte
+}
+
+// Marshal ...
+func (c *conformance) Marshal(io IO) {
+	io.Uint8(&c.U8)
+	io.Int8(&c.I8)
+	io.Bool(&c.B)
+	io.Uint16(&c.U16)
+	io.Int16(&c.I16)
+	io.Uint32(&c.U32)
+	io.Int32(&c.I32)
+	io.BEInt32(&c.BEI32)
+	io.Uint64(&c.U64)
+	io.Int64(&c.I64)
+	io.Float32(&c.F32)
+	io.Varint64(&c.VI64)
+	io.Varuint64(&c.VU64)
+	io.Varint32(&c.VI32)
+	io.Varuint32(&c.VU32)
+	io.Varint16(&c.VI16)
+	io.Varuint16(&c.VU16)
+	io.Float64(&c.F64)
+	io.String(&c.S)
+	io.StringUTF(&c.SUTF)
+	io.ByteSlice(&c.BS)
+	io.ByteFloat(&c.BF)
+	io.RGB(&c.RGB)
+	io.RGBA(&c.RGBA)
+	io.VarRGBA(&c.VRGBA)
+	io.Duration(&c.Dur)
+	io.Time(&c.Time)
+	if c.Big == nil {
+		c.Big = new(big.Int)
+	}
+	io.BigInt(c.Big)
+	io.UUID(&c.UUID)
+	io.BEUUID(&c.BEUUID)
+	FuncSlice(io, &c.Slice, io.String)
+	SortedMap(io, &c.Map, io.String, io.Varint32)
+	OptionalFunc(io, &c.Opt, io.Varuint32)
+	// Bytes reads all remaining bytes, so it must come last.
+	io.Bytes(&c.Rest)
+}
+
+// conformanceValue returns a conformance with values at the edges of the range of every field. Values of
+// lossy encodings, such as ByteFloat and RGB, are picked so that they are encoded exactly.
+func conformanceValue() *conformance {
+	big, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	return &conformance{
+		U8:     math.MaxUint8,
+		I8:     math.MinInt8,
+		B:      true,
+		U16:    0xBEEF,
+		I16:    math.MinInt16,
+		U32:    0xDEADBEEF,
+		I32:    math.MinInt32,
+		BEI32:  0x01020304,
+		U64:    math.MaxUint64,
+		I64:    math.MinInt64,
+		F32:    -1.5,
+		VI64:   math.MinInt64,
+		VU64:   math.MaxUint64,
+		VI32:   math.MinInt32,
+		VU32:   math.MaxUint32,
+		VI16:   math.MinInt16,
+		VU16:   math.MaxUint16,
+		F64:    math.Inf(-1),
+		S:      "hello, wörld",
+		SUTF:   "utf ✓",
+		BS:     []byte{0, 1, 2, 0xff},
+		BF:     90,
+		RGB:    color.RGBA{R: 255, B: 255},
+		RGBA:   color.RGBA{R: 1, G: 2, B: 3, A: 4},
+		VRGBA:  color.RGBA{R: 255, G: 128, B: 0, A: 255},
+		Dur:    -90*time.Minute - 1,
+		Time:   time.Date(2024, 2, 29, 23, 59, 59, 999999999, time.UTC),
+		Big:    big,
+		UUID:   uuid.MustParse("0f0e0d0c-0b0a-0908-0706-050403020100"),
+		BEUUID: uuid.MustParse("00010203-0405-0607-0809-0a0b0c0d0e0f"),
+		Slice:  []string{"a", "", "c"},
+		Map:    map[string]int32{"x": -1, "y": 2},
+		Opt:    Option[uint32](7),
+		Rest:   []byte("rest"),
+	}
+}
+
+// checkConformance fails the test if got does not hold the same values as want.
+func checkConformance(t *testing.T, got, want *conformance) {
+	t.Helper()
+	if got.Big.Cmp(want.Big) != 0 {
+		t.Errorf("BigInt: got %v, want %v", got.Big, want.Big)
+	}
+	if !got.Time.Equal(want.Time) {
+		t.Errorf("Time: got %v, want %v", got.Time, want.Time)
+	}
+	g, w := *got, *want
+	g.Big, w.Big, g.Time, w.Time = nil, nil, time.Time{}, time.Time{}
+	gv, wv := reflect.ValueOf(g), reflect.ValueOf(w)
+	for i := 0; i < gv.NumField(); i++ {
+		if !reflect.DeepEqual(gv.Field(i).Interface(), wv.Field(i).Interface()) {
+			t.Errorf("%v: got %v, want %v", gv.Type().Field(i).Name, gv.Field(i), wv.Field(i))
+		}
+	}
+}
+
+// TestRoundTrip checks that every IO that reads values reads the values written by the IO that writes them
+// for every method of IO, and that a SizeCounter counts the number of bytes written.
+func TestRoundTrip(t *testing.T) {
+	want := conformanceValue()
+	buf := new(bytes.Buffer)
+	want.Marshal(NewWriter(buf, 1))
+	encoded := buf.Bytes()
+
+	if size := Size(want, 1); size != len(encoded) {
+		t.Errorf("SizeCounter: size %v, encoded %v bytes", size, len(encoded))
+	}
+
+	t.Run("Reader", func(t *testing.T) {
+		got := new(conformance)
+		got.Marshal(NewReader(bytes.NewReader(encoded), 1, true))
+		checkConformance(t, got, want)
+	})
+	t.Run("ZeroCopyReader", func(t *testing.T) {
+		got := new(conformance)
+		got.Marshal(NewZeroCopyReader(encoded, 1, true))
+		checkConformance(t, got, want)
+	})
+	t.Run("JSON", func(t *testing.T) {
+		b, err := EncodeJSON(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := new(conformance)
+		if err := DecodeJSON(b, got); err != nil {
+			t.Fatal(err)
+		}
+		checkConformance(t, got, want)
+	})
+}
+
+// TestSizeFields checks that a SizeCounter counts the number of bytes written for every field separately,
+// including the lengths of varints at their boundaries.
+func TestSizeFields(t *testing.T) {
+	for _, x := range []uint64{0, 1<<7 - 1, 1 << 7, 1<<14 - 1, 1 << 14, 1<<63 - 1, math.MaxUint64} {
+		buf := new(bytes.Buffer)
+		NewWriter(buf, 1).Varuint64(&x)
+		s := NewSizeCounter(1)
+		s.Varuint64(&x)
+		if s.Size() != buf.Len() {
+			t.Errorf("Varuint64(%v): size %v, encoded %v bytes", x, s.Size(), buf.Len())
+		}
+	}
+	for _, x := range []int64{0, -1, 63, -64, 64, -65, math.MaxInt64, math.MinInt64} {
+		buf := new(bytes.Buffer)
+		NewWriter(buf, 1).Varint64(&x)
+		s := NewSizeCounter(1)
+		s.Varint64(&x)
+		if s.Size() != buf.Len() {
+			t.Errorf("Varint64(%v): size %v, encoded %v bytes", x, s.Size(), buf.Len())
+		}
+	}
+}
+
+// TestChecks checks that ShieldID, LimitUint32, LimitInt32, UnknownEnumOption and InvalidValue behave as
+// documented on IO for every implementation.
+func TestChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		io       IO
+		shieldID int32
+		reading  bool
+	}{
+		{"Reader", NewReader(bytes.NewReader(nil), 5, true), 5, true},
+		{"ZeroCopyReader", NewZeroCopyReader(nil, 5, true), 5, true},
+		{"Writer", NewWriter(new(bytes.Buffer), 5), 5, false},
+		{"SizeCounter", NewSizeCounter(5), 5, false},
+		{"JSONWriter", NewJSONWriter(), 0, false},
+		{"JSONReader", NewJSONReader(nil), 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if id := tc.io.ShieldID(); id != tc.shieldID {
+				t.Errorf("ShieldID: got %v, want %v", id, tc.shieldID)
+			}
+			for _, c := range []struct {
+				name   string
+				f      func()
+				panics bool
+			}{
+				{"LimitUint32 within", func() { tc.io.LimitUint32(4, 4) }, false},
+				{"LimitUint32 exceeded", func() { tc.io.LimitUint32(5, 4) }, tc.reading},
+				{"LimitInt32 within", func() { tc.io.LimitInt32(-4, -4, 4) }, false},
+				{"LimitInt32 below", func() { tc.io.LimitInt32(-5, -4, 4) }, tc.reading},
+				{"LimitInt32 above", func() { tc.io.LimitInt32(5, -4, 4) }, tc.reading},
+				{"UnknownEnumOption", func() { tc.io.UnknownEnumOption(3, "enum") }, true},
+				{"InvalidValue", func() { tc.io.InvalidValue(3, "field", "reason") }, true},
+			} {
+				if panicked := panics(c.f); panicked != c.panics {
+					t.Errorf("%v: panicked %v, want %v", c.name, panicked, c.panics)
+				}
+			}
+		})
+	}
+}
+
+// panics calls f and reports if it panicked with an error.
+func panics(f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(error); !ok {
+				panic(fmt.Sprintf("panicked with %T instead of an error", r))
+			}
+			panicked = true
+		}
+	}()
+	f()
+	return false
+}
@@ -0,0 +1,386 @@
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JSONField is a single field of a value read/written by a JSONReader or JSONWriter. Every call of an IO
+// method is a field, including the length prefixes written by helpers such as Slice.
+type JSONField struct {
+	// Index is the position of the field in the fields of the value.
+	Index int `json:"index"`
+	// Type is the name of the IO method the field is read/written with in lower case, such as "varuint32",
+	// "string" or "rgba".
+	Type string `json:"type"`
+	// Value holds the value of the field as JSON. Byte slices are encoded as base64 strings and colours
+	// as objects, as done by encoding/json. Floats that are NaN or infinite are encoded as the strings
+	// "NaN", "+Inf" and "-Inf".
+	Value json.RawMessage `json:"value"`
+}
+
+// EncodeJSON encodes the value passed to JSON using a JSONWriter, such as to log a packet. The JSON is an
+// array of JSONFields, which can be decoded back into the value using DecodeJSON.
+func EncodeJSON(m Marshaler) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("encode json: %v", r)
+		}
+	}()
+	w := NewJSONWriter()
+	m.Marshal(w)
+	return json.Marshal(w.Fields())
+}
+
+// DecodeJSON decodes JSON produced by EncodeJSON into the value passed using a JSONReader.
+func DecodeJSON(b []byte, m Marshaler) (err error) {
+	var fields []JSONField
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return fmt.Errorf("decode json: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("decode json: %v", r)
+		}
+	}()
+	m.Marshal(NewJSONReader(fields))
+	return nil
+}
+
+// JSONWriter is an IO that records every field written to it as a JSONField, so that the Marshal method of
+// any value can render it as JSON.
+type JSONWriter struct {
+	fields []JSONField
+}
+
+// NewJSONWriter creates a JSONWriter without any fields.
+func NewJSONWriter() *JSONWriter {
+	return &JSONWriter{fields: []JSONField{}}
+}
+
+// Fields returns the fields written to the JSONWriter, in the order in which they were written.
+func (w *JSONWriter) Fields() []JSONField {
+	return w.fields
+}
+
+// field adds a field with the type and value passed.
+func (w *JSONWriter) field(typ string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("field %v of type %v: %w", len(w.fields), typ, err))
+	}
+	w.fields = append(w.fields, JSONField{Index: len(w.fields), Type: typ, Value: b})
+}
+
+// float adds a field with a float value, which is written as a string if it cannot be represented in JSON.
+func (w *JSONWriter) float(typ string, f float64) {
+	switch {
+	case math.IsNaN(f):
+		w.field(typ, "NaN")
+	case math.IsInf(f, 1):
+		w.field(typ, "+Inf")
+	case math.IsInf(f, -1):
+		w.field(typ, "-Inf")
+	default:
+		w.field(typ, f)
+	}
+}
+
+// Uint8 ...
+func (w *JSONWriter) Uint8(x *uint8) { w.field("uint8", *x) }
+
+// Int8 ...
+func (w *JSONWriter) Int8(x *int8) { w.field("int8", *x) }
+
+// Bool ...
+func (w *JSONWriter) Bool(x *bool) { w.field("bool", *x) }
+
+// Uint16 ...
+func (w *JSONWriter) Uint16(x *uint16) { w.field("uint16", *x) }
+
+// Int16 ...
+func (w *JSONWriter) Int16(x *int16) { w.field("int16", *x) }
+
+// Uint32 ...
+func (w *JSONWriter) Uint32(x *uint32) { w.field("uint32", *x) }
+
+// Int32 ...
+func (w *JSONWriter) Int32(x *int32) { w.field("int32", *x) }
+
+// BEInt32 ...
+func (w *JSONWriter) BEInt32(x *int32) { w.field("beint32", *x) }
+
+// Uint64 ...
+func (w *JSONWriter) Uint64(x *uint64) { w.field("uint64", *x) }
+
+// Int64 ...
+func (w *JSONWriter) Int64(x *int64) { w.field("int64", *x) }
+
+// Float32 ...
+func (w *JSONWriter) Float32(x *float32) { w.float("float32", float64(*x)) }
+
+// Varint64 ...
+func (w *JSONWriter) Varint64(x *int64) { w.field("varint64", *x) }
+
+// Varuint64 ...
+func (w *JSONWriter) Varuint64(x *uint64) { w.field("varuint64", *x) }
+
+// Varint32 ...
+func (w *JSONWriter) Varint32(x *int32) { w.field("varint32", *x) }
+
+// Varuint32 ...
+func (w *JSONWriter) Varuint32(x *uint32) { w.field("varuint32", *x) }
+
+// String ...
+func (w *JSONWriter) String(x *string) { w.field("string", *x) }
+
+// StringUTF ...
+func (w *JSONWriter) StringUTF(x *string) { w.field("stringutf", *x) }
+
+// ByteSlice ...
+func (w *JSONWriter) ByteSlice(x *[]byte) { w.field("byteslice", *x) }
+
+// ByteFloat ...
+func (w *JSONWriter) ByteFloat(x *float32) { w.float("bytefloat", float64(*x)) }
+
+// Bytes ...
+func (w *JSONWriter) Bytes(x *[]byte) { w.field("bytes", *x) }
+
+// RGB ...
+func (w *JSONWriter) RGB(x *color.RGBA) { w.field("rgb", *x) }
+
+// RGBA ...
+func (w *JSONWriter) RGBA(x *color.RGBA) { w.field("rgba", *x) }
+
+// VarRGBA ...
+func (w *JSONWriter) VarRGBA(x *color.RGBA) { w.field("varrgba", *x) }
+
+// Varint16 ...
+func (w *JSONWriter) Varint16(x *int16) { w.field("varint16", *x) }
+
+// Varuint16 ...
+func (w *JSONWriter) Varuint16(x *uint16) { w.field("varuint16", *x) }
+
+// Float64 ...
+func (w *JSONWriter) Float64(x *float64) { w.float("float64", *x) }
+
+// Duration writes the duration as a string such as "1m30s", as returned by time.Duration.String.
+func (w *JSONWriter) Duration(x *time.Duration) { w.field("duration", x.String()) }
+
+// Time writes the time as an RFC 3339 string with nanoseconds, as done by encoding/json.
+func (w *JSONWriter) Time(x *time.Time) { w.field("time", *x) }
+
+// BigInt writes the integer as a JSON number, as done by encoding/json.
+func (w *JSONWriter) BigInt(x *big.Int) { w.field("bigint", x) }
+
+// UUID writes the UUID as a string in its canonical form, as done by encoding/json.
+func (w *JSONWriter) UUID(x *uuid.UUID) { w.field("uuid", *x) }
+
+// BEUUID writes the UUID as a string in its canonical form, as done by encoding/json.
+func (w *JSONWriter) BEUUID(x *uuid.UUID) { w.field("beuuid", *x) }
+
+// ShieldID returns 0, as a JSONWriter does not write to a connection.
+func (w *JSONWriter) ShieldID() int32 { return 0 }
+
+// LimitUint32 does nothing, like Writer.LimitUint32.
+func (w *JSONWriter) LimitUint32(uint32, uint32) {}
+
+// LimitInt32 does nothing, like Writer.LimitInt32.
+func (w *JSONWriter) LimitInt32(int32, int32, int32) {}
+
+// UnknownEnumOption panics with an unknown enum option error, like Writer.UnknownEnumOption.
+func (w *JSONWriter) UnknownEnumOption(value any, enum string) {
+	panic(fmt.Errorf("unknown value '%v' for enum type '%v'", value, enum))
+}
+
+// InvalidValue panics with an invalid value error, like Writer.InvalidValue.
+func (w *JSONWriter) InvalidValue(value any, forField, reason string) {
+	panic(fmt.Errorf("invalid value '%v' for %v: %v", value, forField, reason))
+}
+
+// JSONReader is an IO that reads fields from a list of JSONFields, such as those produced by a JSONWriter.
+// Like Reader, a JSONReader panics if a field cannot be read, such as when the type of the next field does
+// not match the IO method called.
+type JSONReader struct {
+	fields []JSONField
+	i      int
+}
+
+// NewJSONReader creates a JSONReader that reads the fields passed.
+func NewJSONReader(fields []JSONField) *JSONReader {
+	return &JSONReader{fields: fields}
+}
+
+// field reads the next field, which must be of the type passed, into the value that v points to.
+func (r *JSONReader) field(typ string, v any) {
+	if r.i >= len(r.fields) {
+		r.panicf("field %v of type %v: unexpected end of fields", r.i, typ)
+	}
+	f := r.fields[r.i]
+	if f.Type != typ {
+		r.panicf("field %v: expected type %v, got %v", r.i, typ, f.Type)
+	}
+	if err := json.Unmarshal(f.Value, v); err != nil {
+		r.panicf("field %v of type %v: %v", r.i, typ, err)
+	}
+	r.i++
+}
+
+// float reads the next field, which must be of the type passed, into a float.
+func (r *JSONReader) float(typ string) float64 {
+	var v any
+	r.field(typ, &v)
+	switch v {
+	case "NaN":
+		return math.NaN()
+	case "+Inf":
+		return math.Inf(1)
+	case "-Inf":
+		return math.Inf(-1)
+	}
+	f, ok := v.(float64)
+	if !ok {
+		r.panicf("field %v of type %v: invalid float %v", r.i-1, typ, v)
+	}
+	return f
+}
+
+// Uint8 ...
+func (r *JSONReader) Uint8(x *uint8) { r.field("uint8", x) }
+
+// Int8 ...
+func (r *JSONReader) Int8(x *int8) { r.field("int8", x) }
+
+// Bool ...
+func (r *JSONReader) Bool(x *bool) { r.field("bool", x) }
+
+// Uint16 ...
+func (r *JSONReader) Uint16(x *uint16) { r.field("uint16", x) }
+
+// Int16 ...
+func (r *JSONReader) Int16(x *int16) { r.field("int16", x) }
+
+// Uint32 ...
+func (r *JSONReader) Uint32(x *uint32) { r.field("uint32", x) }
+
+// Int32 ...
+func (r *JSONReader) Int32(x *int32) { r.field("int32", x) }
+
+// BEInt32 ...
+func (r *JSONReader) BEInt32(x *int32) { r.field("beint32", x) }
+
+// Uint64 ...
+func (r *JSONReader) Uint64(x *uint64) { r.field("uint64", x) }
+
+// Int64 ...
+func (r *JSONReader) Int64(x *int64) { r.field("int64", x) }
+
+// Float32 ...
+func (r *JSONReader) Float32(x *float32) { *x = float32(r.float("float32")) }
+
+// Varint64 ...
+func (r *JSONReader) Varint64(x *int64) { r.field("varint64", x) }
+
+// Varuint64 ...
+func (r *JSONReader) Varuint64(x *uint64) { r.field("varuint64", x) }
+
+// Varint32 ...
+func (r *JSONReader) Varint32(x *int32) { r.field("varint32", x) }
+
+// Varuint32 ...
+func (r *JSONReader) Varuint32(x *uint32) { r.field("varuint32", x) }
+
+// String ...
+func (r *JSONReader) String(x *string) { r.field("string", x) }
+
+// StringUTF ...
+func (r *JSONReader) StringUTF(x *string) { r.field("stringutf", x) }
+
+// ByteSlice ...
+func (r *JSONReader) ByteSlice(x *[]byte) { r.field("byteslice", x) }
+
+// ByteFloat ...
+func (r *JSONReader) ByteFloat(x *float32) { *x = float32(r.float("bytefloat")) }
+
+// Bytes ...
+func (r *JSONReader) Bytes(x *[]byte) { r.field("bytes", x) }
+
+// RGB ...
+func (r *JSONReader) RGB(x *color.RGBA) { r.field("rgb", x) }
+
+// RGBA ...
+func (r *JSONReader) RGBA(x *color.RGBA) { r.field("rgba", x) }
+
+// VarRGBA ...
+func (r *JSONReader) VarRGBA(x *color.RGBA) { r.field("varrgba", x) }
+
+// Varint16 ...
+func (r *JSONReader) Varint16(x *int16) { r.field("varint16", x) }
+
+// Varuint16 ...
+func (r *JSONReader) Varuint16(x *uint16) { r.field("varuint16", x) }
+
+// Float64 ...
+func (r *JSONReader) Float64(x *float64) { *x = r.float("float64") }
+
+// Duration ...
+func (r *JSONReader) Duration(x *time.Duration) {
+	var s string
+	r.field("duration", &s)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		r.panicf("field %v of type duration: %v", r.i-1, err)
+	}
+	*x = d
+}
+
+// Time ...
+func (r *JSONReader) Time(x *time.Time) { r.field("time", x) }
+
+// BigInt ...
+func (r *JSONReader) BigInt(x *big.Int) { r.field("bigint", x) }
+
+// UUID ...
+func (r *JSONReader) UUID(x *uuid.UUID) { r.field("uuid", x) }
+
+// BEUUID ...
+func (r *JSONReader) BEUUID(x *uuid.UUID) { r.field("beuuid", x) }
+
+// ShieldID returns 0, as a JSONReader does not read from a connection.
+func (r *JSONReader) ShieldID() int32 { return 0 }
+
+// LimitUint32 checks if the value passed is lower than the limit passed. If not, the JSONReader panics.
+func (r *JSONReader) LimitUint32(value uint32, max uint32) {
+	if err := limitUint32(value, max); err != nil {
+		panic(err)
+	}
+}
+
+// LimitInt32 checks if the value passed is lower than the limit passed and higher than the minimum. If not,
+// the JSONReader panics.
+func (r *JSONReader) LimitInt32(value int32, min, max int32) {
+	if err := limitInt32(value, min, max); err != nil {
+		panic(err)
+	}
+}
+
+// UnknownEnumOption panics with an unknown enum option error.
+func (r *JSONReader) UnknownEnumOption(value any, enum string) {
+	r.panicf("unknown value '%v' for enum type '%v'", value, enum)
+}
+
+// InvalidValue panics with an error indicating that the value passed is not valid for a specific field.
+func (r *JSONReader) InvalidValue(value any, forField, reason string) {
+	r.panicf("invalid value '%v' for %v: %v", value, forField, reason)
+}
+
+// panicf panics with the format and values passed.
+func (r *JSONReader) panicf(format string, a ...any) {
+	panic(fmt.Errorf(format, a...))
+}
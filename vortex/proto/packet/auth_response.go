@@ -7,10 +7,53 @@ import (
 const (
 	AuthResponseSuccess uint32 = iota
 	AuthResponseInvalidToken
+	// AuthResponseIncompatibleProtocol is returned if the protocol version of the Login is not supported
+	// by the service, or if the Login could not be decoded at all, as is the case for peers implementing a
+	// protocol older than the Protocol field.
+	AuthResponseIncompatibleProtocol
+	// AuthResponseIncompatibleSchema is returned if a packet in the Schema of the Login does not match the
+	// schema of the packet with the same ID registered with the service.
+	AuthResponseIncompatibleSchema
+	// AuthResponseInvalidPublicKey is returned if the PublicKey of the Login is not a valid X25519 public
+	// key while an encryption algorithm was agreed on.
+	AuthResponseInvalidPublicKey
+	// AuthResponseTooManyConnections is returned if the service already has the maximum number of
+	// connections logged in with the identity of the Login.
+	AuthResponseTooManyConnections
+	// AuthResponseDraining is returned if the service is draining and does not accept new peers. The peer
+	// should log in to another node of the service.
+	AuthResponseDraining
 )
 
 type AuthResponse struct {
-	Code uint32
+	Code uint32 `vortex:"varint"`
+	// Protocol is the protocol version of the connection, which is the Protocol of the Login, and the fields
+	// of the response are read/written as of that version. If the login was rejected with
+	// AuthResponseIncompatibleProtocol, it is CurrentProtocol of the service instead.
+	Protocol uint32 `vortex:"varint"`
+	// Capabilities is the bitset of the Capability constants supported by both the service and the
+	// connection. Features are only used if their capability is set.
+	Capabilities uint32 `vortex:"varint"`
+	// Compression is the ID of the compression algorithm picked from those offered in Login. Frames above
+	// the compression threshold of either side may be compressed with it after the response was sent. It
+	// is CompressionAlgorithmNone if none of the algorithms offered are supported.
+	Compression uint16
+	// Schema holds the Schema of every packet the service sends if the login succeeded, which the connection
+	// checks against the packets it receives. If the login was rejected with AuthResponseIncompatibleSchema,
+	// it holds the Schema of every packet the service receives instead, so that the connection can find
+	// which of the packets it sends do not match. It is only read/written from ProtocolSchema on.
+	Schema []Schema
+	// Encryption is the ID of the encryption algorithm picked from those offered in Login. All frames sent
+	// by either side after the response are encrypted with it. It is EncryptionAlgorithmNone if none of the
+	// algorithms offered are supported, in which case frames are not encrypted. Encryption, PublicKey and
+	// KeyProof are only read/written from ProtocolEncryption on.
+	Encryption uint16
+	// PublicKey is the ephemeral X25519 public key of the service if an encryption algorithm was picked.
+	PublicKey []byte
+	// KeyProof is an HMAC-SHA256 keyed with the token of the service over the public keys of both sides and
+	// Encryption, set if an encryption algorithm was picked. It proves to the connection that PublicKey was
+	// sent by a party knowing the token.
+	KeyProof []byte
 }
 
 func (a *AuthResponse) ID() uint32 {
@@ -19,4 +62,17 @@ func (a *AuthResponse) ID() uint32 {
 
 func (a *AuthResponse) Marshal(io proto.IO) {
 	io.Varuint32(&a.Code)
+	io.Varuint32(&a.Protocol)
+	io.Varuint32(&a.Capabilities)
+	io.Uint16(&a.Compression)
+	if a.Protocol < ProtocolSchema {
+		return
+	}
+	proto.Slice(io, &a.Schema)
+	if a.Protocol < ProtocolEncryption {
+		return
+	}
+	io.Uint16(&a.Encryption)
+	io.ByteSlice(&a.PublicKey)
+	io.ByteSlice(&a.KeyProof)
 }
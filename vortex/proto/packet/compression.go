@@ -0,0 +1,151 @@
+package packet
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/vortex-service/vortex/vortex/internal"
+)
+
+const (
+	CompressionAlgorithmNone uint16 = iota
+	CompressionAlgorithmFlate
+	CompressionAlgorithmSnappy
+)
+
+// Compression represents a compression algorithm that frames may be compressed with after it was
+// negotiated in the login handshake.
+type Compression interface {
+	// EncodeCompression encodes the compression algorithm into a uint16 ID, as sent in Login and
+	// AuthResponse.
+	EncodeCompression() uint16
+	// Compress compresses the given data and returns the compressed data.
+	Compress(decompressed []byte) ([]byte, error)
+	// Decompress decompresses the given data and returns the decompressed data.
+	Decompress(compressed []byte) ([]byte, error)
+}
+
+var (
+	// FlateCompression is the implementation of the Flate compression algorithm.
+	FlateCompression flateCompression
+	// SnappyCompression is the implementation of the Snappy compression algorithm.
+	SnappyCompression snappyCompression
+)
+
+// CompressionByID returns the Compression with the ID passed. False is returned if no algorithm with the ID
+// exists, or if id is CompressionAlgorithmNone.
+func CompressionByID(id uint16) (Compression, bool) {
+	switch id {
+	case CompressionAlgorithmFlate:
+		return FlateCompression, true
+	case CompressionAlgorithmSnappy:
+		return SnappyCompression, true
+	}
+	return nil, false
+}
+
+// maxDecompressedSize is the maximum size of a frame after decompressing it. Frames that decompress to a
+// larger size are rejected, so that small compressed frames cannot exhaust memory.
+const maxDecompressedSize = 16 * 1024 * 1024
+
+// flateCompression is the implementation of the Flate compression algorithm.
+type flateCompression struct{}
+
+// snappyCompression is the implementation of the Snappy compression algorithm.
+type snappyCompression struct{}
+
+// flateDecompressPool is a sync.Pool for io.ReadCloser flate readers. These are pooled for connections.
+var flateDecompressPool = sync.Pool{
+	New: func() any { return flate.NewReader(bytes.NewReader(nil)) },
+}
+
+// flateCompressPool is a sync.Pool for flate writers. These are pooled for connections.
+var flateCompressPool = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(io.Discard, 6)
+		return w
+	},
+}
+
+// EncodeCompression ...
+func (flateCompression) EncodeCompression() uint16 {
+	return CompressionAlgorithmFlate
+}
+
+// Compress ...
+func (flateCompression) Compress(decompressed []byte) ([]byte, error) {
+	compressed := internal.BufferPool.Get().(*bytes.Buffer)
+	w := flateCompressPool.Get().(*flate.Writer)
+
+	defer func() {
+		// Reset the buffer, so we can return it to the buffer pool safely.
+		compressed.Reset()
+		internal.BufferPool.Put(compressed)
+		flateCompressPool.Put(w)
+	}()
+
+	w.Reset(compressed)
+
+	_, err := w.Write(decompressed)
+	if err != nil {
+		return nil, fmt.Errorf("compress flate: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close flate writer: %w", err)
+	}
+	return append([]byte(nil), compressed.Bytes()...), nil
+}
+
+// Decompress ...
+func (flateCompression) Decompress(compressed []byte) ([]byte, error) {
+	buf := bytes.NewReader(compressed)
+	c := flateDecompressPool.Get().(io.ReadCloser)
+	defer flateDecompressPool.Put(c)
+
+	if err := c.(flate.Resetter).Reset(buf, nil); err != nil {
+		return nil, fmt.Errorf("reset flate: %w", err)
+	}
+	_ = c.Close()
+
+	// Guard against zip bombs by limiting the allocation to a prespecified size.
+	decompressed, err := io.ReadAll(io.LimitReader(c, maxDecompressedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress flate: %w", err)
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompress flate: size exceeds maximum of %v bytes", maxDecompressedSize)
+	}
+	return decompressed, nil
+}
+
+// EncodeCompression ...
+func (snappyCompression) EncodeCompression() uint16 {
+	return CompressionAlgorithmSnappy
+}
+
+// Compress ...
+func (snappyCompression) Compress(decompressed []byte) ([]byte, error) {
+	return snappy.Encode(nil, decompressed), nil
+}
+
+// Decompress ...
+func (snappyCompression) Decompress(compressed []byte) ([]byte, error) {
+	// Snappy stores the decoded length up front, so it is checked before allocating.
+	l, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("decompress snappy: %w", err)
+	}
+	if l > maxDecompressedSize {
+		return nil, fmt.Errorf("decompress snappy: size exceeds maximum of %v bytes", maxDecompressedSize)
+	}
+	decompressed, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("decompress snappy: %w", err)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,45 @@
+package packet
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// Description is a machine-readable description of a set of packets, from which codecs for the packets
+// may be generated in other languages. It is encoded as JSON by encoding/json.
+type Description struct {
+	// Packets holds the description of every packet, sorted by ID.
+	Packets []PacketDescription `json:"packets"`
+	// Types holds the struct types that the fields of the packets refer to, by name.
+	Types map[string]proto.StructDescription `json:"types"`
+}
+
+// PacketDescription describes a single packet.
+type PacketDescription struct {
+	ID uint32 `json:"id"`
+	proto.StructDescription
+}
+
+// Describe describes the packets passed. The fields of the packets are described as marshaled by
+// proto.MarshalStruct, so the description only matches packets that are marshaled with it or generated by
+// vortexgen. An error is returned if proto.MarshalsStruct reports that a packet passed is marshaled
+// differently. Packets of which the Marshal method depends on their values, such as Login, are described as
+// marshaled for the value passed.
+func Describe(packets ...Packet) (*Description, error) {
+	d := &Description{Packets: []PacketDescription{}, Types: make(map[string]proto.StructDescription)}
+	for _, pk := range packets {
+		if !proto.MarshalsStruct(pk) {
+			return nil, fmt.Errorf("describe %T: Marshal does not follow the rules of proto.MarshalStruct", pk)
+		}
+		s, err := proto.DescribeStruct(reflect.TypeOf(pk).Elem(), d.Types)
+		if err != nil {
+			return nil, err
+		}
+		d.Packets = append(d.Packets, PacketDescription{ID: pk.ID(), StructDescription: s})
+	}
+	sort.Slice(d.Packets, func(i, j int) bool { return d.Packets[i].ID < d.Packets[j].ID })
+	return d, nil
+}
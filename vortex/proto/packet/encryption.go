@@ -0,0 +1,75 @@
+package packet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	EncryptionAlgorithmNone uint16 = iota
+	EncryptionAlgorithmAESGCM
+	EncryptionAlgorithmChaCha20Poly1305
+)
+
+// Encryption represents an AEAD cipher that frames may be encrypted with after it was negotiated in the
+// login handshake. The keys of the cipher are derived from an X25519 key exchange of which the public keys
+// are sent in Login and AuthResponse.
+type Encryption interface {
+	// EncodeEncryption encodes the encryption algorithm into a uint16 ID, as sent in Login and
+	// AuthResponse.
+	EncodeEncryption() uint16
+	// NewAEAD creates the cipher with the 32 byte key passed. The cipher must use 12 byte nonces.
+	NewAEAD(key []byte) (cipher.AEAD, error)
+}
+
+var (
+	// AESGCMEncryption is the implementation of AES-256 in Galois/Counter Mode.
+	AESGCMEncryption aesGCMEncryption
+	// ChaCha20Poly1305Encryption is the implementation of ChaCha20-Poly1305 as described in RFC 8439. It is
+	// faster than AESGCMEncryption on hardware without AES instructions.
+	ChaCha20Poly1305Encryption chaCha20Poly1305Encryption
+)
+
+// EncryptionByID returns the Encryption with the ID passed. False is returned if no algorithm with the ID
+// exists, or if id is EncryptionAlgorithmNone.
+func EncryptionByID(id uint16) (Encryption, bool) {
+	switch id {
+	case EncryptionAlgorithmAESGCM:
+		return AESGCMEncryption, true
+	case EncryptionAlgorithmChaCha20Poly1305:
+		return ChaCha20Poly1305Encryption, true
+	}
+	return nil, false
+}
+
+// aesGCMEncryption is the implementation of AES-256-GCM.
+type aesGCMEncryption struct{}
+
+// chaCha20Poly1305Encryption is the implementation of ChaCha20-Poly1305.
+type chaCha20Poly1305Encryption struct{}
+
+// EncodeEncryption ...
+func (aesGCMEncryption) EncodeEncryption() uint16 {
+	return EncryptionAlgorithmAESGCM
+}
+
+// NewAEAD ...
+func (aesGCMEncryption) NewAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncodeEncryption ...
+func (chaCha20Poly1305Encryption) EncodeEncryption() uint16 {
+	return EncryptionAlgorithmChaCha20Poly1305
+}
+
+// NewAEAD ...
+func (chaCha20Poly1305Encryption) NewAEAD(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.New(key)
+}
@@ -0,0 +1,33 @@
+package packet
+
+import (
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+const (
+	// ErrorPermissionDenied is sent if the identity of the connection is not allowed to send the packet by
+	// the policy of the service.
+	ErrorPermissionDenied uint32 = iota
+)
+
+// Error is sent by a service to a connection to report that a packet it sent was not handled. If the
+// packet was a request, Error is sent as its response. Error is only sent to connections that support
+// CapabilityErrors, and is returned by Conn.ReadPacket like any other packet.
+type Error struct {
+	// PacketID is the ID of the packet that was not handled.
+	PacketID uint32 `vortex:"varint"`
+	// Code is one of the Error codes, such as ErrorPermissionDenied.
+	Code uint32 `vortex:"varint"`
+	// Message describes the error.
+	Message string
+}
+
+func (e *Error) ID() uint32 {
+	return IDError
+}
+
+func (e *Error) Marshal(io proto.IO) {
+	io.Varuint32(&e.PacketID)
+	io.Varuint32(&e.Code)
+	io.String(&e.Message)
+}
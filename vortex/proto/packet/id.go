@@ -5,3 +5,17 @@ const (
 	IDAuthResponse
 	IDHeartbeat
 )
+
+// IDBuiltIn is the first of the IDs reserved for packets built into the protocol, which range up to, but
+// not including, IDReserved. Built-in packets added after the first release use these IDs, so that they
+// do not collide with the IDs of packets registered by services, which should be lower than IDBuiltIn.
+const IDBuiltIn uint32 = 0xf0
+
+const (
+	IDThrottle = IDBuiltIn + iota
+	IDError
+)
+
+// IDReserved is reserved to mark frames that carry extensions, such as a trace context, and may not be
+// used as the ID of a packet.
+const IDReserved uint32 = 0xff
@@ -6,7 +6,32 @@ import (
 
 type Login struct {
 	Service string
-	Token   string
+	// Token is the token of the service. It is empty if the connection offers encryption, in which case
+	// TokenProof proves that the connection knows the token instead, so that the token is never sent.
+	Token string
+	// Protocol is the protocol version of the connection. It is CurrentProtocol for connections made by
+	// this implementation.
+	Protocol uint32 `vortex:"varint"`
+	// Capabilities is a bitset of the Capability constants supported by the connection.
+	Capabilities uint32 `vortex:"varint"`
+	// Compression holds the IDs of the compression algorithms supported by the connection, in order of
+	// preference. The service picks the first one it also supports and returns it in AuthResponse.
+	Compression []uint16
+	// Schema holds the Schema of every packet the connection may send. The service rejects the login with
+	// AuthResponseIncompatibleSchema if any of them does not match the schema of a packet with the same ID
+	// that the service receives. It is only read/written from ProtocolSchema on.
+	Schema []Schema
+	// Encryption holds the IDs of the encryption algorithms supported by the connection, in order of
+	// preference. The service picks the first one it also supports and returns it in AuthResponse.
+	// Encryption, PublicKey and TokenProof are only read/written from ProtocolEncryption on.
+	Encryption []uint16
+	// PublicKey is the ephemeral X25519 public key of the connection, used to derive the keys of the
+	// encryption algorithm picked. It is empty if Encryption is empty.
+	PublicKey []byte
+	// TokenProof is an HMAC-SHA256 keyed with the token of the service over PublicKey and Encryption. It is
+	// set instead of Token if Encryption is not empty, so that a party relaying the Login can neither learn
+	// the token nor replace the public key or the algorithms offered.
+	TokenProof []byte
 }
 
 func (l *Login) ID() uint32 {
@@ -16,4 +41,17 @@ func (l *Login) ID() uint32 {
 func (l *Login) Marshal(io proto.IO) {
 	io.String(&l.Service)
 	io.String(&l.Token)
+	io.Varuint32(&l.Protocol)
+	io.Varuint32(&l.Capabilities)
+	proto.FuncSlice(io, &l.Compression, io.Uint16)
+	if l.Protocol < ProtocolSchema {
+		return
+	}
+	proto.Slice(io, &l.Schema)
+	if l.Protocol < ProtocolEncryption {
+		return
+	}
+	proto.FuncSlice(io, &l.Encryption, io.Uint16)
+	io.ByteSlice(&l.PublicKey)
+	io.ByteSlice(&l.TokenProof)
 }
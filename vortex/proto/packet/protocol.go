@@ -0,0 +1,42 @@
+package packet
+
+const (
+	// CurrentProtocol is the version of the protocol implemented, exchanged in Login and AuthResponse. It
+	// is increased whenever the framing or the built-in packets change in a way older peers cannot handle.
+	CurrentProtocol uint32 = 3
+	// MinimumProtocol is the oldest protocol version that peers may log in with. The fields of Login and
+	// AuthResponse added by later versions are only read/written for connections of those versions.
+	MinimumProtocol uint32 = 1
+
+	// ProtocolSchema is the protocol version that added the Schema of Login and AuthResponse.
+	ProtocolSchema uint32 = 2
+	// ProtocolEncryption is the protocol version that added the fields of Login and AuthResponse that
+	// negotiate encryption.
+	ProtocolEncryption uint32 = 3
+)
+
+const (
+	// CapabilityCompression is set if the peer can decode frames compressed with the algorithm negotiated
+	// in Login and AuthResponse.
+	CapabilityCompression uint32 = 1 << iota
+	// CapabilityBatching is set if the peer can decode batches of frames.
+	CapabilityBatching
+	// CapabilityRequests is set if the peer can handle frames carrying request and response IDs.
+	CapabilityRequests
+	// CapabilityTracing is set if the peer can decode frames carrying a trace context.
+	CapabilityTracing
+	// CapabilityStreams is set if the peer can handle stream frames.
+	CapabilityStreams
+	// CapabilityEncryption is set if the peer can encrypt and decrypt frames with the algorithm and keys
+	// negotiated in Login and AuthResponse.
+	CapabilityEncryption
+	// CapabilityThrottling is set if the peer can decode the Throttle packets sent when it exceeds a rate
+	// limit of the service.
+	CapabilityThrottling
+	// CapabilityErrors is set if the peer can decode the Error packets sent for packets that were not
+	// handled.
+	CapabilityErrors
+
+	// Capabilities holds all capabilities of the protocol implemented.
+	Capabilities = CapabilityCompression | CapabilityBatching | CapabilityRequests | CapabilityTracing | CapabilityStreams | CapabilityEncryption | CapabilityThrottling | CapabilityErrors
+)
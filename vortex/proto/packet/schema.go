@@ -0,0 +1,56 @@
+package packet
+
+import (
+	"reflect"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// Versioned may be implemented by packets to declare the version of their schema. The version should be
+// increased whenever the meaning of the fields of the packet changes without their layout changing, which
+// is not detected by the fingerprint of the packet alone. Packets that do not implement Versioned have
+// version 0.
+type Versioned interface {
+	Packet
+	SchemaVersion() uint32
+}
+
+// Schema describes the schema of a packet registered with a connection. Schemas are exchanged in Login and
+// AuthResponse, so that packets with the same ID but a different schema on both sides are detected before
+// any of them are sent.
+type Schema struct {
+	// PacketID is the ID of the packet.
+	PacketID uint32 `vortex:"varint"`
+	// Name is the name of the type of the packet. It is used to report mismatches and is not compared.
+	Name string
+	// Version is the version returned by the SchemaVersion method of the packet, or 0 if it does not
+	// implement Versioned.
+	Version uint32 `vortex:"varint"`
+	// Fingerprint is the hash of the layout of the fields of the packet, as returned by proto.Fingerprint.
+	Fingerprint uint64
+}
+
+// SchemaOf returns the Schema of the packet passed.
+func SchemaOf(pk Packet) Schema {
+	s := Schema{PacketID: pk.ID(), Name: reflect.TypeOf(pk).Elem().Name()}
+	if v, ok := pk.(Versioned); ok {
+		s.Version = v.SchemaVersion()
+	}
+	// The fingerprint is taken of a new packet, so that the values held by pk cannot affect the fields
+	// marshaled.
+	s.Fingerprint = proto.Fingerprint(reflect.New(reflect.TypeOf(pk).Elem()).Interface().(Packet))
+	return s
+}
+
+// Compatible checks if a packet with the Schema passed may be decoded as a packet with Schema s.
+func (s Schema) Compatible(other Schema) bool {
+	return s.PacketID == other.PacketID && s.Version == other.Version && s.Fingerprint == other.Fingerprint
+}
+
+// Marshal ...
+func (s *Schema) Marshal(io proto.IO) {
+	io.Varuint32(&s.PacketID)
+	io.String(&s.Name)
+	io.Varuint32(&s.Version)
+	io.Uint64(&s.Fingerprint)
+}
@@ -0,0 +1,26 @@
+package packet
+
+import (
+	"time"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// Throttle is sent by a service to a connection that sent a packet exceeding one of the rate limits of the
+// service. The packet was dropped without being handled. Throttle is only sent to connections that support
+// CapabilityThrottling, and is returned by Conn.ReadPacket like any other packet.
+type Throttle struct {
+	// PacketID is the ID of the packet that was dropped.
+	PacketID uint32 `vortex:"varint"`
+	// RetryAfter is the time after which the rate limit allows the packet again.
+	RetryAfter time.Duration
+}
+
+func (t *Throttle) ID() uint32 {
+	return IDThrottle
+}
+
+func (t *Throttle) Marshal(io proto.IO) {
+	io.Varuint32(&t.PacketID)
+	io.Duration(&t.RetryAfter)
+}
@@ -1,12 +1,15 @@
 package proto
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"image/color"
 	"io"
 	"math"
+	"math/big"
+	"time"
 	"unsafe"
 
 	"github.com/google/uuid"
@@ -20,6 +23,10 @@ type Reader struct {
 	}
 	shieldID      int32
 	limitsEnabled bool
+
+	// data is the byte slice read from by a zero-copy Reader, and buf the reader over it that r is set to.
+	data []byte
+	buf  *bytes.Reader
 }
 
 // NewReader creates a new Reader using the io.ByteReader passed as underlying source to read bytes from.
@@ -30,6 +37,34 @@ func NewReader(r interface {
 	return &Reader{r: r, shieldID: shieldID, limitsEnabled: enableLimits}
 }
 
+// NewZeroCopyReader creates a new Reader that reads from the byte slice passed. Unlike a Reader created with
+// NewReader, strings and byte slices read by a zero-copy Reader are not copied, but alias b. They are only
+// valid as long as b is not modified, and must be copied, for example using Clone, to keep them beyond
+// that.
+func NewZeroCopyReader(b []byte, shieldID int32, enableLimits bool) *Reader {
+	buf := bytes.NewReader(b)
+	return &Reader{r: buf, shieldID: shieldID, limitsEnabled: enableLimits, data: b, buf: buf}
+}
+
+// next returns the next n bytes of the underlying buffer. The bytes returned by a zero-copy Reader alias its
+// byte slice, while other Readers return a copy.
+func (r *Reader) next(n int) []byte {
+	if r.data == nil {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r.r, b); err != nil {
+			r.panic(err)
+		}
+		return b
+	}
+	if n > r.buf.Len() {
+		r.panic(io.ErrUnexpectedEOF)
+	}
+	off := len(r.data) - r.buf.Len()
+	_, _ = r.buf.Seek(int64(n), io.SeekCurrent)
+	// The capacity is limited, so that appending to the slice returned cannot overwrite the bytes after it.
+	return r.data[off : off+n : off+n]
+}
+
 // Uint8 reads a uint8 from the underlying buffer.
 func (r *Reader) Uint8(x *uint8) {
 	var err error
@@ -52,7 +87,7 @@ func (r *Reader) Uint16(x *uint16) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = binary.BigEndian.Uint16(b)
+	*x = binary.LittleEndian.Uint16(b)
 }
 
 // Int16 reads a little endian int16 from the underlying buffer.
@@ -61,7 +96,7 @@ func (r *Reader) Int16(x *int16) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = int16(binary.BigEndian.Uint16(b))
+	*x = int16(binary.LittleEndian.Uint16(b))
 }
 
 // Uint32 reads a little endian uint32 from the underlying buffer.
@@ -70,7 +105,7 @@ func (r *Reader) Uint32(x *uint32) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = binary.BigEndian.Uint32(b)
+	*x = binary.LittleEndian.Uint32(b)
 }
 
 // Int32 reads a little endian int32 from the underlying buffer.
@@ -79,7 +114,7 @@ func (r *Reader) Int32(x *int32) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = int32(binary.BigEndian.Uint32(b))
+	*x = int32(binary.LittleEndian.Uint32(b))
 }
 
 // BEInt32 reads a big endian int32 from the underlying buffer.
@@ -97,7 +132,7 @@ func (r *Reader) Uint64(x *uint64) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = binary.BigEndian.Uint64(b)
+	*x = binary.LittleEndian.Uint64(b)
 }
 
 // Int64 reads a little endian int64 from the underlying buffer.
@@ -106,7 +141,7 @@ func (r *Reader) Int64(x *int64) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = int64(binary.BigEndian.Uint64(b))
+	*x = int64(binary.LittleEndian.Uint64(b))
 }
 
 // Float32 reads a little endian float32 from the underlying buffer.
@@ -115,7 +150,7 @@ func (r *Reader) Float32(x *float32) {
 	if _, err := r.r.Read(b); err != nil {
 		r.panic(err)
 	}
-	*x = math.Float32frombits(binary.BigEndian.Uint32(b))
+	*x = math.Float32frombits(binary.LittleEndian.Uint32(b))
 }
 
 // Bool reads a bool from the underlying buffer.
@@ -138,10 +173,10 @@ func (r *Reader) StringUTF(x *string) {
 	if l > math.MaxInt16 {
 		r.panic(errStringTooLong)
 	}
-	data := make([]byte, l)
-	if _, err := r.r.Read(data); err != nil {
-		r.panic(err)
+	if l < 0 {
+		r.panicf("negative string length %v", l)
 	}
+	data := r.next(l)
 	*x = *(*string)(unsafe.Pointer(&data))
 }
 
@@ -153,10 +188,7 @@ func (r *Reader) String(x *string) {
 	if l > math.MaxInt32 {
 		r.panic(errStringTooLong)
 	}
-	data := make([]byte, l)
-	if _, err := r.r.Read(data); err != nil {
-		r.panic(err)
-	}
+	data := r.next(l)
 	*x = *(*string)(unsafe.Pointer(&data))
 }
 
@@ -168,11 +200,7 @@ func (r *Reader) ByteSlice(x *[]byte) {
 	if l > math.MaxInt32 {
 		r.panic(errStringTooLong)
 	}
-	data := make([]byte, l)
-	if _, err := r.r.Read(data); err != nil {
-		r.panic(err)
-	}
-	*x = data
+	*x = r.next(l)
 }
 
 // ByteFloat reads a rotational float32 from a single byte.
@@ -221,6 +249,10 @@ func (r *Reader) VarRGBA(x *color.RGBA) {
 
 // Bytes reads the leftover bytes into a byte slice.
 func (r *Reader) Bytes(p *[]byte) {
+	if r.data != nil {
+		*p = r.next(r.buf.Len())
+		return
+	}
 	var err error
 	*p, err = io.ReadAll(r.r)
 	if err != nil {
@@ -228,12 +260,9 @@ func (r *Reader) Bytes(p *[]byte) {
 	}
 }
 
-// UUID reads a uuid.UUID from the underlying buffer.
+// UUID reads a little endian uuid.UUID from the underlying buffer.
 func (r *Reader) UUID(x *uuid.UUID) {
-	b := make([]byte, 16)
-	if _, err := r.r.Read(b); err != nil {
-		r.panic(err)
-	}
+	b := r.next(16)
 
 	// The UUIDs we read are Little Endian, but the uuid library is based on Big Endian UUIDs, so we need to
 	// reverse the two int64s the UUID is composed of, then reverse their bytes too.
@@ -245,24 +274,24 @@ func (r *Reader) UUID(x *uuid.UUID) {
 	*x = arr
 }
 
+// BEUUID reads a big endian uuid.UUID from the underlying buffer. Big endian is the byte order of RFC 4122
+// and of the uuid.UUID type itself.
+func (r *Reader) BEUUID(x *uuid.UUID) {
+	copy(x[:], r.next(16))
+}
+
 // LimitUint32 checks if the value passed is lower than the limit passed. If not, the Reader panics.
 func (r *Reader) LimitUint32(value uint32, max uint32) {
-	if max == math.MaxUint32 {
-		// Account for 0-1 overflowing into max.
-		max = 0
-	}
-	if value > max {
-		r.panicf("uint32 %v exceeds maximum of %v", value, max)
+	if err := limitUint32(value, max); err != nil {
+		r.panic(err)
 	}
 }
 
 // LimitInt32 checks if the value passed is lower than the limit passed and higher than the minimum. If not,
 // the Reader panics.
 func (r *Reader) LimitInt32(value int32, min, max int32) {
-	if value < min {
-		r.panicf("int32 %v exceeds minimum of %v", value, min)
-	} else if value > max {
-		r.panicf("int32 %v exceeds maximum of %v", value, max)
+	if err := limitInt32(value, min, max); err != nil {
+		r.panic(err)
 	}
 }
 
@@ -363,6 +392,83 @@ func (r *Reader) Varuint32(x *uint32) {
 	r.panic(errVarIntOverflow)
 }
 
+// Varint16 reads up to 3 bytes from the underlying buffer into an int16.
+func (r *Reader) Varint16(x *int16) {
+	var ux uint16
+	r.Varuint16(&ux)
+	*x = int16(ux >> 1)
+	if ux&1 != 0 {
+		*x = ^*x
+	}
+}
+
+// Varuint16 reads up to 3 bytes from the underlying buffer into a uint16.
+func (r *Reader) Varuint16(x *uint16) {
+	var v uint32
+	for i := 0; i < 21; i += 7 {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			r.panic(err)
+		}
+
+		v |= uint32(b&0x7f) << i
+		if b&0x80 == 0 {
+			if v > math.MaxUint16 {
+				break
+			}
+			*x = uint16(v)
+			return
+		}
+	}
+	r.panic(errVarIntOverflow)
+}
+
+// Float64 reads a little endian float64 from the underlying buffer.
+func (r *Reader) Float64(x *float64) {
+	var v uint64
+	r.Uint64(&v)
+	*x = math.Float64frombits(v)
+}
+
+// Duration reads a time.Duration from the underlying buffer as a varint64 of nanoseconds.
+func (r *Reader) Duration(x *time.Duration) {
+	var v int64
+	r.Varint64(&v)
+	*x = time.Duration(v)
+}
+
+// Time reads a time.Time from the underlying buffer as a varint64 of seconds since the Unix epoch, followed
+// by a varuint32 of nanoseconds. The time is returned in UTC.
+func (r *Reader) Time(x *time.Time) {
+	var sec int64
+	var nsec uint32
+	r.Varint64(&sec)
+	r.Varuint32(&nsec)
+	if nsec >= 1e9 {
+		r.panicf("time nanoseconds %v exceed a second", nsec)
+	}
+	*x = time.Unix(sec, int64(nsec)).UTC()
+}
+
+// maxBigIntLength is the maximum length in bytes of the magnitude of a big.Int read if limits are enabled.
+const maxBigIntLength = 1024
+
+// BigInt reads a big.Int from the underlying buffer as a bool that is true if it is negative, followed by its
+// magnitude as a big endian byte slice prefixed with a varuint32.
+func (r *Reader) BigInt(x *big.Int) {
+	var neg bool
+	var length uint32
+	r.Bool(&neg)
+	r.Varuint32(&length)
+	if r.limitsEnabled && length > maxBigIntLength {
+		r.panicf("big int length was too long: length of %v", length)
+	}
+	x.SetBytes(r.next(int(length)))
+	if neg {
+		x.Neg(x)
+	}
+}
+
 // panicf panics with the format and values passed and assigns the error created to the Reader.
 func (r *Reader) panicf(format string, a ...any) {
 	panic(fmt.Errorf(format, a...))
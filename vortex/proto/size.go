@@ -0,0 +1,193 @@
+package proto
+
+import (
+	"fmt"
+	"image/color"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Size returns the number of bytes that m encodes to when written to a Writer with the shield ID passed.
+func Size(m Marshaler, shieldID int32) int {
+	s := NewSizeCounter(shieldID)
+	m.Marshal(s)
+	return s.Size()
+}
+
+// SizeCounter is an IO that computes the number of bytes that the fields written to it encode to, without
+// writing them anywhere. It may be used to allocate a buffer of the exact size needed before writing a
+// value to it with a Writer.
+type SizeCounter struct {
+	n        int
+	shieldID int32
+}
+
+// NewSizeCounter creates a SizeCounter with a size of 0. The shield ID passed should be the same as that of
+// the Writer the value is written to, as Marshal methods may encode values differently depending on it.
+func NewSizeCounter(shieldID int32) *SizeCounter {
+	return &SizeCounter{shieldID: shieldID}
+}
+
+// Size returns the number of bytes counted so far.
+func (s *SizeCounter) Size() int {
+	return s.n
+}
+
+// Reset resets the size counted to 0, so that the SizeCounter may be reused.
+func (s *SizeCounter) Reset() {
+	s.n = 0
+}
+
+// Uint8 ...
+func (s *SizeCounter) Uint8(*uint8) { s.n++ }
+
+// Int8 ...
+func (s *SizeCounter) Int8(*int8) { s.n++ }
+
+// Bool ...
+func (s *SizeCounter) Bool(*bool) { s.n++ }
+
+// Uint16 ...
+func (s *SizeCounter) Uint16(*uint16) { s.n += 2 }
+
+// Int16 ...
+func (s *SizeCounter) Int16(*int16) { s.n += 2 }
+
+// Uint32 ...
+func (s *SizeCounter) Uint32(*uint32) { s.n += 4 }
+
+// Int32 ...
+func (s *SizeCounter) Int32(*int32) { s.n += 4 }
+
+// BEInt32 ...
+func (s *SizeCounter) BEInt32(*int32) { s.n += 4 }
+
+// Uint64 ...
+func (s *SizeCounter) Uint64(*uint64) { s.n += 8 }
+
+// Int64 ...
+func (s *SizeCounter) Int64(*int64) { s.n += 8 }
+
+// Float32 ...
+func (s *SizeCounter) Float32(*float32) { s.n += 4 }
+
+// Varint64 ...
+func (s *SizeCounter) Varint64(x *int64) {
+	ux := uint64(*x) << 1
+	if *x < 0 {
+		ux = ^ux
+	}
+	s.n += varuintSize(ux)
+}
+
+// Varuint64 ...
+func (s *SizeCounter) Varuint64(x *uint64) { s.n += varuintSize(*x) }
+
+// Varint32 ...
+func (s *SizeCounter) Varint32(x *int32) {
+	ux := uint32(*x) << 1
+	if *x < 0 {
+		ux = ^ux
+	}
+	s.n += varuintSize(uint64(ux))
+}
+
+// Varuint32 ...
+func (s *SizeCounter) Varuint32(x *uint32) { s.n += varuintSize(uint64(*x)) }
+
+// String ...
+func (s *SizeCounter) String(x *string) { s.n += varuintSize(uint64(len(*x))) + len(*x) }
+
+// StringUTF ...
+func (s *SizeCounter) StringUTF(x *string) { s.n += 2 + len(*x) }
+
+// ByteSlice ...
+func (s *SizeCounter) ByteSlice(x *[]byte) { s.n += varuintSize(uint64(len(*x))) + len(*x) }
+
+// ByteFloat ...
+func (s *SizeCounter) ByteFloat(*float32) { s.n++ }
+
+// Bytes ...
+func (s *SizeCounter) Bytes(x *[]byte) { s.n += len(*x) }
+
+// RGB ...
+func (s *SizeCounter) RGB(*color.RGBA) { s.n += 12 }
+
+// RGBA ...
+func (s *SizeCounter) RGBA(*color.RGBA) { s.n += 4 }
+
+// VarRGBA ...
+func (s *SizeCounter) VarRGBA(x *color.RGBA) {
+	s.n += varuintSize(uint64(x.R) | uint64(x.G)<<8 | uint64(x.B)<<16 | uint64(x.A)<<24)
+}
+
+// Varint16 ...
+func (s *SizeCounter) Varint16(x *int16) {
+	ux := uint16(*x) << 1
+	if *x < 0 {
+		ux = ^ux
+	}
+	s.n += varuintSize(uint64(ux))
+}
+
+// Varuint16 ...
+func (s *SizeCounter) Varuint16(x *uint16) { s.n += varuintSize(uint64(*x)) }
+
+// Float64 ...
+func (s *SizeCounter) Float64(*float64) { s.n += 8 }
+
+// Duration ...
+func (s *SizeCounter) Duration(x *time.Duration) {
+	v := int64(*x)
+	s.Varint64(&v)
+}
+
+// Time ...
+func (s *SizeCounter) Time(x *time.Time) {
+	sec := x.Unix()
+	s.Varint64(&sec)
+	s.n += varuintSize(uint64(x.Nanosecond()))
+}
+
+// BigInt ...
+func (s *SizeCounter) BigInt(x *big.Int) {
+	l := (x.BitLen() + 7) / 8
+	s.n += 1 + varuintSize(uint64(l)) + l
+}
+
+// UUID ...
+func (s *SizeCounter) UUID(*uuid.UUID) { s.n += 16 }
+
+// BEUUID ...
+func (s *SizeCounter) BEUUID(*uuid.UUID) { s.n += 16 }
+
+// ShieldID returns the shield ID provided to the SizeCounter.
+func (s *SizeCounter) ShieldID() int32 { return s.shieldID }
+
+// LimitUint32 does nothing, like Writer.LimitUint32.
+func (s *SizeCounter) LimitUint32(uint32, uint32) {}
+
+// LimitInt32 does nothing, like Writer.LimitInt32.
+func (s *SizeCounter) LimitInt32(int32, int32, int32) {}
+
+// UnknownEnumOption panics with an unknown enum option error, like Writer.UnknownEnumOption.
+func (s *SizeCounter) UnknownEnumOption(value any, enum string) {
+	panic(fmt.Errorf("unknown value '%v' for enum type '%v'", value, enum))
+}
+
+// InvalidValue panics with an invalid value error, like Writer.InvalidValue.
+func (s *SizeCounter) InvalidValue(value any, forField, reason string) {
+	panic(fmt.Errorf("invalid value '%v' for %v: %v", value, forField, reason))
+}
+
+// varuintSize returns the number of bytes that x encodes to as a varuint.
+func varuintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
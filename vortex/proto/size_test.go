@@ -0,0 +1,44 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sizeBenchmark is a packet with a few fields and a payload of a configurable size.
+type sizeBenchmark struct {
+	ID      uint64
+	Name    string
+	X, Y, Z float32
+	Data    []byte
+}
+
+// Marshal ...
+func (s *sizeBenchmark) Marshal(io IO) {
+	io.Varuint64(&s.ID)
+	io.String(&s.Name)
+	io.Float32(&s.X)
+	io.Float32(&s.Y)
+	io.Float32(&s.Z)
+	io.ByteSlice(&s.Data)
+}
+
+var sizeBenchmarks = []struct {
+	name string
+	v    *sizeBenchmark
+}{
+	{"small", &sizeBenchmark{ID: 12345, Name: "player", X: 1.5, Y: 64, Z: -3.25}},
+	{"large", &sizeBenchmark{ID: 12345, Name: "player", Data: bytes.Repeat([]byte{1, 2, 3, 4}, 64*1024)}},
+}
+
+// BenchmarkSize measures computing the encoded size of a small and a large value using a SizeCounter.
+func BenchmarkSize(b *testing.B) {
+	for _, bc := range sizeBenchmarks {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Size(bc.v, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package proto
+
+import (
+	"cmp"
+	"fmt"
+	"image/color"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/google/uuid"
+)
+
+// MarshalStruct reads/writes the exported fields of the struct that v points to, in the order in which
+// they are declared, so that a Marshal method may be implemented as follows:
+//
+//	func (pk *Packet) Marshal(io proto.IO) {
+//		proto.MarshalStruct(io, pk)
+//	}
+//
+// Every field is read/written with the IO method of its type, such as Uint32 for uint32 fields and String
+// for string fields. Fields of a type of which the pointer implements Marshaler, whatever its kind, are
+// marshaled with its Marshal method and take no tag options, Optional fields as if by OptionalMarshaler,
+// and other structs field by field. Fields of the types time.Time, time.Duration and big.Int are
+// read/written with Time, Duration and BigInt, and uuid.UUID fields with BEUUID, which matches the encoding
+// of the [16]byte it is made of.
+// Slices are prefixed with their length as a varuint32, except for []byte, which is read/written with
+// ByteSlice. Maps are prefixed with their length as a varuint32, followed by the key and value of every
+// entry, as done by Map.
+// The encoding of a field may be changed with a vortex struct tag holding one or more of the following
+// options, separated by commas:
+//
+//	vortex:"-"              The field is skipped.
+//	vortex:"varint"         Integers of 16, 32 or 64 bits are read/written as varints. For slices and
+//	                        arrays, this applies to their elements, and for maps to their values.
+//	vortex:"len=uint16"     Slices are prefixed with their length as a uint8, uint16, uint32, varint32
+//	                        or varuint32.
+//	vortex:"optional"       Pointers are prefixed with a bool that is true if the pointer is not nil,
+//	                        after which the value it points to follows. Pointer fields require this
+//	                        option.
+//	vortex:"sorted"         The entries of maps are written in ascending order of their keys, as done
+//	                        by SortedMap, so that a map is always encoded to the same bytes. The keys
+//	                        must be integers, floats or strings.
+//
+// The plan of how to marshal a type is built once and cached, so that MarshalStruct only pays for the
+// reflection on the first call for every type. MarshalStruct panics if v is not a non-nil pointer to a
+// struct, or if the struct holds a field of a type that cannot be marshaled.
+func MarshalStruct(io IO, v any) {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Pointer || val.Elem().Kind() != reflect.Struct || val.IsNil() {
+		panic(fmt.Errorf("marshal struct: %T is not a non-nil pointer to a struct", v))
+	}
+	planOf(val.Type().Elem()).marshal(io, val.UnsafePointer())
+}
+
+// codec reads/writes the value that p points to.
+type codec func(io IO, p unsafe.Pointer)
+
+// structPlan holds the codecs of the fields of a struct type.
+type structPlan struct {
+	fields []fieldPlan
+}
+
+// fieldPlan is a field of a struct that is marshaled.
+type fieldPlan struct {
+	offset uintptr
+	codec  codec
+}
+
+// marshal reads/writes every field of the struct that p points to.
+func (s *structPlan) marshal(io IO, p unsafe.Pointer) {
+	for _, f := range s.fields {
+		f.codec(io, unsafe.Add(p, f.offset))
+	}
+}
+
+// plans holds the *structPlan of every struct type marshaled with MarshalStruct.
+var plans sync.Map
+
+// planOf returns the cached plan of the struct type passed, building it if it does not yet exist.
+func planOf(t reflect.Type) *structPlan {
+	if s, ok := plans.Load(t); ok {
+		return s.(*structPlan)
+	}
+	b := &planBuilder{building: make(map[reflect.Type]*structPlan)}
+	s := b.structPlan(t)
+	for t, s := range b.building {
+		plans.LoadOrStore(t, s)
+	}
+	return s
+}
+
+// planBuilder builds the plans of a struct type and the struct types it holds.
+type planBuilder struct {
+	// building holds the plans built so far, so that types referring to themselves through slices or
+	// pointers do not recurse endlessly.
+	building map[reflect.Type]*structPlan
+}
+
+// structPlan returns the plan of the struct type passed.
+func (b *planBuilder) structPlan(t reflect.Type) *structPlan {
+	if s, ok := plans.Load(t); ok {
+		return s.(*structPlan)
+	}
+	if s, ok := b.building[t]; ok {
+		return s
+	}
+	s := &structPlan{}
+	b.building[t] = s
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _ := field.Tag.Lookup("vortex")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		opts, err := ParseTag(tag)
+		if err == nil {
+			var c codec
+			if c, err = b.codec(field.Type, opts); err == nil {
+				s.fields = append(s.fields, fieldPlan{offset: field.Offset, codec: c})
+				continue
+			}
+		}
+		panic(fmt.Errorf("marshal struct %v: field %v: %w", t, field.Name, err))
+	}
+	return s
+}
+
+// TagOptions holds the options of a vortex struct tag, as documented on MarshalStruct.
+type TagOptions struct {
+	Varint   bool
+	Optional bool
+	Sorted   bool
+	// Length is the type of the length prefix of a slice, or an empty string for the default.
+	Length string
+}
+
+// ParseTag parses the options of a vortex struct tag. It is exported so that tools generating or describing
+// code for struct types, such as vortexgen, accept the same tags as MarshalStruct.
+func ParseTag(tag string) (opts TagOptions, err error) {
+	if tag == "" {
+		return opts, nil
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		switch opt = strings.TrimSpace(opt); {
+		case opt == "varint":
+			opts.Varint = true
+		case opt == "optional":
+			opts.Optional = true
+		case opt == "sorted":
+			opts.Sorted = true
+		case strings.HasPrefix(opt, "len="):
+			opts.Length = strings.TrimPrefix(opt, "len=")
+			if _, ok := lengthPrefixes[opts.Length]; !ok {
+				return opts, fmt.Errorf("unknown length prefix %q", opts.Length)
+			}
+		default:
+			return opts, fmt.Errorf("unknown tag option %q", opt)
+		}
+	}
+	return opts, nil
+}
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	optionalType  = reflect.TypeOf((*optionalValue)(nil)).Elem()
+	rgbaType      = reflect.TypeOf(color.RGBA{})
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	bigIntType    = reflect.TypeOf(big.Int{})
+	uuidType      = reflect.TypeOf(uuid.UUID{})
+)
+
+// codec returns the codec of a value of the type passed, marshaled with the options passed.
+func (b *planBuilder) codec(t reflect.Type, opts TagOptions) (codec, error) {
+	if opts.Optional && t.Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("optional is only supported for pointers, not %v", t)
+	}
+	if opts.Length != "" && t.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("len is only supported for slices, not %v", t)
+	}
+	if opts.Sorted && t.Kind() != reflect.Map {
+		return nil, fmt.Errorf("sorted is only supported for maps, not %v", t)
+	}
+	if opts.Varint {
+		switch t.Kind() {
+		case reflect.Int16, reflect.Uint16, reflect.Int32, reflect.Uint32, reflect.Int64, reflect.Uint64,
+			reflect.Slice, reflect.Array, reflect.Map, reflect.Pointer:
+		default:
+			return nil, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+	}
+
+	if ptr := reflect.PointerTo(t); ptr.Implements(marshalerType) && !ptr.Implements(optionalType) {
+		if opts != (TagOptions{}) {
+			return nil, fmt.Errorf("tag options are not supported for %v, which implements Marshaler", t)
+		}
+		return func(io IO, p unsafe.Pointer) {
+			reflect.NewAt(t, p).Interface().(Marshaler).Marshal(io)
+		}, nil
+	}
+
+	switch t {
+	case durationType:
+		// Durations are always read/written as varints, so the varint option does not change them.
+		return func(io IO, p unsafe.Pointer) { io.Duration((*time.Duration)(p)) }, nil
+	case timeType:
+		return func(io IO, p unsafe.Pointer) { io.Time((*time.Time)(p)) }, nil
+	case bigIntType:
+		return func(io IO, p unsafe.Pointer) { io.BigInt((*big.Int)(p)) }, nil
+	case uuidType:
+		if opts.Varint {
+			return nil, fmt.Errorf("varint is only supported for 16, 32 and 64 bit integers, not %v", t)
+		}
+		return func(io IO, p unsafe.Pointer) { io.BEUUID((*uuid.UUID)(p)) }, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(io IO, p unsafe.Pointer) { io.Bool((*bool)(p)) }, nil
+	case reflect.Uint8:
+		return func(io IO, p unsafe.Pointer) { io.Uint8((*uint8)(p)) }, nil
+	case reflect.Int8:
+		return func(io IO, p unsafe.Pointer) { io.Int8((*int8)(p)) }, nil
+	case reflect.Uint16:
+		if opts.Varint {
+			return func(io IO, p unsafe.Pointer) { io.Varuint16((*uint16)(p)) }, nil
+		}
+		return func(io IO, p unsafe.Pointer) { io.Uint16((*uint16)(p)) }, nil
+	case reflect.Int16:
+		if opts.Varint {
+			return func(io IO, p unsafe.Pointer) { io.Varint16((*int16)(p)) }, nil
+		}
+		return func(io IO, p unsafe.Pointer) { io.Int16((*int16)(p)) }, nil
+	case reflect.Uint32:
+		if opts.Varint {
+			return func(io IO, p unsafe.Pointer) { io.Varuint32((*uint32)(p)) }, nil
+		}
+		return func(io IO, p unsafe.Pointer) { io.Uint32((*uint32)(p)) }, nil
+	case reflect.Int32:
+		if opts.Varint {
+			return func(io IO, p unsafe.Pointer) { io.Varint32((*int32)(p)) }, nil
+		}
+		return func(io IO, p unsafe.Pointer) { io.Int32((*int32)(p)) }, nil
+	case reflect.Uint64:
+		if opts.Varint {
+			return func(io IO, p unsafe.Pointer) { io.Varuint64((*uint64)(p)) }, nil
+		}
+		return func(io IO, p unsafe.Pointer) { io.Uint64((*uint64)(p)) }, nil
+	case reflect.Int64:
+		if opts.Varint {
+			return func(io IO, p unsafe.Pointer) { io.Varint64((*int64)(p)) }, nil
+		}
+		return func(io IO, p unsafe.Pointer) { io.Int64((*int64)(p)) }, nil
+	case reflect.Float32:
+		return func(io IO, p unsafe.Pointer) { io.Float32((*float32)(p)) }, nil
+	case reflect.Float64:
+		return func(io IO, p unsafe.Pointer) { io.Float64((*float64)(p)) }, nil
+	case reflect.String:
+		return func(io IO, p unsafe.Pointer) { io.String((*string)(p)) }, nil
+	case reflect.Slice:
+		return b.sliceCodec(t, opts)
+	case reflect.Array:
+		return b.arrayCodec(t, opts)
+	case reflect.Map:
+		return b.mapCodec(t, opts)
+	case reflect.Pointer:
+		if !opts.Optional {
+			return nil, fmt.Errorf("pointer %v requires the optional tag option", t)
+		}
+		return b.pointerCodec(t, TagOptions{Varint: opts.Varint})
+	case reflect.Struct:
+		return b.structCodec(t)
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+// structCodec returns the codec of a struct type that does not implement Marshaler, which is marshaled as
+// an Optional or field by field.
+func (b *planBuilder) structCodec(t reflect.Type) (codec, error) {
+	switch {
+	case t == rgbaType:
+		return func(io IO, p unsafe.Pointer) { io.RGBA((*color.RGBA)(p)) }, nil
+	case reflect.PointerTo(t).Implements(optionalType):
+		_, val := reflect.New(t).Interface().(optionalValue).optional()
+		elemType := reflect.TypeOf(val).Elem()
+		elem, err := b.codec(elemType, TagOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return func(io IO, p unsafe.Pointer) {
+			set, val := reflect.NewAt(t, p).Interface().(optionalValue).optional()
+			io.Bool(set)
+			if f, ok := io.(*fingerprinter); ok {
+				f.element(elemType, func(p unsafe.Pointer) { elem(io, p) })
+				return
+			}
+			if *set {
+				elem(io, reflect.ValueOf(val).UnsafePointer())
+			}
+		}, nil
+	}
+	s := b.structPlan(t)
+	return s.marshal, nil
+}
+
+// pointerCodec returns the codec of an optional pointer type. Like the code that vortexgen generates for
+// pointers, it only adds the value of a pointer to a fingerprint if the pointer is set, so that both lead
+// to the same fingerprint.
+func (b *planBuilder) pointerCodec(t reflect.Type, opts TagOptions) (codec, error) {
+	elemType := t.Elem()
+	elem, err := b.codec(elemType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(io IO, p unsafe.Pointer) {
+		ptr := (*unsafe.Pointer)(p)
+		set := *ptr != nil
+		io.Bool(&set)
+		if !set {
+			return
+		}
+		if *ptr == nil {
+			*ptr = reflect.New(elemType).UnsafePointer()
+		}
+		elem(io, *ptr)
+	}, nil
+}
+
+// sliceCodec returns the codec of a slice type, prefixed with its length.
+func (b *planBuilder) sliceCodec(t reflect.Type, opts TagOptions) (codec, error) {
+	if t.Elem().Kind() == reflect.Uint8 && (opts.Length == "" || opts.Length == "varuint32") {
+		return func(io IO, p unsafe.Pointer) { io.ByteSlice((*[]byte)(p)) }, nil
+	}
+	length := lengthPrefixes[opts.Length]
+	if length == nil {
+		length = lengthPrefixes["varuint32"]
+	}
+	elem, err := b.codec(t.Elem(), TagOptions{Varint: opts.Varint})
+	if err != nil {
+		return nil, err
+	}
+	size := t.Elem().Size()
+	return func(io IO, p unsafe.Pointer) {
+		v := reflect.NewAt(t, p).Elem()
+		l := uint32(v.Len())
+		length(io, &l)
+		if f, ok := io.(*fingerprinter); ok {
+			f.element(t.Elem(), func(p unsafe.Pointer) { elem(io, p) })
+			return
+		}
+		if decoding(io, l) {
+			v.Set(reflect.MakeSlice(t, int(l), int(l)))
+		}
+		if l == 0 {
+			return
+		}
+		data := v.UnsafePointer()
+		for i := uintptr(0); i < uintptr(l); i++ {
+			elem(io, unsafe.Add(data, i*size))
+		}
+	}, nil
+}
+
+// arrayCodec returns the codec of an array type, of which every element is marshaled without a length
+// prefix.
+func (b *planBuilder) arrayCodec(t reflect.Type, opts TagOptions) (codec, error) {
+	elem, err := b.codec(t.Elem(), TagOptions{Varint: opts.Varint})
+	if err != nil {
+		return nil, err
+	}
+	n, size := uintptr(t.Len()), t.Elem().Size()
+	return func(io IO, p unsafe.Pointer) {
+		for i := uintptr(0); i < n; i++ {
+			elem(io, unsafe.Add(p, i*size))
+		}
+	}, nil
+}
+
+// mapCodec returns the codec of a map type, prefixed with its length as a varuint32.
+func (b *planBuilder) mapCodec(t reflect.Type, opts TagOptions) (codec, error) {
+	if opts.Sorted && !orderedKind(t.Key().Kind()) {
+		return nil, fmt.Errorf("sorted is only supported for maps with integer, float or string keys, not %v", t)
+	}
+	key, err := b.codec(t.Key(), TagOptions{})
+	if err != nil {
+		return nil, err
+	}
+	elem, err := b.codec(t.Elem(), TagOptions{Varint: opts.Varint})
+	if err != nil {
+		return nil, err
+	}
+	return func(io IO, p unsafe.Pointer) {
+		v := reflect.NewAt(t, p).Elem()
+		l := uint32(v.Len())
+		io.Varuint32(&l)
+		if f, ok := io.(*fingerprinter); ok {
+			f.element(t, func(unsafe.Pointer) {
+				key(io, reflect.New(t.Key()).UnsafePointer())
+				elem(io, reflect.New(t.Elem()).UnsafePointer())
+			})
+			return
+		}
+		if decoding(io, l) {
+			m := reflect.MakeMapWithSize(t, int(l))
+			for i := uint32(0); i < l; i++ {
+				k, e := reflect.New(t.Key()), reflect.New(t.Elem())
+				key(io, k.UnsafePointer())
+				elem(io, e.UnsafePointer())
+				m.SetMapIndex(k.Elem(), e.Elem())
+			}
+			v.Set(m)
+			return
+		}
+		keys := v.MapKeys()
+		if opts.Sorted {
+			sortKeys(keys)
+		}
+		// Map entries are not addressable, so every entry is copied before it is written.
+		k, e := reflect.New(t.Key()), reflect.New(t.Elem())
+		for _, mk := range keys {
+			k.Elem().Set(mk)
+			e.Elem().Set(v.MapIndex(mk))
+			key(io, k.UnsafePointer())
+			elem(io, e.UnsafePointer())
+		}
+	}, nil
+}
+
+// orderedKind checks if values of the kind passed can be sorted by sortKeys.
+func orderedKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	}
+	return false
+}
+
+// sortKeys sorts the map keys passed in ascending order. All keys must be of the same kind, for which
+// orderedKind returns true.
+func sortKeys(keys []reflect.Value) {
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		switch {
+		case a.CanInt():
+			return cmp.Compare(a.Int(), b.Int())
+		case a.CanUint():
+			return cmp.Compare(a.Uint(), b.Uint())
+		case a.CanFloat():
+			return cmp.Compare(a.Float(), b.Float())
+		}
+		return cmp.Compare(a.String(), b.String())
+	})
+}
+
+// lengthPrefixes holds the functions that read/write the length prefix of a slice by the name used in the
+// len tag option.
+var lengthPrefixes = map[string]func(io IO, l *uint32){
+	"uint8": func(io IO, l *uint32) {
+		x := uint8(*l)
+		io.Uint8(&x)
+		*l = uint32(x)
+	},
+	"uint16": func(io IO, l *uint32) {
+		x := uint16(*l)
+		io.Uint16(&x)
+		*l = uint32(x)
+	},
+	"uint32": func(io IO, l *uint32) {
+		io.Uint32(l)
+	},
+	"varint32": func(io IO, l *uint32) {
+		x := int32(*l)
+		io.Varint32(&x)
+		*l = uint32(x)
+	},
+	"varuint32": func(io IO, l *uint32) {
+		io.Varuint32(l)
+	},
+}
@@ -0,0 +1,214 @@
+package proto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// flags is a non-struct type with a Marshal method that encodes it differently from its kind.
+type flags uint32
+
+// Marshal ...
+func (f *flags) Marshal(io IO) {
+	x := uint8(*f)
+	io.Uint8(&x)
+	*f = flags(x)
+}
+
+// tagged is a struct holding a field for every tag option of MarshalStruct.
+type tagged struct {
+	Plain    int32
+	Varint   int32   `vortex:"varint"`
+	Skipped  int32   `vortex:"-"`
+	Short    []int32 `vortex:"len=uint8,varint"`
+	Default  []string
+	Optional *elemA `vortex:"optional"`
+	Flags    flags
+	Set      Optional[elemA]
+	hidden   int32
+}
+
+// Marshal ...
+func (s *tagged) Marshal(io IO) {
+	MarshalStruct(io, s)
+}
+
+// manual reads/writes the fields of a tagged as MarshalStruct is documented to.
+func (s *tagged) manual(io IO) {
+	io.Int32(&s.Plain)
+	io.Varint32(&s.Varint)
+	l := uint8(len(s.Short))
+	io.Uint8(&l)
+	FuncSliceOfLen(io, uint32(l), &s.Short, io.Varint32)
+	FuncSlice(io, &s.Default, io.String)
+	set := s.Optional != nil
+	io.Bool(&set)
+	if set {
+		s.Optional.Marshal(io)
+	}
+	s.Flags.Marshal(io)
+	OptionalMarshaler(io, &s.Set)
+}
+
+// TestMarshalStruct checks that MarshalStruct encodes every field as documented for its tag options,
+// including types that implement Marshaler regardless of their kind, and that the value decoded equals the
+// value encoded.
+func TestMarshalStruct(t *testing.T) {
+	for _, v := range []*tagged{
+		{},
+		{
+			Plain: -1, Varint: 300, Skipped: 5, Short: []int32{1, -1, 1 << 20}, Default: []string{"a", "bc"},
+			Optional: &elemA{X: 7}, Flags: 0x1ff, Set: Option(elemA{X: 9}), hidden: 5,
+		},
+	} {
+		got, want := new(bytes.Buffer), new(bytes.Buffer)
+		v.Marshal(NewWriter(got, 1))
+		v.manual(NewWriter(want, 1))
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%+v: encoded %x, want %x", v, got.Bytes(), want.Bytes())
+		}
+
+		decoded := new(tagged)
+		decoded.Marshal(NewReader(bytes.NewReader(got.Bytes()), 1, true))
+		expected := *v
+		expected.Skipped, expected.hidden, expected.Flags = 0, 0, flags(uint8(v.Flags))
+		if expected.Default == nil {
+			expected.Default = []string{}
+		}
+		if expected.Short == nil {
+			expected.Short = []int32{}
+		}
+		if !reflect.DeepEqual(*decoded, expected) {
+			t.Errorf("decoded %+v, want %+v", *decoded, expected)
+		}
+	}
+}
+
+// TestMarshalStructInvalid checks that MarshalStruct panics for types and tag options it does not support.
+func TestMarshalStructInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v    any
+	}{
+		{"not a pointer", tagged{}},
+		{"nil pointer", (*tagged)(nil)},
+		{"unknown option", &struct {
+			X int32 `vortex:"fixed"`
+		}{}},
+		{"unknown length prefix", &struct {
+			X []int32 `vortex:"len=int64"`
+		}{}},
+		{"len on a non-slice", &struct {
+			X int32 `vortex:"len=uint8"`
+		}{}},
+		{"varint on uint8", &struct {
+			X uint8 `vortex:"varint"`
+		}{}},
+		{"varint on a Marshaler", &struct {
+			X flags `vortex:"varint"`
+		}{}},
+		{"pointer without optional", &struct{ X *int32 }{}},
+		{"optional on a non-pointer", &struct {
+			X int32 `vortex:"optional"`
+		}{}},
+		{"unsupported kind", &struct{ X chan int }{}},
+	} {
+		if !panics(func() { MarshalStruct(NewWriter(new(bytes.Buffer), 1), tc.v) }) {
+			t.Errorf("%v: MarshalStruct did not panic", tc.name)
+		}
+	}
+}
+
+// structSliceA and structSliceB hold slices and Optionals of elemA and elemB, marshaled using MarshalStruct.
+type structSliceA struct {
+	L []elemA
+	O Optional[elemA]
+}
+type structSliceB struct {
+	L []elemB
+	O Optional[elemA]
+}
+type structOptionalB struct {
+	L []elemA
+	O Optional[elemB]
+}
+
+// Marshal ...
+func (s *structSliceA) Marshal(io IO) { MarshalStruct(io, s) }
+
+// Marshal ...
+func (s *structSliceB) Marshal(io IO) { MarshalStruct(io, s) }
+
+// Marshal ...
+func (s *structOptionalB) Marshal(io IO) { MarshalStruct(io, s) }
+
+// TestMarshalStructFingerprint checks that the fingerprint of a struct marshaled with MarshalStruct changes
+// if the element type of a slice or Optional field changes.
+func TestMarshalStructFingerprint(t *testing.T) {
+	a := Fingerprint(&structSliceA{})
+	for _, b := range []Marshaler{&structSliceB{}, &structOptionalB{}} {
+		if Fingerprint(b) == a {
+			t.Errorf("%T: fingerprint equals that of %T, which has elements of a different type", b, &structSliceA{})
+		}
+	}
+	if full := (&structSliceA{L: []elemA{{1}}, O: Option(elemA{3})}); Fingerprint(full) != a {
+		t.Error("fingerprint depends on the elements that a struct holds")
+	}
+}
+
+// sortedMaps holds maps marshaled with and without the sorted tag option.
+type sortedMaps struct {
+	Sorted   map[string]int32 `vortex:"sorted,varint"`
+	Unsorted map[int32]elemB
+}
+
+// Marshal ...
+func (m *sortedMaps) Marshal(io IO) {
+	MarshalStruct(io, m)
+}
+
+// manual reads/writes the fields of a sortedMaps as MarshalStruct is documented to.
+func (m *sortedMaps) manual(io IO) {
+	SortedMap(io, &m.Sorted, io.String, io.Varint32)
+	Map(io, &m.Unsorted, io.Int32, func(v *elemB) { v.Marshal(io) })
+}
+
+// TestMarshalStructMaps checks that MarshalStruct encodes maps with the sorted option like SortedMap, decodes
+// the maps it encodes, and leads to the same fingerprint as Map and SortedMap.
+func TestMarshalStructMaps(t *testing.T) {
+	v := &sortedMaps{
+		Sorted:   map[string]int32{"c": 3, "a": 1, "b": -2, "d": 1 << 20},
+		Unsorted: map[int32]elemB{1: {"x"}},
+	}
+	got, want := new(bytes.Buffer), new(bytes.Buffer)
+	v.Marshal(NewWriter(got, 1))
+	v.manual(NewWriter(want, 1))
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("encoded %x, want %x", got.Bytes(), want.Bytes())
+	}
+	decoded := new(sortedMaps)
+	decoded.Marshal(NewReader(bytes.NewReader(got.Bytes()), 1, true))
+	if !reflect.DeepEqual(decoded, v) {
+		t.Errorf("decoded %+v, want %+v", decoded, v)
+	}
+
+	if Fingerprint(v) != Fingerprint(marshalerFunc(v.manual)) {
+		t.Error("fingerprint differs from that of Map and SortedMap")
+	}
+	if !panics(func() {
+		MarshalStruct(NewWriter(new(bytes.Buffer), 1), &struct {
+			M map[elemB]int32 `vortex:"sorted"`
+		}{})
+	}) {
+		t.Error("sorted map with keys that cannot be sorted did not panic")
+	}
+}
+
+// marshalerFunc is a Marshaler that calls the function.
+type marshalerFunc func(io IO)
+
+// Marshal ...
+func (f marshalerFunc) Marshal(io IO) {
+	f(io)
+}
@@ -0,0 +1,109 @@
+package proto
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// UnionRegistry holds the types that the value of a union may have, each identified by a tag. A union is a
+// field that holds one of several types implementing T, such as the different events in an envelope:
+//
+//	var events = proto.NewUnionRegistry[Event]("Event").
+//		Register(0, func() Event { return &Join{} }).
+//		Register(1, func() Event { return &Leave{} })
+//
+//	func (e *Envelope) Marshal(io proto.IO) {
+//		proto.Union(io, &e.Event, events)
+//	}
+//
+// Types should be registered when the registry is created. Register must not be called while values are
+// read/written using the registry.
+type UnionRegistry[T Marshaler] struct {
+	name  string
+	byTag map[uint32]func() T
+	tags  map[reflect.Type]uint32
+}
+
+// NewUnionRegistry creates a UnionRegistry without any types. The name passed is used in the errors of
+// Union, such as for unknown tags.
+func NewUnionRegistry[T Marshaler](name string) *UnionRegistry[T] {
+	return &UnionRegistry[T]{name: name, byTag: make(map[uint32]func() T), tags: make(map[reflect.Type]uint32)}
+}
+
+// Register registers the type of the values returned by newValue under the tag passed. newValue is called
+// to create the value that a union with the tag is read into. The values returned must be of the same
+// type, which is usually a pointer to a struct. Register panics if the tag or the type is already
+// registered, and returns the UnionRegistry so that calls may be chained.
+func (u *UnionRegistry[T]) Register(tag uint32, newValue func() T) *UnionRegistry[T] {
+	t := reflect.TypeOf(newValue())
+	if t == nil {
+		panic(fmt.Errorf("register union %v tag %v: value is nil", u.name, tag))
+	}
+	if _, ok := u.byTag[tag]; ok {
+		panic(fmt.Errorf("register union %v tag %v: tag already registered", u.name, tag))
+	}
+	if existing, ok := u.tags[t]; ok {
+		panic(fmt.Errorf("register union %v tag %v: %v already registered with tag %v", u.name, tag, t, existing))
+	}
+	u.byTag[tag], u.tags[t] = newValue, tag
+	return u
+}
+
+// Union reads/writes x as one of the types registered in u, prefixed with its tag as a varuint32. When
+// reading, x is set to a new value of the type registered for the tag read, and UnknownEnumOption is called
+// if no type is registered for it. When writing, InvalidValue is called if x is nil or of a type that is not
+// registered.
+func Union[T Marshaler](r IO, x *T, u *UnionRegistry[T]) {
+	if f, ok := r.(*fingerprinter); ok {
+		u.fingerprint(f)
+		return
+	}
+	if decoding(r, 0) {
+		var tag uint32
+		r.Varuint32(&tag)
+		newValue, ok := u.byTag[tag]
+		if !ok {
+			r.UnknownEnumOption(tag, u.name)
+			return
+		}
+		*x = newValue()
+		(*x).Marshal(r)
+		return
+	}
+	tag, ok := u.tags[reflect.TypeOf(*x)]
+	if !ok {
+		r.InvalidValue(fmt.Sprintf("%T", *x), u.name, "type is not registered in the union")
+		return
+	}
+	r.Varuint32(&tag)
+	(*x).Marshal(r)
+}
+
+// fingerprint adds every type registered in the union to the fingerprint, so that the fingerprint changes if
+// a type is registered or the fields of one change, regardless of the value the union holds.
+func (u *UnionRegistry[T]) fingerprint(f *fingerprinter) {
+	f.field("union")
+	if f.unions[u] {
+		// The union holds itself, such as through a variant that holds the union again. Its types are
+		// already part of the fingerprint.
+		return
+	}
+	if f.unions == nil {
+		f.unions = make(map[any]bool)
+	}
+	f.unions[u] = true
+	defer delete(f.unions, u)
+
+	tags := make([]uint32, 0, len(u.byTag))
+	for tag := range u.byTag {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		f.field(strconv.FormatUint(uint64(tag), 10))
+		u.byTag[tag]().Marshal(f)
+	}
+	f.field("end union")
+}
@@ -6,6 +6,8 @@ import (
 	"image/color"
 	"io"
 	"math"
+	"math/big"
+	"time"
 	"unsafe"
 
 	"github.com/google/uuid"
@@ -154,7 +156,7 @@ func (w *Writer) VarRGBA(x *color.RGBA) {
 	w.Varuint32(&val)
 }
 
-// UUID writes a UUID to the underlying buffer.
+// UUID writes a little endian UUID to the underlying buffer.
 func (w *Writer) UUID(x *uuid.UUID) {
 	b := append((*x)[8:], (*x)[:8]...)
 	for i, j := 0, 15; i < j; i, j = i+1, j-1 {
@@ -163,6 +165,12 @@ func (w *Writer) UUID(x *uuid.UUID) {
 	_, _ = w.w.Write(b)
 }
 
+// BEUUID writes a big endian UUID to the underlying buffer. Big endian is the byte order of RFC 4122 and of
+// the uuid.UUID type itself.
+func (w *Writer) BEUUID(x *uuid.UUID) {
+	_, _ = w.w.Write(x[:])
+}
+
 // Varint64 writes an int64 as 1-10 bytes to the underlying buffer.
 func (w *Writer) Varint64(x *int64) {
 	u := *x
@@ -211,11 +219,66 @@ func (w *Writer) Varuint32(x *uint32) {
 	_ = w.w.WriteByte(byte(u))
 }
 
+// Varint16 writes an int16 as 1-3 bytes to the underlying buffer.
+func (w *Writer) Varint16(x *int16) {
+	u := *x
+	ux := uint16(u) << 1
+	if u < 0 {
+		ux = ^ux
+	}
+	w.Varuint16(&ux)
+}
+
+// Varuint16 writes a uint16 as 1-3 bytes to the underlying buffer.
+func (w *Writer) Varuint16(x *uint16) {
+	u := *x
+	for u >= 0x80 {
+		_ = w.w.WriteByte(byte(u) | 0x80)
+		u >>= 7
+	}
+	_ = w.w.WriteByte(byte(u))
+}
+
+// Float64 writes a little endian float64 to the underlying buffer.
+func (w *Writer) Float64(x *float64) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, math.Float64bits(*x))
+	_, _ = w.w.Write(data)
+}
+
+// Duration writes a time.Duration to the underlying buffer as a varint64 of nanoseconds.
+func (w *Writer) Duration(x *time.Duration) {
+	v := int64(*x)
+	w.Varint64(&v)
+}
+
+// Time writes a time.Time to the underlying buffer as a varint64 of seconds since the Unix epoch, followed
+// by a varuint32 of nanoseconds. The location of the time is not written.
+func (w *Writer) Time(x *time.Time) {
+	sec, nsec := x.Unix(), uint32(x.Nanosecond())
+	w.Varint64(&sec)
+	w.Varuint32(&nsec)
+}
+
+// BigInt writes a big.Int to the underlying buffer as a bool that is true if it is negative, followed by its
+// magnitude as a big endian byte slice prefixed with a varuint32.
+func (w *Writer) BigInt(x *big.Int) {
+	neg, b := x.Sign() < 0, x.Bytes()
+	w.Bool(&neg)
+	w.ByteSlice(&b)
+}
+
 // ShieldID returns the shield ID provided to the writer.
 func (w *Writer) ShieldID() int32 {
 	return w.shieldID
 }
 
+// LimitUint32 does nothing: Limits are only checked when reading, as the values written are trusted.
+func (w *Writer) LimitUint32(uint32, uint32) {}
+
+// LimitInt32 does nothing: Limits are only checked when reading, as the values written are trusted.
+func (w *Writer) LimitInt32(int32, int32, int32) {}
+
 // UnknownEnumOption panics with an unknown enum option error.
 func (w *Writer) UnknownEnumOption(value any, enum string) {
 	w.panicf("unknown value '%v' for enum type '%v'", value, enum)
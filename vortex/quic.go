@@ -0,0 +1,430 @@
+package vortex
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// QUICNextProto is the ALPN protocol negotiated by QUICTransport if its TLS config does not set any.
+const QUICNextProto = "vortex"
+
+// QUICTransport is a Transport carrying messages over QUIC connections. The login and all other messages
+// are sent on a bidirectional control stream opened by the dialing side. Requests, responses and streams
+// opened using Conn.OpenStream are each sent on a unidirectional QUIC stream of their own instead, so that
+// a packet lost for one of them does not hold up the others. This only applies to connections that neither
+// batch packets nor encrypt frames using WithEncryption, as both require messages to be read in the order
+// they were written. QUIC encrypts all traffic with TLS, so WithEncryption is not needed for QUIC.
+type QUICTransport struct {
+	// TLSConfig is the TLS configuration of the QUIC connections. Listening requires a certificate, and
+	// dialing a config that trusts the certificate of the service. If NextProtos is empty, QUICNextProto is
+	// negotiated. SelfSignedTLS creates configs for testing.
+	TLSConfig *tls.Config
+	// Config is the configuration of the QUIC connections. If nil, the defaults of quic-go are used, except
+	// for allowing up to 1000 unidirectional streams to be open at once.
+	Config *quic.Config
+}
+
+// Listen listens for QUIC connections on the UDP address passed, such as ":8443".
+func (t QUICTransport) Listen(addr string) (Listener, error) {
+	ln, err := quic.ListenAddr(addr, t.tlsConfig(), t.config())
+	if err != nil {
+		return nil, err
+	}
+	l := &quicListener{ln: ln, conns: make(chan MessageConn), closed: make(chan struct{})}
+	go l.accept()
+	return l, nil
+}
+
+// Dial dials a QUIC connection to the UDP address passed and opens its control stream.
+func (t QUICTransport) Dial(ctx context.Context, addr string) (MessageConn, error) {
+	conn, err := quic.DialAddr(ctx, addr, t.tlsConfig(), t.config())
+	if err != nil {
+		return nil, err
+	}
+	control, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return newQUICConn(conn, control), nil
+}
+
+// tlsConfig returns the TLS config of the transport with QUICNextProto set if no protocols are set.
+func (t QUICTransport) tlsConfig() *tls.Config {
+	conf := new(tls.Config)
+	if t.TLSConfig != nil {
+		conf = t.TLSConfig.Clone()
+	}
+	if len(conf.NextProtos) == 0 {
+		conf.NextProtos = []string{QUICNextProto}
+	}
+	return conf
+}
+
+// config returns the QUIC config of the transport.
+func (t QUICTransport) config() *quic.Config {
+	if t.Config != nil {
+		return t.Config
+	}
+	return &quic.Config{MaxIncomingUniStreams: 1000}
+}
+
+// quicAcceptTimeout is the time that a QUIC connection accepted has to open its control stream.
+const quicAcceptTimeout = 10 * time.Second
+
+// quicListener is the Listener of a QUICTransport. Connections are passed to Accept once they opened their
+// control stream.
+type quicListener struct {
+	ln        *quic.Listener
+	conns     chan MessageConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// accept accepts QUIC connections and waits for each of them to open its control stream.
+func (l *quicListener) accept() {
+	for {
+		conn, err := l.ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(conn.Context(), quicAcceptTimeout)
+			defer cancel()
+			control, err := conn.AcceptStream(ctx)
+			if err != nil {
+				_ = conn.CloseWithError(0, "control stream not opened")
+				return
+			}
+			select {
+			case l.conns <- newQUICConn(conn, control):
+			case <-l.closed:
+				_ = conn.CloseWithError(0, "")
+			}
+		}()
+	}
+}
+
+// Accept ...
+func (l *quicListener) Accept() (MessageConn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+// Close ...
+func (l *quicListener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+// Addr ...
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// quicMessage is a message read from one of the streams of a quicConn, or the error that ended reading.
+type quicMessage struct {
+	msg []byte
+	err error
+}
+
+// quicConn is the MessageConn of a QUIC connection. Messages are written to the control stream, or to the
+// unidirectional stream of a lane, in the same format as those of a socketConn. Messages read from all
+// streams are passed to ReadMessage in the order they arrive.
+type quicConn struct {
+	conn    quic.Connection
+	control quic.Stream
+
+	readLimit atomic.Int64
+	deadline  atomic.Pointer[time.Time]
+	messages  chan quicMessage
+	err       error
+
+	// writeMu guards writing to the control stream, and lanesMu the lanes open, each of which guards writing
+	// to its stream itself.
+	writeMu sync.Mutex
+	lanesMu sync.Mutex
+	lanes   map[uint64]*quicLane
+
+	// peerClosed is set once the peer wrote a close message or closed the control stream, after which Close
+	// need not wait for it.
+	peerClosed atomic.Bool
+
+	closeOnce sync.Once
+}
+
+// newQUICConn creates a quicConn of the QUIC connection and control stream passed, and starts reading
+// messages from the control stream and the streams the peer opens.
+func newQUICConn(conn quic.Connection, control quic.Stream) *quicConn {
+	c := &quicConn{conn: conn, control: control, messages: make(chan quicMessage), lanes: make(map[uint64]*quicLane)}
+	c.readLimit.Store(DefaultSocketReadLimit)
+	go c.readControl()
+	go c.acceptLanes()
+	return c
+}
+
+// quicLane is a lane of a quicConn. Its unidirectional stream is opened when the first message on the lane
+// is written.
+type quicLane struct {
+	mu sync.Mutex
+	s  quic.SendStream
+}
+
+// readControl reads messages from the control stream until reading fails.
+func (c *quicConn) readControl() {
+	r := bufio.NewReader(c.control)
+	for {
+		msg, err := readSocketMessage(r, c.readLimit.Load())
+		var closeErr *CloseError
+		if errors.Is(err, io.EOF) || errors.As(err, &closeErr) {
+			c.peerClosed.Store(true)
+		}
+		if !c.deliver(quicMessage{msg: msg, err: err}) || err != nil {
+			return
+		}
+	}
+}
+
+// acceptLanes accepts the unidirectional streams opened by the peer and reads their messages.
+func (c *quicConn) acceptLanes() {
+	for {
+		s, err := c.conn.AcceptUniStream(c.conn.Context())
+		if err != nil {
+			return
+		}
+		go func() {
+			r := bufio.NewReader(s)
+			for {
+				msg, err := readSocketMessage(r, c.readLimit.Load())
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				if err == nil && msg == nil {
+					err = errors.New("close message on lane")
+				}
+				if !c.deliver(quicMessage{msg: msg, err: err}) || err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// deliver passes a message to ReadMessage. False is returned if the connection was closed first.
+func (c *quicConn) deliver(m quicMessage) bool {
+	select {
+	case c.messages <- m:
+		return true
+	case <-c.conn.Context().Done():
+		return false
+	}
+}
+
+// ReadMessage ...
+func (c *quicConn) ReadMessage() ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	var timeout <-chan time.Time
+	if d := c.deadline.Load(); d != nil {
+		t := time.NewTimer(time.Until(*d))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case m := <-c.messages:
+		if m.err != nil {
+			c.err = m.err
+			if errors.Is(m.err, errReadLimit) {
+				_ = c.WriteClose(websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""))
+				_ = c.Close()
+			}
+		}
+		return m.msg, m.err
+	case <-c.conn.Context().Done():
+		c.err = fmt.Errorf("read: %w", net.ErrClosed)
+		return nil, c.err
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteMessage ...
+func (c *quicConn) WriteMessage(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeSocketMessage(c.control, socketMessage, msg)
+}
+
+// WriteMessageLane writes a message to the unidirectional stream of a lane, which is opened if it is not
+// open yet. The stream is closed after writing the message if last is true. Messages on lane 0 are written
+// to the control stream. Only writes on the same lane wait for each other, so a lane blocked by the flow
+// control of its stream does not block the others.
+func (c *quicConn) WriteMessageLane(lane uint64, msg []byte, last bool) error {
+	if lane == 0 {
+		return c.WriteMessage(msg)
+	}
+	c.lanesMu.Lock()
+	l, ok := c.lanes[lane]
+	if !ok {
+		l = new(quicLane)
+		c.lanes[lane] = l
+	}
+	if last {
+		delete(c.lanes, lane)
+	}
+	c.lanesMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.s == nil {
+		s, err := c.conn.OpenUniStreamSync(c.conn.Context())
+		if err != nil {
+			c.releaseLane(lane, l)
+			return err
+		}
+		l.s = s
+	}
+	if err := writeSocketMessage(l.s, socketMessage, msg); err != nil {
+		c.releaseLane(lane, l)
+		return err
+	}
+	if last {
+		return l.s.Close()
+	}
+	return nil
+}
+
+// releaseLane removes a lane that could not be written to from the lanes open, unless it was replaced.
+func (c *quicConn) releaseLane(lane uint64, l *quicLane) {
+	c.lanesMu.Lock()
+	defer c.lanesMu.Unlock()
+	if c.lanes[lane] == l {
+		delete(c.lanes, lane)
+	}
+}
+
+// WriteClose ...
+func (c *quicConn) WriteClose(payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.control.SetWriteDeadline(time.Now().Add(time.Second))
+	return writeSocketMessage(c.control, socketClose, payload)
+}
+
+// quicCloseTimeout is the time that Close waits for the peer to close the connection after closing the
+// control stream, so that the messages written last are not discarded.
+const quicCloseTimeout = time.Second
+
+// Close closes the control stream, which the peer reads as the end of the connection. Unless the peer closed
+// its control stream first, Close then waits for the peer to close the QUIC connection in turn for up to
+// quicCloseTimeout, as closing the QUIC connection right away would discard messages not yet delivered.
+func (c *quicConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.control.Close()
+		if !c.peerClosed.Load() {
+			t := time.NewTimer(quicCloseTimeout)
+			defer t.Stop()
+			select {
+			case <-c.conn.Context().Done():
+			case <-t.C:
+			}
+		}
+		_ = c.conn.CloseWithError(0, "")
+	})
+	return nil
+}
+
+// SetReadLimit sets the read limit of the streams of the connection. If n is not positive,
+// DefaultSocketReadLimit is used.
+func (c *quicConn) SetReadLimit(n int64) {
+	if n <= 0 {
+		n = DefaultSocketReadLimit
+	}
+	c.readLimit.Store(n)
+}
+
+// SetReadDeadline ...
+func (c *quicConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.deadline.Store(nil)
+	} else {
+		c.deadline.Store(&t)
+	}
+	return nil
+}
+
+// RemoteAddr ...
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SelfSignedTLS creates a self-signed certificate for the hosts passed, such as "localhost" or "127.0.0.1",
+// and returns a TLS config holding it for listening, and a TLS config trusting it for dialing. It is meant
+// for testing QUICTransport on loopback, and should not be used in production.
+func SelfSignedTLS(hosts ...string) (server, client *tls.Config, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "vortex"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}}
+	return server, &tls.Config{RootCAs: pool}, nil
+}
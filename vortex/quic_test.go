@@ -0,0 +1,188 @@
+package vortex
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// serveQUIC starts serving the service passed on a QUICTransport listening on loopback, and returns the
+// QUICTransport to dial it with and its address.
+func serveQUIC(t *testing.T, v *Vortex) (QUICTransport, string) {
+	t.Helper()
+	srvTLS, cliTLS, err := SelfSignedTLS("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := QUICTransport{TLSConfig: srvTLS}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go v.Serve(l)
+	return QUICTransport{TLSConfig: cliTLS}, l.Addr().String()
+}
+
+// TestQUICHugeLength checks that a service serving a QUICTransport closes a connection that sends a message
+// with a huge length on its control stream with close code 1009.
+func TestQUICHugeLength(t *testing.T) {
+	v := NewService("test", auth.Auth{Token: "token"}, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	v.RegisterHandler(nopHandler{})
+	tr, addr := serveQUIC(t, v)
+
+	conn, err := tr.Dial(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.(*quicConn).control.Write(binary.AppendUvarint([]byte{socketMessage}, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("got error %v, want close code %v", err, websocket.CloseMessageTooBig)
+	}
+}
+
+// TestQUICLoopback checks that requests made concurrently while a large stream is written over a
+// QUICTransport, each on a lane of its own, are all answered, and that the stream is received in full.
+func TestQUICLoopback(t *testing.T) {
+	h := echoHandler{streams: make(chan []byte, 1)}
+	v := NewService("test", auth.Auth{Token: "token"}, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	v.RegisterPackets(&payload{})
+	v.RegisterSentPackets(&payload{})
+	v.RegisterHandler(h)
+	tr, addr := serveQUIC(t, v)
+
+	c, err := Dialer{
+		Transport:   tr,
+		Packets:     []packet.Packet{&payload{}},
+		SentPackets: []packet.Packet{&payload{}},
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}.Dial(addr, "peer", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go func() {
+		for {
+			if _, err := c.ReadPacket(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	data := compressibleData(4 * 1024 * 1024)
+	streamErr := make(chan error, 1)
+	go func() {
+		w, err := c.OpenStream(ctx, &payload{})
+		if err == nil {
+			if _, err = w.Write(data); err == nil {
+				err = w.Close()
+			}
+		}
+		streamErr <- err
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := []byte(fmt.Sprintf("request %v", i))
+			resp, err := c.Request(ctx, &payload{Data: want})
+			if err != nil {
+				t.Errorf("request %v: %v", i, err)
+				return
+			}
+			if got := resp.(*payload).Data; !bytes.Equal(got, want) {
+				t.Errorf("request %v: got response %q, want %q", i, got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := <-streamErr; err != nil {
+		t.Fatalf("write stream: %v", err)
+	}
+	select {
+	case got := <-h.streams:
+		if !bytes.Equal(got, data) {
+			t.Errorf("got %v bytes of stream data, want the %v bytes written", len(got), len(data))
+		}
+	case <-ctx.Done():
+		t.Fatal("stream not received")
+	}
+}
+
+// TestQUICLanesIndependent checks that writing on a lane of a quicConn does not wait for a lane blocked by
+// the flow control of its stream.
+func TestQUICLanesIndependent(t *testing.T) {
+	srvTLS, cliTLS, err := SelfSignedTLS("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := QUICTransport{TLSConfig: srvTLS}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := QUICTransport{TLSConfig: cliTLS}.Dial(context.Background(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The peer only accepts the control stream once a message was written on it.
+	if err := conn.WriteMessage([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// The peer never calls ReadMessage, so it stops reading lane 1 after its first message, and the second
+	// message, which is larger than the receive window of a stream, blocks lane 1.
+	lanes := conn.(laneConn)
+	if err := lanes.WriteMessageLane(1, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- lanes.WriteMessageLane(1, make([]byte, 8*1024*1024), false)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-blocked:
+		t.Fatalf("lane 1 not blocked: %v", err)
+	default:
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lanes.WriteMessageLane(2, []byte{2}, true)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lane 2 blocked by lane 1")
+	}
+}
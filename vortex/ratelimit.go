@@ -0,0 +1,251 @@
+package vortex
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitAction is the action taken for a packet received that exceeds a RateLimit.
+type RateLimitAction uint8
+
+const (
+	// RateLimitDrop drops packets exceeding the limit without handling them.
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitDelay delays handling packets exceeding the limit until the limit allows them. No other
+	// packets are read from the connection in the meantime, which slows down the peer as well.
+	RateLimitDelay
+	// RateLimitThrottle drops packets exceeding the limit and sends a packet.Throttle to the peer, holding
+	// the time after which the packet is allowed again. Packets are dropped without a packet.Throttle for
+	// peers that do not support packet.CapabilityThrottling.
+	RateLimitThrottle
+	// RateLimitDisconnect closes the connection when a packet exceeds the limit. The *RateLimitError passed
+	// to the DisconnectHandler holds the limit that was exceeded.
+	RateLimitDisconnect
+)
+
+// rateLimitActions holds the names of the RateLimitAction constants.
+var rateLimitActions = [...]string{"drop", "delay", "throttle", "disconnect"}
+
+// String returns the name of the action, such as "drop".
+func (a RateLimitAction) String() string {
+	if int(a) < len(rateLimitActions) {
+		return rateLimitActions[a]
+	}
+	return fmt.Sprintf("RateLimitAction(%d)", a)
+}
+
+// MarshalText encodes the action as its name, so that it may be written to configuration files.
+func (a RateLimitAction) MarshalText() ([]byte, error) {
+	if int(a) >= len(rateLimitActions) {
+		return nil, fmt.Errorf("unknown rate limit action %d", a)
+	}
+	return []byte(rateLimitActions[a]), nil
+}
+
+// UnmarshalText decodes an action from its name, so that it may be read from configuration files.
+func (a *RateLimitAction) UnmarshalText(text []byte) error {
+	for i, name := range rateLimitActions {
+		if name == string(text) {
+			*a = RateLimitAction(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown rate limit action %q", text)
+}
+
+// RateLimit is a token bucket limiting the rate of packets received. The bucket holds up to Burst tokens
+// and is refilled with Rate tokens per second. Every packet takes a token, and packets arriving while the
+// bucket is empty exceed the limit. A RateLimit with a Rate of 0 does not limit anything.
+type RateLimit struct {
+	// Rate is the number of packets per second allowed on average.
+	Rate float64
+	// Burst is the number of packets allowed at once after a period without packets. If smaller than 1, a
+	// Burst of 1 is used.
+	Burst int
+	// Action is the action taken for packets exceeding the limit.
+	Action RateLimitAction
+}
+
+// RateLimits holds the rate limits of the packets that connections send to a service after logging in. A
+// packet must be allowed by every limit that applies to it. The limits are checked from the most to the
+// least specific: Packets, Conn, Identity and Global. The action of the first limit exceeded is taken, and
+// a packet that is not allowed takes no tokens from any of the limits. Limits left zero do not apply.
+// Opening a stream counts as receiving the packet it was opened with, and a stream that is not allowed is
+// reset. The data sent on streams is not counted, as the rate at which it is sent is already bounded by the
+// StreamHandler reading it through StreamWindow.
+type RateLimits struct {
+	// Global limits the packets of all connections of the service together.
+	Global RateLimit
+	// Identity limits the packets of all connections logged in with the same identity together.
+	Identity RateLimit
+	// Identities holds the limits of specific identities, replacing Identity for them.
+	Identities map[string]RateLimit
+	// Conn limits the packets of every connection.
+	Conn RateLimit
+	// Packets holds the limits of packets with specific IDs, which apply to every connection separately.
+	Packets map[uint32]RateLimit
+}
+
+// bucket holds the tokens of a RateLimit. The Rate and Burst of the limit are passed every time a token is
+// taken, so that limits may be changed without losing the state of their buckets.
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allows refills the bucket for the time passed since the last packet and checks if a token may be taken
+// from it. If the bucket is empty, false is returned with the time until a token is available, unless the
+// action of the limit is RateLimitDelay, in which case the token may be reserved and the time to wait for it
+// is returned. The token is only taken by calling take. b.mu must be held.
+func (b *bucket) allows(l RateLimit, now time.Time) (time.Duration, bool) {
+	burst := float64(max(l.Burst, 1))
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+
+	wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	if b.tokens >= 1 {
+		return 0, true
+	}
+	return wait, l.Action == RateLimitDelay
+}
+
+// take takes a token from the bucket after allows reported that it may be taken. The tokens of a bucket
+// become negative when tokens are reserved by RateLimitDelay. b.mu must be held.
+func (b *bucket) take() {
+	b.tokens--
+}
+
+// connBuckets holds the buckets of the limits that apply to a single connection. It is only used by the
+// goroutine reading the connection.
+type connBuckets struct {
+	conn    bucket
+	packets map[uint32]*bucket
+}
+
+// packet returns the bucket of the packet ID passed, creating it if it does not exist yet.
+func (b *connBuckets) packet(id uint32) *bucket {
+	if b.packets == nil {
+		b.packets = make(map[uint32]*bucket)
+	}
+	pb, ok := b.packets[id]
+	if !ok {
+		pb = new(bucket)
+		b.packets[id] = pb
+	}
+	return pb
+}
+
+// identityBucket is the bucket shared by the connections logged in with the same identity.
+type identityBucket struct {
+	bucket
+	conns int
+}
+
+// rateLimiter applies the RateLimits of a service to the packets received by its connections.
+type rateLimiter struct {
+	limits atomic.Pointer[RateLimits]
+	global bucket
+
+	mu         sync.Mutex
+	identities map[string]*identityBucket
+}
+
+// set replaces the limits applied. The buckets of the limits are kept.
+func (l *rateLimiter) set(limits RateLimits) {
+	l.limits.Store(&limits)
+}
+
+// acquire returns the bucket of the identity passed for a connection that logged in with it. release must
+// be called with the identity once the connection is closed.
+func (l *rateLimiter) acquire(identity string) *identityBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.identities == nil {
+		l.identities = make(map[string]*identityBucket)
+	}
+	b, ok := l.identities[identity]
+	if !ok {
+		b = new(identityBucket)
+		l.identities[identity] = b
+	}
+	b.conns++
+	return b
+}
+
+// release releases the bucket of the identity passed for a connection that was closed. The bucket is
+// removed once no connections with the identity are left.
+func (l *rateLimiter) release(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.identities[identity]; ok {
+		if b.conns--; b.conns == 0 {
+			delete(l.identities, identity)
+		}
+	}
+}
+
+// rateLimitCheck is the result of applying the rate limits to a packet.
+type rateLimitCheck struct {
+	// allowed is true if the packet may be handled, possibly after waiting for delay.
+	allowed bool
+	delay   time.Duration
+	// scope and limit are the scope and limit exceeded if allowed is false, with retryAfter being the time
+	// after which the limit allows the packet.
+	scope      string
+	limit      RateLimit
+	retryAfter time.Duration
+}
+
+// check applies the limits to a packet with the ID passed received by a connection.
+func (l *rateLimiter) check(c *Conn, id uint32) rateLimitCheck {
+	limits := l.limits.Load()
+	if limits == nil {
+		return rateLimitCheck{allowed: true}
+	}
+	identity := limits.Identity
+	if il, ok := limits.Identities[c.Identity()]; ok {
+		identity = il
+	}
+
+	type applied struct {
+		scope string
+		limit RateLimit
+		b     *bucket
+	}
+	var scopes []applied
+	add := func(scope string, limit RateLimit, b func() *bucket) {
+		if limit.Rate > 0 {
+			scopes = append(scopes, applied{scope: scope, limit: limit, b: b()})
+		}
+	}
+	add("packet", limits.Packets[id], func() *bucket { return c.buckets.packet(id) })
+	add("connection", limits.Conn, func() *bucket { return &c.buckets.conn })
+	add("identity", identity, func() *bucket { return &c.identityBucket.bucket })
+	add("global", limits.Global, func() *bucket { return &l.global })
+
+	// The buckets are all locked before checking any of them, always in the same order, so that tokens are
+	// only taken once every limit allows the packet, without other connections taking them in between.
+	for _, s := range scopes {
+		s.b.mu.Lock()
+		defer s.b.mu.Unlock()
+	}
+	now, res := time.Now(), rateLimitCheck{allowed: true}
+	for _, s := range scopes {
+		wait, ok := s.b.allows(s.limit, now)
+		if !ok {
+			return rateLimitCheck{scope: s.scope, limit: s.limit, retryAfter: wait}
+		}
+		res.delay = max(res.delay, wait)
+	}
+	for _, s := range scopes {
+		s.b.take()
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package vortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// errNotRequest is returned by Reply if the context passed does not belong to a request.
+var errNotRequest = errors.New("context does not carry a request")
+
+// requestKey is the context key that the ID of a request received is stored under.
+type requestKey struct{}
+
+// Request writes a packet to the connection as a request and waits for the peer to respond to it using
+// Reply. The response is returned, or an error if ctx is done or the connection closed before a response
+// was received. If ctx carries a trace context, it is sent along with the request. An error wrapping
+// errors.ErrUnsupported is returned if the peer does not support requests.
+//
+// The response is read by the goroutine reading the connection, which is also the goroutine that calls the
+// Handler of a service, or the goroutine calling ReadPacket on a connection returned by a Dialer. Request
+// must therefore not be called from that goroutine, such as from within HandlePacket: no response could be
+// read until it returned, so Request would only return once ctx is done. Call Request from a goroutine of
+// its own instead.
+func (c *Conn) Request(ctx context.Context, pk packet.Packet) (packet.Packet, error) {
+	if !c.supports(packet.CapabilityRequests) {
+		return nil, fmt.Errorf("request: %w", errors.ErrUnsupported)
+	}
+	h := c.header(ctx)
+	h.flags |= flagRequest
+	h.requestID = c.nextRequest.Add(1)
+
+	ch := make(chan packet.Packet, 1)
+	c.requestsMu.Lock()
+	c.requests[h.requestID] = ch
+	c.requestsMu.Unlock()
+	defer func() {
+		c.requestsMu.Lock()
+		delete(c.requests, h.requestID)
+		c.requestsMu.Unlock()
+	}()
+
+	if err := c.write(h, pk, false); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Reply writes a packet to the connection as the response to the request that ctx belongs to. ctx must be
+// the context passed to a ContextHandler or returned by ReadPacketContext for a request.
+func (c *Conn) Reply(ctx context.Context, pk packet.Packet) error {
+	id, ok := ctx.Value(requestKey{}).(uint32)
+	if !ok {
+		return errNotRequest
+	}
+	h := c.header(ctx)
+	h.flags |= flagResponse
+	h.requestID = id
+	return c.write(h, pk, false)
+}
+
+// respond passes a response to the request with the ID passed. Responses to requests that are no longer
+// waiting are dropped.
+func (c *Conn) respond(id uint32, pk packet.Packet) {
+	c.requestsMu.Lock()
+	ch, ok := c.requests[id]
+	c.requestsMu.Unlock()
+	if !ok {
+		c.log.Debug("response to unknown request", "packet", pk.ID(), "request", id)
+		return
+	}
+	select {
+	case ch <- pk:
+	default:
+		// A response was already received for this request.
+	}
+}
@@ -0,0 +1,75 @@
+package vortex
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// requestHandler is a Handler that makes a request to the peer for every packet received, either from within
+// HandlePacket or from a goroutine of its own, and passes the error returned by Request to errs.
+type requestHandler struct {
+	async bool
+	errs  chan error
+}
+
+// HandlePacket ...
+func (h requestHandler) HandlePacket(c *Conn, _ packet.Packet) {
+	request := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_, err := c.Request(ctx, &payload{Data: []byte("request")})
+		h.errs <- err
+	}
+	if h.async {
+		go request()
+	} else {
+		request()
+	}
+}
+
+// TestRequestFromHandler checks that a handler of a service gets a response to a request made from a
+// goroutine of its own, while a request made from within the handler blocks the goroutine reading the
+// connection until its context is done, as documented on Conn.Request.
+func TestRequestFromHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		async bool
+		want  error
+	}{
+		{"goroutine", true, nil},
+		{"handler", false, context.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := requestHandler{async: tc.async, errs: make(chan error, 1)}
+			v := NewService("test", auth.Auth{Token: "token"}, WithLogger(testLogger()))
+			v.RegisterPackets(&payload{})
+			v.RegisterHandler(h)
+
+			c, err := Dialer{Logger: testLogger(), Packets: []packet.Packet{&payload{}}}.Dial(serveTest(t, v), "peer", "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			go func() {
+				for {
+					ctx, pk, err := c.ReadPacketContext()
+					if err != nil {
+						return
+					}
+					_ = c.Reply(ctx, pk)
+				}
+			}()
+			if err := c.WritePacket(&payload{Data: []byte("packet")}, false); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-h.errs; !errors.Is(err, tc.want) {
+				t.Errorf("got error %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
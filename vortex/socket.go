@@ -0,0 +1,223 @@
+package vortex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TCPTransport is a Transport carrying messages over plain TCP connections, with every message prefixed
+// with its length. It avoids the overhead of websockets for links between services in the same network,
+// but does not encrypt anything, so it should only be used within trusted networks or together with
+// WithEncryption. Addresses are TCP addresses such as "localhost:8080".
+type TCPTransport struct{}
+
+// Listen ...
+func (TCPTransport) Listen(addr string) (Listener, error) {
+	return listenSocket("tcp", addr)
+}
+
+// Dial ...
+func (TCPTransport) Dial(ctx context.Context, addr string) (MessageConn, error) {
+	return dialSocket(ctx, "tcp", addr)
+}
+
+// UnixTransport is a Transport carrying messages over Unix domain sockets, with every message prefixed
+// with its length. It is meant for peers on the same host, such as sidecars. Addresses are paths of socket
+// files, which are removed when the Listener is closed. All peers connected over a Unix socket share the
+// same address for the PerIP limit of ConnectionLimits.
+type UnixTransport struct{}
+
+// Listen ...
+func (UnixTransport) Listen(addr string) (Listener, error) {
+	return listenSocket("unix", addr)
+}
+
+// Dial ...
+func (UnixTransport) Dial(ctx context.Context, addr string) (MessageConn, error) {
+	return dialSocket(ctx, "unix", addr)
+}
+
+// listenSocket listens for socket connections on the network and address passed.
+func listenSocket(network, addr string) (Listener, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return socketListener{ln}, nil
+}
+
+// dialSocket dials a socket connection on the network and address passed.
+func dialSocket(ctx context.Context, network, addr string) (MessageConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newSocketConn(conn), nil
+}
+
+// socketListener is the Listener of TCPTransport and UnixTransport.
+type socketListener struct {
+	net.Listener
+}
+
+// Accept ...
+func (l socketListener) Accept() (MessageConn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newSocketConn(conn), nil
+}
+
+const (
+	// socketMessage and socketClose are the types of the messages of a socketConn.
+	socketMessage byte = iota
+	socketClose
+)
+
+// errReadLimit is returned when reading a message larger than the read limit of a socketConn.
+var errReadLimit = errors.New("read limit exceeded")
+
+// DefaultSocketReadLimit is the maximum size in bytes of the messages read by the connections of
+// TCPTransport, UnixTransport and QUICTransport if no read limit is set. The length of a message is sent by
+// the peer, so without a limit, a single peer could make the service allocate an arbitrary amount of memory.
+const DefaultSocketReadLimit = 16 * 1024 * 1024
+
+// socketReadChunk is the size in bytes up to which the buffer of a message is allocated before reading it.
+// Larger messages are read into a buffer that grows as the message is received, so that the memory used
+// depends on the bytes actually sent rather than on the length that the peer claims.
+const socketReadChunk = 64 * 1024
+
+// socketConn is the MessageConn of a stream-oriented net.Conn. Every message is written as its type,
+// socketMessage or socketClose, followed by its length as a varuint64 and its payload.
+type socketConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	readLimit int64
+	writeMu   sync.Mutex
+}
+
+// newSocketConn creates a socketConn reading and writing the net.Conn passed.
+func newSocketConn(conn net.Conn) *socketConn {
+	return &socketConn{conn: conn, r: bufio.NewReader(conn), readLimit: DefaultSocketReadLimit}
+}
+
+// ReadMessage ...
+func (c *socketConn) ReadMessage() ([]byte, error) {
+	msg, err := readSocketMessage(c.r, c.readLimit)
+	if errors.Is(err, errReadLimit) {
+		_ = c.WriteClose(websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""))
+		_ = c.conn.Close()
+	}
+	return msg, err
+}
+
+// readSocketMessage reads a message with its type and length from the reader passed. A *CloseError is
+// returned for a close message. If limit is positive, errReadLimit is returned for messages larger than
+// limit without reading them.
+func readSocketMessage(r *bufio.Reader, limit int64) ([]byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if limit > 0 && n > uint64(limit) {
+		return nil, errReadLimit
+	}
+	var msg []byte
+	if n <= socketReadChunk {
+		msg = make([]byte, n)
+		_, err = io.ReadFull(r, msg)
+	} else {
+		buf := bytes.NewBuffer(make([]byte, 0, socketReadChunk))
+		_, err = io.CopyN(buf, r, int64(n))
+		msg = buf.Bytes()
+	}
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch typ {
+	case socketMessage:
+		return msg, nil
+	case socketClose:
+		if len(msg) < 2 {
+			return nil, &CloseError{Code: websocket.CloseNoStatusReceived}
+		}
+		return nil, &CloseError{Code: int(binary.BigEndian.Uint16(msg)), Text: string(msg[2:])}
+	}
+	return nil, fmt.Errorf("unknown message type %v", typ)
+}
+
+// unexpectedEOF turns an io.EOF returned while reading a message into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteMessage ...
+func (c *socketConn) WriteMessage(msg []byte) error {
+	return c.write(socketMessage, msg)
+}
+
+// WriteClose ...
+func (c *socketConn) WriteClose(payload []byte) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.write(socketClose, payload)
+}
+
+// write writes a message of the type passed.
+func (c *socketConn) write(typ byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeSocketMessage(c.conn, typ, payload)
+}
+
+// writeSocketMessage writes a message of the type passed, prefixed with its type and length, to the writer
+// passed.
+func writeSocketMessage(w io.Writer, typ byte, payload []byte) error {
+	header := binary.AppendUvarint([]byte{typ}, uint64(len(payload)))
+	buf := net.Buffers{header, payload}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// SetReadLimit sets the read limit of the connection. If n is not positive, DefaultSocketReadLimit is used.
+func (c *socketConn) SetReadLimit(n int64) {
+	if n <= 0 {
+		n = DefaultSocketReadLimit
+	}
+	c.readLimit = n
+}
+
+// SetReadDeadline ...
+func (c *socketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr ...
+func (c *socketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close ...
+func (c *socketConn) Close() error {
+	return c.conn.Close()
+}
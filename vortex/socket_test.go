@@ -0,0 +1,84 @@
+package vortex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// nopHandler is a Handler that ignores every packet.
+type nopHandler struct{}
+
+// HandlePacket ...
+func (nopHandler) HandlePacket(*Conn, packet.Packet) {}
+
+// TestReadSocketMessageLength checks that messages with a length above the read limit are refused without
+// allocating them, and that messages of a valid length larger than the read chunk are read in full.
+func TestReadSocketMessageLength(t *testing.T) {
+	for _, n := range []uint64{DefaultSocketReadLimit + 1, 1 << 62, math.MaxUint64} {
+		header := binary.AppendUvarint([]byte{socketMessage}, n)
+		_, err := readSocketMessage(bufio.NewReader(bytes.NewReader(header)), DefaultSocketReadLimit)
+		if !errors.Is(err, errReadLimit) {
+			t.Errorf("length %v: got error %v, want %v", n, err, errReadLimit)
+		}
+	}
+
+	payload := bytes.Repeat([]byte{7}, socketReadChunk*3+1)
+	buf := new(bytes.Buffer)
+	if err := writeSocketMessage(buf, socketMessage, payload); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := readSocketMessage(bufio.NewReader(buf), DefaultSocketReadLimit)
+	if err != nil || !bytes.Equal(msg, payload) {
+		t.Errorf("got %v bytes and error %v, want %v bytes", len(msg), err, len(payload))
+	}
+
+	header := binary.AppendUvarint([]byte{socketMessage}, socketReadChunk*2)
+	_, err = readSocketMessage(bufio.NewReader(bytes.NewReader(append(header, 1, 2, 3))), DefaultSocketReadLimit)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated message: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// TestServeHugeLength checks that a service serving a TCPTransport closes a connection that sends a message
+// with a huge length before logging in with close code 1009, and keeps accepting other connections.
+func TestServeHugeLength(t *testing.T) {
+	v := NewService("test", auth.Auth{Token: "token"}, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	v.RegisterHandler(nopHandler{})
+	l, err := TCPTransport{}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go v.Serve(l)
+
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Write(binary.AppendUvarint([]byte{socketMessage}, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = newSocketConn(raw).ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("got error %v, want close code %v", err, websocket.CloseMessageTooBig)
+	}
+
+	c, err := Dialer{Transport: TCPTransport{}}.Dial(l.Addr().String(), "peer", "token")
+	if err != nil {
+		t.Fatalf("dial after huge message: %v", err)
+	}
+	_ = c.Close()
+}
@@ -0,0 +1,346 @@
+package vortex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+const (
+	// StreamWindow is the number of bytes of a stream that may be sent before the receiver has read them.
+	// Writing to a stream blocks once the window is used up, until the receiver reads more of the data.
+	StreamWindow = 256 * 1024
+	// maxStreamChunk is the maximum number of bytes of data held by a single stream frame.
+	maxStreamChunk = 16 * 1024
+)
+
+var (
+	// errStreamsNotAccepted is the reason a stream is reset if the connection does not accept streams.
+	errStreamsNotAccepted = errors.New("streams not accepted")
+	// errStreamNotRead is the reason a stream is reset if its handler returned before reading all of it.
+	errStreamNotRead = errors.New("stream handler returned before reading stream")
+	// errStreamRateLimited is the reason a stream is reset if opening it exceeded a rate limit of the
+	// service.
+	errStreamRateLimited = errors.New("rate limit exceeded")
+	// errStreamDenied is the reason a stream is reset if the policy of the service denies the packet it was
+	// opened with.
+	errStreamDenied = errors.New("permission denied")
+	// errStreamWindow is the reason a stream is reset if more data was sent than its window allows.
+	errStreamWindow = errors.New("stream window exceeded")
+)
+
+// stream is one side of a stream of data multiplexed over a Conn. A stream is unidirectional: the side that
+// opened it writes data, and the other side reads it.
+type stream struct {
+	c  *Conn
+	id uint32
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// window is the number of bytes the writer may still send.
+	window int
+	// buf holds data received that was not yet read. outstanding is the number of bytes received that the
+	// window was not yet grown by again, of which consumed bytes were read.
+	buf         bytes.Buffer
+	outstanding int
+	consumed    int
+	// eof is true once the writer closed the stream.
+	eof bool
+	err error
+}
+
+// newStream creates a stream with the ID passed and registers it with the Conn.
+func (c *Conn) newStream(id uint32) *stream {
+	s := &stream{c: c, id: id, window: StreamWindow}
+	s.cond = sync.NewCond(&s.mu)
+
+	c.streamsMu.Lock()
+	c.streams[id] = s
+	c.streamsMu.Unlock()
+	return s
+}
+
+// stream returns the stream with the ID passed, or nil if it does not exist.
+func (c *Conn) stream(id uint32) *stream {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	return c.streams[id]
+}
+
+// OpenStream opens a stream to the peer, which receives it through its StreamHandler together with the
+// packet meta passed, which must be registered with the peer. Data written to the stream is sent in chunks,
+// and Write blocks while the peer has not yet read StreamWindow bytes written. The stream must be closed
+// after writing all data. If ctx is done before the stream is closed, the stream is reset and Write returns
+// the error of ctx. If ctx carries a trace context, it is sent along with meta. An error wrapping
+// errors.ErrUnsupported is returned if the peer does not support streams.
+func (c *Conn) OpenStream(ctx context.Context, meta packet.Packet) (io.WriteCloser, error) {
+	if !c.supports(packet.CapabilityStreams) {
+		return nil, fmt.Errorf("open stream: %w", errors.ErrUnsupported)
+	}
+	// Streams opened by the dialing side have odd IDs and streams opened by the accepting side even IDs,
+	// so that both sides may open streams without their IDs colliding.
+	id := c.nextStream.Add(1) * 2
+	if c.client {
+		id--
+	}
+	s := c.newStream(id)
+
+	h := c.header(ctx)
+	h.flags |= flagStream
+	h.streamID, h.streamOp = id, streamOpen
+	if err := c.writeFrame(encodeFrame(h, meta)); err != nil {
+		c.removeStream(id)
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		s.reset(ctx.Err(), true)
+	})
+	return &streamWriter{s: s, stop: stop}, nil
+}
+
+// acceptStream accepts a stream opened by the peer with the ID and packet passed, and serves it to the
+// StreamHandler.
+func (c *Conn) acceptStream(id uint32, meta packet.Packet) {
+	if c.streamHandler == nil || !c.LoggedIn() {
+		c.writeStreamReset(id, errStreamsNotAccepted)
+		return
+	}
+	if c.stream(id) != nil {
+		c.log.Warn("stream opened twice", "stream", id)
+		return
+	}
+	go c.serveStream(c.newStream(id), meta)
+}
+
+// handleStream handles a stream frame received other than one opening a stream.
+func (c *Conn) handleStream(r received) {
+	id := r.h.streamID
+	s := c.stream(id)
+	if s == nil {
+		// The stream was already closed or reset by this side, so frames still arriving are dropped.
+		return
+	}
+	switch r.h.streamOp {
+	case streamData:
+		s.receive(r.data)
+	case streamWindow:
+		var n uint32
+		if err := proto.Varuint32(bytes.NewReader(r.data), &n); err != nil {
+			s.reset(err, true)
+			return
+		}
+		s.grow(int(n))
+	case streamClose:
+		s.closeRead()
+	case streamReset:
+		var reason string
+		func() {
+			defer func() {
+				if recover() != nil {
+					reason = "unknown"
+				}
+			}()
+			proto.NewReader(bytes.NewReader(r.data), 1, false).String(&reason)
+		}()
+		s.reset(&StreamResetError{Reason: reason}, false)
+	default:
+		c.log.Warn("unknown stream operation", "stream", id, "op", r.h.streamOp)
+	}
+}
+
+// serveStream passes a stream opened by the peer to the StreamHandler. If the handler returns before the
+// stream was read to the end, the stream is reset.
+func (c *Conn) serveStream(s *stream, meta packet.Packet) {
+	c.streamHandler.HandleStream(c, meta, streamReader{s: s})
+
+	s.mu.Lock()
+	done := s.err != nil || (s.eof && s.buf.Len() == 0)
+	s.mu.Unlock()
+	if !done {
+		s.reset(errStreamNotRead, true)
+	}
+	c.removeStream(s.id)
+}
+
+// removeStream removes the stream with the ID passed from the Conn.
+func (c *Conn) removeStream(id uint32) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	delete(c.streams, id)
+}
+
+// closeStreams fails all streams of the Conn with net.ErrClosed. It is called when the Conn is closed.
+func (c *Conn) closeStreams() {
+	c.streamsMu.Lock()
+	streams := c.streams
+	c.streams = make(map[uint32]*stream)
+	c.streamsMu.Unlock()
+
+	for _, s := range streams {
+		s.reset(net.ErrClosed, false)
+	}
+}
+
+// writeStreamReset writes a frame resetting the stream with the ID passed with err as reason.
+func (c *Conn) writeStreamReset(id uint32, err error) {
+	buf := bytes.NewBuffer(nil)
+	reason := err.Error()
+	proto.NewWriter(buf, 1).String(&reason)
+	if err := c.writeFrame(streamFrame(id, streamReset, buf.Bytes())); err != nil {
+		c.log.Debug("write stream reset", "stream", id, "err", err)
+	}
+}
+
+// streamFrame returns a frame with a stream operation and the data passed.
+func streamFrame(id uint32, op byte, data []byte) frame {
+	return frame{h: header{flags: flagStream, streamID: id, streamOp: op}, body: data, data: true}
+}
+
+// receive adds data received to the buffer of the stream. The stream is reset if the data exceeds its
+// window.
+func (s *stream) receive(data []byte) {
+	s.mu.Lock()
+	if s.outstanding+len(data) > StreamWindow {
+		s.mu.Unlock()
+		s.reset(errStreamWindow, true)
+		return
+	}
+	s.outstanding += len(data)
+	s.buf.Write(data)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// grow grows the window of the stream by n bytes.
+func (s *stream) grow(n int) {
+	s.mu.Lock()
+	s.window += n
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// closeRead marks the stream as closed by the writer, so that reads return io.EOF once all data was read.
+func (s *stream) closeRead() {
+	s.mu.Lock()
+	s.eof = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// reset aborts the stream with the error passed, which is returned by any read or write on the stream
+// from then on. If send is true, the peer is notified.
+func (s *stream) reset(err error, send bool) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	s.mu.Unlock()
+	s.cond.Broadcast()
+
+	s.c.removeStream(s.id)
+	if send {
+		s.c.writeStreamReset(s.id, err)
+	}
+}
+
+// streamWriter is the io.WriteCloser returned by Conn.OpenStream.
+type streamWriter struct {
+	s    *stream
+	stop func() bool
+}
+
+// Write writes p to the stream in chunks, blocking while the window of the stream is used up.
+func (w *streamWriter) Write(p []byte) (n int, err error) {
+	s := w.s
+	for len(p) > 0 {
+		s.mu.Lock()
+		for s.window == 0 && s.err == nil && !s.eof {
+			s.cond.Wait()
+		}
+		if s.err != nil || s.eof {
+			err := s.err
+			s.mu.Unlock()
+			if err == nil {
+				err = io.ErrClosedPipe
+			}
+			return n, err
+		}
+		k := min(len(p), s.window, maxStreamChunk)
+		s.window -= k
+		s.mu.Unlock()
+
+		if err := s.c.writeFrame(streamFrame(s.id, streamData, append([]byte(nil), p[:k]...))); err != nil {
+			return n, err
+		}
+		n, p = n+k, p[k:]
+	}
+	return n, nil
+}
+
+// Close closes the stream, so that the peer reads io.EOF after all data written.
+func (w *streamWriter) Close() error {
+	w.stop()
+	s := w.s
+	s.mu.Lock()
+	if s.err != nil || s.eof {
+		err := s.err
+		s.mu.Unlock()
+		return err
+	}
+	s.eof = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+
+	s.c.removeStream(s.id)
+	return s.c.writeFrame(streamFrame(s.id, streamClose, nil))
+}
+
+// streamReader is the io.Reader passed to a StreamHandler.
+type streamReader struct {
+	s *stream
+}
+
+// Read reads data received on the stream, blocking until data is available. io.EOF is returned once the
+// writer closed the stream and all data was read.
+func (r streamReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.mu.Lock()
+	for s.buf.Len() == 0 && !s.eof && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.buf.Len() == 0 {
+		err := s.err
+		s.mu.Unlock()
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	n, _ := s.buf.Read(p)
+	s.consumed += n
+	grow := 0
+	if s.consumed >= StreamWindow/2 && !s.eof {
+		grow = s.consumed
+		s.outstanding -= s.consumed
+		s.consumed = 0
+	}
+	s.mu.Unlock()
+
+	if grow > 0 {
+		buf := bytes.NewBuffer(nil)
+		_ = proto.WriteVaruint32(buf, uint32(grow))
+		if err := s.c.writeFrame(streamFrame(s.id, streamWindow, buf.Bytes())); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,279 @@
+package vortex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto/packet"
+)
+
+// streamHandler is a Handler that passes every stream opened by a peer to the function.
+type streamHandler func(meta packet.Packet, r io.Reader)
+
+// HandlePacket ...
+func (streamHandler) HandlePacket(*Conn, packet.Packet) {}
+
+// HandleStream ...
+func (h streamHandler) HandleStream(_ *Conn, meta packet.Packet, r io.Reader) {
+	h(meta, r)
+}
+
+// dialStreams dials a service with the Handler passed and returns a connection to it that is read until the
+// test ends, so that the stream frames sent by the service are handled. The functions passed configure the
+// service after its packets are registered.
+func dialStreams(t *testing.T, h Handler, configure ...func(v *Vortex)) *Conn {
+	t.Helper()
+	v := NewService("test", auth.Auth{Token: "token"}, WithLogger(testLogger()))
+	v.RegisterPackets(&payload{})
+	v.RegisterHandler(h)
+	for _, f := range configure {
+		f(v)
+	}
+	c, err := Dialer{Logger: testLogger()}.Dial(serveTest(t, v), "peer", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	go func() {
+		for {
+			if _, err := c.ReadPacket(); err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// streamResult is the data read from a stream by a handler and the error that ended reading it.
+type streamResult struct {
+	meta packet.Packet
+	data []byte
+	err  error
+}
+
+// readStream returns a streamHandler that reads every stream to the end and passes the result to the
+// channel passed.
+func readStream(results chan<- streamResult) streamHandler {
+	return func(meta packet.Packet, r io.Reader) {
+		data, err := io.ReadAll(r)
+		results <- streamResult{meta: meta, data: data, err: err}
+	}
+}
+
+// writeUntilReset writes to a stream until writing fails, and returns the error.
+func writeUntilReset(t *testing.T, w io.Writer) error {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := w.Write(make([]byte, 1024)); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("stream not reset")
+	return nil
+}
+
+// checkReset fails the test if err is not a *StreamResetError with the reason passed.
+func checkReset(t *testing.T, err error, reason error) {
+	t.Helper()
+	var resetErr *StreamResetError
+	if !errors.As(err, &resetErr) || resetErr.Reason != reason.Error() {
+		t.Errorf("got error %v, want stream reset with reason %q", err, reason)
+	}
+}
+
+// TestStream checks that the data written to a stream, several times the size of its window, is read by the
+// StreamHandler of the peer in full, together with the packet the stream was opened with, followed by
+// io.EOF once the stream is closed.
+func TestStream(t *testing.T) {
+	results := make(chan streamResult, 1)
+	c := dialStreams(t, readStream(results))
+
+	w, err := c.OpenStream(context.Background(), &payload{Data: []byte("meta")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("stream data "), StreamWindow)
+	if n, err := w.Write(data); err != nil || n != len(data) {
+		t.Fatalf("wrote %v bytes with error %v, want %v bytes", n, err, len(data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("read stream: %v", res.err)
+	}
+	if meta, ok := res.meta.(*payload); !ok || string(meta.Data) != "meta" {
+		t.Errorf("got meta %#v, want payload %q", res.meta, "meta")
+	}
+	if !bytes.Equal(res.data, data) {
+		t.Errorf("read %v bytes, want the %v bytes written", len(res.data), len(data))
+	}
+	if _, err := w.Write([]byte{1}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("write after close: got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+// TestStreamFlowControl checks that writing to a stream blocks once StreamWindow bytes were written that the
+// peer did not read, and continues once the peer reads them.
+func TestStreamFlowControl(t *testing.T) {
+	read := make(chan struct{})
+	results := make(chan streamResult, 1)
+	c := dialStreams(t, streamHandler(func(meta packet.Packet, r io.Reader) {
+		<-read
+		readStream(results)(meta, r)
+	}))
+
+	w, err := c.OpenStream(context.Background(), &payload{Data: []byte("meta")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, StreamWindow+1))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("write beyond the window returned before the peer read the stream: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(read)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; res.err != nil || len(res.data) != StreamWindow+1 {
+		t.Errorf("read %v bytes with error %v, want %v bytes", len(res.data), res.err, StreamWindow+1)
+	}
+}
+
+// TestStreamWindowExceeded checks that a stream is reset if the writer sends more data than the window
+// allows.
+func TestStreamWindowExceeded(t *testing.T) {
+	read := make(chan struct{})
+	results := make(chan streamResult, 1)
+	c := dialStreams(t, streamHandler(func(meta packet.Packet, r io.Reader) {
+		<-read
+		readStream(results)(meta, r)
+	}))
+
+	w, err := c.OpenStream(context.Background(), &payload{Data: []byte("meta")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames are written directly, bypassing the window kept by the writer.
+	id := w.(*streamWriter).s.id
+	for i := 0; i <= StreamWindow/maxStreamChunk; i++ {
+		if err := c.writeFrame(streamFrame(id, streamData, make([]byte, maxStreamChunk))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkReset(t, writeUntilReset(t, w), errStreamWindow)
+	close(read)
+	if res := <-results; !errors.Is(res.err, errStreamWindow) {
+		t.Errorf("read: got error %v, want %v", res.err, errStreamWindow)
+	}
+}
+
+// TestStreamReset checks that a stream is reset for the peer with the error of the context passed to
+// OpenStream once it is done.
+func TestStreamReset(t *testing.T) {
+	results := make(chan streamResult, 1)
+	c := dialStreams(t, readStream(results))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := c.OpenStream(ctx, &payload{Data: []byte("meta")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	checkReset(t, (<-results).err, context.Canceled)
+	if _, err := w.Write([]byte{1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("write after reset: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestStreamHandlerReturns checks that a stream is reset for the writer if the StreamHandler of the peer
+// returns before reading it to the end, or if the peer does not accept streams.
+func TestStreamHandlerReturns(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		h      Handler
+		reason error
+	}{
+		{"not read", streamHandler(func(packet.Packet, io.Reader) {}), errStreamNotRead},
+		{"not accepted", requestHandler{}, errStreamsNotAccepted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialStreams(t, tc.h)
+			w, err := c.OpenStream(context.Background(), &payload{Data: []byte("meta")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkReset(t, writeUntilReset(t, w), tc.reason)
+		})
+	}
+}
+
+// TestStreamRateLimited checks that opening a stream counts against the rate limit of the packet it is opened
+// with, and that a stream exceeding it is reset.
+func TestStreamRateLimited(t *testing.T) {
+	results := make(chan streamResult, 1)
+	c := dialStreams(t, readStream(results), func(v *Vortex) {
+		v.SetRateLimits(RateLimits{Packets: map[uint32]RateLimit{(&payload{}).ID(): {Rate: 0.001, Burst: 1}}})
+	})
+
+	w, err := c.OpenStream(context.Background(), &payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; res.err != nil {
+		t.Fatalf("read stream: %v", res.err)
+	}
+
+	w, err = c.OpenStream(context.Background(), &payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReset(t, writeUntilReset(t, w), errStreamRateLimited)
+}
+
+// TestStreamDenied checks that a stream opened with a packet that the policy of the service denies is reset
+// with a permission denied reason without being passed to the StreamHandler.
+func TestStreamDenied(t *testing.T) {
+	results := make(chan streamResult, 1)
+	c := dialStreams(t, readStream(results), func(v *Vortex) {
+		if err := v.SetPolicy(&auth.Policy{Packets: []auth.PacketRule{{Type: "payload", Allow: []string{"admin"}}}}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	w, err := c.OpenStream(context.Background(), &payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReset(t, writeUntilReset(t, w), errStreamDenied)
+	select {
+	case res := <-results:
+		t.Errorf("denied stream passed to the handler: %+v", res)
+	default:
+	}
+}
@@ -0,0 +1,87 @@
+// Package trace implements propagating W3C trace context between Vortex services. The trace context of a
+// packet is carried in the frame header and made available to handlers through their context.Context.
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// SpanContext is the trace context of a span as defined by the W3C Trace Context specification.
+type SpanContext struct {
+	// TraceID is the ID of the trace that the span is part of.
+	TraceID [16]byte
+	// SpanID is the ID of the span.
+	SpanID [8]byte
+	// Flags holds the trace flags of the span, such as whether it is sampled.
+	Flags byte
+	// State holds the vendor specific tracestate of the span. It is propagated as-is.
+	State string
+}
+
+// FlagSampled is the trace flag set if the span is sampled.
+const FlagSampled byte = 0x01
+
+// Valid checks if both the trace and span ID of the SpanContext are non-zero.
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats the SpanContext as the value of a traceparent header.
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID[:], sc.SpanID[:], sc.Flags)
+}
+
+// Parse parses a SpanContext from the values of a traceparent and tracestate header. An error is returned
+// if traceparent is not valid.
+func Parse(traceParent, traceState string) (SpanContext, error) {
+	sc := SpanContext{State: traceState}
+	if len(traceParent) < 55 || traceParent[2] != '-' || traceParent[35] != '-' || traceParent[52] != '-' {
+		return sc, fmt.Errorf("invalid traceparent %q", traceParent)
+	}
+	if traceParent[:2] == "ff" || (traceParent[:2] == "00" && len(traceParent) != 55) {
+		return sc, fmt.Errorf("invalid traceparent version %q", traceParent[:2])
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceParent[3:35])); err != nil {
+		return sc, fmt.Errorf("invalid trace ID: %w", err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(traceParent[36:52])); err != nil {
+		return sc, fmt.Errorf("invalid span ID: %w", err)
+	}
+	if _, err := hex.Decode(flags[:], []byte(traceParent[53:55])); err != nil {
+		return sc, fmt.Errorf("invalid trace flags: %w", err)
+	}
+	sc.Flags = flags[0]
+	if !sc.Valid() {
+		return sc, errors.New("trace ID and span ID must not be zero")
+	}
+	return sc, nil
+}
+
+// spanContextKey is the context key that a SpanContext is stored under.
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying the SpanContext passed. Packets written with the
+// context returned carry sc in their frame header.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// FromContext returns the SpanContext carried by ctx. False is returned if ctx does not carry a valid
+// SpanContext.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.Valid()
+}
+
+// Tracer starts spans for the packets handled by a Vortex service. It may be implemented on top of an
+// OpenTelemetry tracer by converting the SpanContext of the parent and of the span started.
+type Tracer interface {
+	// Start starts a span with the name passed as a child of the SpanContext carried by ctx, if any. The
+	// context returned must carry the SpanContext of the new span, so that packets written with it during
+	// the span are part of it. end is called once the packet has been handled.
+	Start(ctx context.Context, name string) (_ context.Context, end func())
+}
@@ -0,0 +1,218 @@
+package vortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Transport carries the messages of connections between Vortex services and their peers. The framing,
+// login and handling of packets are the same for every Transport, which only moves whole messages.
+type Transport interface {
+	// Listen listens for connections on the address passed, in the format of the Transport.
+	Listen(addr string) (Listener, error)
+	// Dial dials a connection to the listener at the address passed. The context passed is only used for
+	// dialing.
+	Dial(ctx context.Context, addr string) (MessageConn, error)
+}
+
+// Listener accepts the connections of a Transport.
+type Listener interface {
+	// Accept waits for and returns the next connection. An error is returned once the Listener is closed.
+	Accept() (MessageConn, error)
+	// Close stops listening. Connections already accepted are not closed.
+	Close() error
+	// Addr returns the address that the Listener listens on.
+	Addr() net.Addr
+}
+
+// MessageConn is a message-oriented connection carried by a Transport. ReadMessage may be called
+// concurrently with the write methods, but the write methods may not be called concurrently.
+type MessageConn interface {
+	// ReadMessage reads the next message. A *CloseError is returned if the peer sent a close message.
+	ReadMessage() ([]byte, error)
+	// WriteMessage writes a message.
+	WriteMessage(msg []byte) error
+	// WriteClose writes a close message with the payload passed, which starts with a close code as a big
+	// endian uint16. The connection must still be closed using Close.
+	WriteClose(payload []byte) error
+	// SetReadLimit sets the maximum size in bytes of the messages read. Reading a larger message fails and
+	// closes the connection.
+	SetReadLimit(n int64)
+	// SetReadDeadline sets the deadline for reading messages. A zero time means no deadline.
+	SetReadDeadline(t time.Time) error
+	// RemoteAddr returns the network address of the peer.
+	RemoteAddr() net.Addr
+	// Close closes the connection without sending a close message.
+	Close() error
+}
+
+// laneConn is implemented by a MessageConn that carries messages on independent lanes, such as the streams
+// of a QUIC connection, so that a message delayed on one lane does not delay those on others. Messages on
+// the same lane are read in the order they were written. Lane 0 is the lane of WriteMessage. Unlike the
+// write methods of MessageConn, WriteMessageLane may be called concurrently with any write method.
+type laneConn interface {
+	// WriteMessageLane writes a message on a lane. If last is true, no more messages are written on the lane,
+	// which may then be released.
+	WriteMessageLane(lane uint64, msg []byte, last bool) error
+}
+
+// errListenerClosed is returned by Listener.Accept once the Listener is closed.
+var errListenerClosed = fmt.Errorf("accept: %w", net.ErrClosed)
+
+// WebsocketTransport is the Transport carrying every message in a websocket message. It is the Transport
+// used by Vortex.Start and by a Dialer without a Transport.
+type WebsocketTransport struct {
+	// Upgrader upgrades the HTTP requests accepted to websocket connections. It is not used if the Listener
+	// is served by Vortex.Serve, which upgrades the requests like Vortex.Start, using the upgrader and the
+	// checks configured by the options of the service.
+	Upgrader websocket.Upgrader
+	// Dialer dials websocket connections. If nil, websocket.DefaultDialer is used.
+	Dialer *websocket.Dialer
+	// Path is the path of the URL that connections are accepted on. If empty, "/ws" is used.
+	Path string
+}
+
+// Listen listens for HTTP requests on the TCP address passed, such as ":8080", and upgrades those made to
+// the Path of the transport to websocket connections. Requests are served from the first call to Accept
+// on.
+func (t WebsocketTransport) Listen(addr string) (Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	path := t.Path
+	if path == "" {
+		path = "/ws"
+	}
+	l := &websocketListener{ln: ln, upgrader: t.Upgrader, conns: make(chan MessageConn), closed: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, l.serveHTTP)
+	l.srv = &http.Server{Handler: mux}
+	return l, nil
+}
+
+// Dial dials the websocket URL passed, such as "ws://localhost:8080/ws".
+func (t WebsocketTransport) Dial(ctx context.Context, addr string) (MessageConn, error) {
+	dialer := t.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	conn, resp, err := dialer.DialContext(ctx, addr, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%w: %v", err, resp.Status)
+		}
+		return nil, err
+	}
+	return websocketConn{conn}, nil
+}
+
+// websocketListener is the Listener of a WebsocketTransport. The connections upgraded by its HTTP server are
+// passed to Accept, unless a handler is set using handle.
+type websocketListener struct {
+	ln       net.Listener
+	srv      *http.Server
+	upgrader websocket.Upgrader
+	// handler handles the requests instead of upgrading them using upgrader if not nil.
+	handler   atomic.Pointer[http.HandlerFunc]
+	conns     chan MessageConn
+	closed    chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// handle sets the handler that handles the requests made to the path of the listener from then on, and
+// starts serving requests.
+func (l *websocketListener) handle(h http.HandlerFunc) {
+	l.handler.Store(&h)
+	l.start()
+}
+
+// start starts serving HTTP requests if the listener was not yet started.
+func (l *websocketListener) start() {
+	l.startOnce.Do(func() {
+		go func() { _ = l.srv.Serve(l.ln) }()
+	})
+}
+
+// serveHTTP passes a request made to the path of the listener to its handler, or upgrades it and passes the
+// connection to Accept if it has none.
+func (l *websocketListener) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if h := l.handler.Load(); h != nil {
+		(*h)(w, r)
+		return
+	}
+	conn, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	select {
+	case l.conns <- websocketConn{conn}:
+	case <-l.closed:
+		_ = conn.Close()
+	}
+}
+
+// Accept ...
+func (l *websocketListener) Accept() (MessageConn, error) {
+	l.start()
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+// Close ...
+func (l *websocketListener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.srv.Close()
+		// The server only closes the listener if it was started.
+		_ = l.ln.Close()
+	})
+	return err
+}
+
+// Addr ...
+func (l *websocketListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// websocketConn is the MessageConn of a websocket connection. Messages are written as binary messages.
+type websocketConn struct {
+	*websocket.Conn
+}
+
+// ReadMessage ...
+func (c websocketConn) ReadMessage() ([]byte, error) {
+	_, msg, err := c.Conn.ReadMessage()
+	if err != nil {
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return nil, &CloseError{Code: closeErr.Code, Text: closeErr.Text}
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage ...
+func (c websocketConn) WriteMessage(msg []byte) error {
+	return c.Conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// WriteClose ...
+func (c websocketConn) WriteClose(payload []byte) error {
+	return c.Conn.WriteControl(websocket.CloseMessage, payload, time.Now().Add(time.Second))
+}
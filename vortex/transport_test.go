@@ -0,0 +1,54 @@
+package vortex
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vortex-service/vortex/vortex/auth"
+)
+
+// TestServeWebsocketTransport checks that a service serving a Listener of WebsocketTransport checks the
+// upgrade requests using its options instead of the Upgrader of the transport.
+func TestServeWebsocketTransport(t *testing.T) {
+	v := NewService("test", auth.Auth{Token: "token"},
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithRequiredHeaders(http.Header{"X-Region": {"eu"}}),
+		WithSubprotocols("vortex.v1"),
+	)
+	v.RegisterHandler(nopHandler{})
+	l, err := WebsocketTransport{}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go v.Serve(l)
+
+	url := "ws://" + l.Addr().String() + "/ws"
+	dialer := &websocket.Dialer{Subprotocols: []string{"vortex.v1"}}
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"missing header", nil, http.StatusForbidden},
+		{"wrong header", http.Header{"X-Region": {"us"}}, http.StatusForbidden},
+		{"valid", http.Header{"X-Region": {"eu"}}, http.StatusSwitchingProtocols},
+	} {
+		conn, resp, err := dialer.Dial(url, tc.header)
+		if resp == nil {
+			t.Fatalf("%v: dial: %v", tc.name, err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("%v: got status %v, want %v", tc.name, resp.StatusCode, tc.status)
+		}
+		if conn != nil {
+			if conn.Subprotocol() != "vortex.v1" {
+				t.Errorf("%v: got subprotocol %q, want %q", tc.name, conn.Subprotocol(), "vortex.v1")
+			}
+			_ = conn.Close()
+		}
+	}
+}
@@ -1,117 +1,393 @@
 package vortex
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/vortex-service/vortex/vortex/auth"
-	"github.com/vortex-service/vortex/vortex/internal"
-	"github.com/vortex-service/vortex/vortex/proto"
+	"github.com/vortex-service/vortex/vortex/metrics"
 	"github.com/vortex-service/vortex/vortex/proto/packet"
+	"github.com/vortex-service/vortex/vortex/trace"
 )
 
 type Vortex struct {
-	srv *http.Server
+	srv     *http.Server
+	log     *slog.Logger
+	metrics metrics.Collector
+	tracer  trace.Tracer
+
+	metricsAddr string
+
+	upgrader             websocket.Upgrader
+	requiredHeaders      http.Header
+	readLimit            int64
+	compression          []packet.Compression
+	compressionThreshold int
+	encryption           []packet.Encryption
+	batchDelay           time.Duration
+	batchSize            int
+	zeroCopy             bool
+	limiter              rateLimiter
+	policy               atomic.Pointer[policy]
 
 	name string
 
 	handler Handler
-	packets []packet.Packet
+	packets pool
+	// sent holds the packets registered with RegisterSentPackets.
+	sent pool
 
 	auth auth.Auth
 
-	conns   []*Conn
+	conns   map[uuid.UUID]*Conn
 	connsMu sync.Mutex
+	// admitted, ips and identities count the connections admitted in total, by remote IP and by identity
+	// for the ConnectionLimits. They are guarded by connsMu.
+	admitted   int
+	ips        map[string]int
+	identities map[string]int
+	connLimits ConnectionLimits
+	draining   atomic.Bool
 }
 
-func NewService(name string, auth auth.Auth) *Vortex {
-	return &Vortex{
-		name: name,
-		auth: auth,
+func NewService(name string, auth auth.Auth, opts ...Option) *Vortex {
+	v := &Vortex{
+		name:    name,
+		auth:    auth,
+		log:     slog.Default(),
+		metrics: metrics.Nop{},
+
+		upgrader:             upgrader,
+		compressionThreshold: DefaultCompressionThreshold,
+		packets:              newPool(&packet.Login{}),
+		sent:                 newPool(),
+		conns:                make(map[uuid.UUID]*Conn),
+		ips:                  make(map[string]int),
+		identities:           make(map[string]int),
 	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	v.log = v.log.With("service", name)
+	return v
 }
 
+// Start starts listening for websocket connections on :8080, and serving metrics on the address set using
+// WithMetricsAddr, if any. Start blocks until the server stops and returns the error that stopped it.
 func (v *Vortex) Start() error {
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Println("Error upgrading to WebSocket:", err)
-			return
-		}
-		defer conn.Close()
+	if h, ok := v.metrics.(http.Handler); ok && v.metricsAddr != "" {
+		go v.serveMetrics(h)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", v.serveWebsocket)
+	v.srv = &http.Server{Addr: ":8080", Handler: mux}
 
-		v.handle(conn)
-	})
+	v.log.Info("listening", "addr", v.srv.Addr)
+	return v.srv.ListenAndServe()
+}
 
-	log.Println("Server is listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// serveMetrics serves the metrics handler passed on /metrics at the metrics address of the service.
+func (v *Vortex) serveMetrics(h http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	v.log.Info("serving metrics", "addr", v.metricsAddr)
+	if err := http.ListenAndServe(v.metricsAddr, mux); err != nil {
+		v.log.Error("serve metrics", "addr", v.metricsAddr, "err", err)
+	}
+}
 
-	return nil
+// serveWebsocket upgrades the HTTP request to a websocket connection and handles it until it is closed.
+// The request is refused with an HTTP error if the service is draining or its ConnectionLimits are
+// reached.
+func (v *Vortex) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	if status, ok := v.checkUpgrade(r); !ok {
+		v.log.Debug("upgrade refused", "addr", r.RemoteAddr, "status", status)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if status, ok := v.admit(r.RemoteAddr); !ok {
+		v.log.Debug("connection refused", "addr", r.RemoteAddr, "status", status, "draining", v.draining.Load())
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer v.release(r.RemoteAddr)
+
+	conn, err := v.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		v.log.Debug("upgrade to websocket", "addr", r.RemoteAddr, "err", err)
+		return
+	}
+	v.serveConn(websocketConn{conn})
 }
 
-func (v *Vortex) handle(conn *websocket.Conn) {
+// ListenAndServe listens for connections on the address passed using the Transport passed, and handles
+// them until listening fails. Use Serve to handle the connections of a Listener that can be closed.
+func (v *Vortex) ListenAndServe(t Transport, addr string) error {
+	l, err := t.Listen(addr)
+	if err != nil {
+		return fmt.Errorf("listen %v: %w", addr, err)
+	}
+	defer l.Close()
+	return v.Serve(l)
+}
+
+// Serve accepts connections from the Listener passed and handles each of them in a goroutine of its own,
+// exactly like the websocket connections accepted by Start. Connections exceeding the ConnectionLimits of
+// the service, or accepted while it is draining, are closed with the close code 1013 (try again later)
+// before logging in. The HTTP requests of a Listener of WebsocketTransport are checked and upgraded like
+// those accepted by Start instead. Serve blocks until Accept fails, such as when the Listener is closed,
+// and returns the error returned by Accept.
+func (v *Vortex) Serve(l Listener) error {
+	if wl, ok := l.(*websocketListener); ok {
+		wl.handle(v.serveWebsocket)
+	}
+	v.log.Info("listening", "addr", l.Addr().String())
 	for {
-		_, msg, err := conn.ReadMessage()
-		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-			fmt.Printf("Unexpected: %v\n", err)
-			break
+		conn, err := l.Accept()
+		if err != nil {
+			return err
 		}
-
-		c := NewConn(conn)
-
-		buf := internal.BufferPool.Get().(*bytes.Buffer)
-		defer func() {
-			buf.Reset()
-			internal.BufferPool.Put(buf)
+		go func() {
+			addr := conn.RemoteAddr().String()
+			if _, ok := v.admit(addr); !ok {
+				v.log.Debug("connection refused", "addr", addr, "draining", v.draining.Load())
+				_ = conn.WriteClose(websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "connection refused"))
+				_ = conn.Close()
+				return
+			}
+			defer v.release(addr)
+			v.serveConn(conn)
 		}()
+	}
+}
+
+// serveConn handles a connection that was admitted until it is closed.
+func (v *Vortex) serveConn(conn MessageConn) {
+	if v.readLimit > 0 {
+		conn.SetReadLimit(v.readLimit)
+	}
+	c := newConn(conn, v.log, v.metrics, v.packets)
+	c.zeroCopy = v.zeroCopy
+	v.handle(c)
+}
 
-		if len(msg) < 1 {
-			return
+// checkUpgrade checks if an upgrade request carries the required headers and offers one of the
+// subprotocols of the service. If not, false is returned with the HTTP status to refuse the request with.
+// The origin of the request is checked by the upgrader.
+func (v *Vortex) checkUpgrade(r *http.Request) (int, bool) {
+	for name, values := range v.requiredHeaders {
+		got := r.Header.Values(name)
+		if len(got) == 0 || len(values) > 0 && !containsAny(got, values) {
+			return http.StatusForbidden, false
 		}
+	}
+	if len(v.upgrader.Subprotocols) > 0 && !containsAny(websocket.Subprotocols(r), v.upgrader.Subprotocols) {
+		return http.StatusBadRequest, false
+	}
+	return 0, true
+}
+
+// containsAny checks if any of the strings in a are also in b.
+func containsAny(a, b []string) bool {
+	return slices.ContainsFunc(a, func(s string) bool { return slices.Contains(b, s) })
+}
+
+// handle handles a connection for its full lifetime, calling the lifecycle methods of the Handler as the
+// connection is accepted, logs in and is closed.
+func (v *Vortex) handle(c *Conn) {
+	v.connsMu.Lock()
+	v.conns[c.ID()] = c
+	v.connsMu.Unlock()
+
+	c.log.Debug("connection accepted")
+	if h, ok := v.handler.(ConnectHandler); ok {
+		h.HandleConnect(c)
+	}
+
+	reason := v.read(c)
+	_ = c.Close()
 
-		var pk packet.Packet
-		var registeredPk bool
+	v.connsMu.Lock()
+	delete(v.conns, c.ID())
+	v.connsMu.Unlock()
 
-		if msg[0] == byte(packet.IDLogin) {
-			pk = &packet.Login{}
+	if c.LoggedIn() {
+		v.limiter.release(c.Identity())
+		v.releaseIdentity(c.Identity())
+	}
+
+	c.log.Info("connection closed", "identity", c.Identity(), "reason", reason)
+	if h, ok := v.handler.(DisconnectHandler); ok {
+		h.HandleDisconnect(c, reason)
+	}
+}
+
+// read reads and handles packets from the connection until it is closed or a packet could not be
+// decoded. The error that ended the connection is returned.
+func (v *Vortex) read(c *Conn) error {
+	for {
+		h, pk, err := c.read()
+		if err != nil {
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) && decodeErr.PacketID == packet.IDLogin && !c.LoggedIn() {
+				// Peers implementing an older protocol send a Login that cannot be decoded.
+				return v.rejectLogin(c, &packet.AuthResponse{Code: packet.AuthResponseIncompatibleProtocol})
+			}
+			return err
 		}
 
-		for _, registeredPacket := range v.packets {
-			if msg[0] == byte(registeredPacket.ID()) {
-				pk = registeredPacket
-				registeredPk = true
-				break
+		if h.flags&flagStream != 0 {
+			if err := v.acceptStream(c, h, pk); err != nil {
+				return err
+			}
+			continue
+		}
+		if login, ok := pk.(*packet.Login); ok {
+			if err := v.handleLogin(c, login); err != nil {
+				return err
 			}
+			continue
 		}
+		if !c.LoggedIn() {
+			c.log.Warn("packet received before login", "packet", pk.ID())
+			v.handleError(c, errNotLoggedIn)
+			continue
+		}
+		if h.flags&flagResponse != 0 {
+			c.respond(h.requestID, pk)
+			continue
+		}
+		if handle, err := v.limit(c, pk.ID()); err != nil {
+			return err
+		} else if handle && v.authorize(c, h, pk) {
+			v.handlePacket(c.context(h), c, pk)
+		}
+	}
+}
 
-		if pk == nil {
-			return
+// acceptStream applies the rate limits and the policy of the service to a stream opened by a connection,
+// keyed by the ID of the packet it was opened with, and accepts the stream if they allow it. A stream
+// exceeding a limit or denied by the policy is reset, and an error is returned if the connection was closed
+// because of it.
+func (v *Vortex) acceptStream(c *Conn, h header, meta packet.Packet) error {
+	if c.LoggedIn() {
+		if handle, err := v.limit(c, meta.ID()); err != nil {
+			return err
+		} else if !handle {
+			c.writeStreamReset(h.streamID, errStreamRateLimited)
+			return nil
 		}
+		if !v.allows(c, meta.ID()) {
+			c.writeStreamReset(h.streamID, errStreamDenied)
+			return nil
+		}
+	}
+	c.acceptStream(h.streamID, meta)
+	return nil
+}
 
-		reader := proto.NewReader(bytes.NewReader(msg[1:]), 1, false)
-		pk.Marshal(reader)
-
-		if registeredPk {
-			v.handler.HandlePacket(c, pk)
-		} else {
-			switch pk.ID() {
-			case packet.IDLogin:
-				pk := pk.(*packet.Login)
-				v.handleLogin(c, pk)
-			default:
-				log.Println("Received unknown packet ID:", pk.ID())
-			}
+// limit applies the rate limits of the service to a packet received by a connection and takes the action
+// of the limit exceeded, if any. limit returns true if the packet should be handled, and an error if the
+// connection was closed because the packet exceeded a limit.
+func (v *Vortex) limit(c *Conn, id uint32) (bool, error) {
+	res := v.limiter.check(c, id)
+	if res.allowed && res.delay == 0 {
+		return true, nil
+	}
+	action := RateLimitDelay
+	if !res.allowed {
+		action = res.limit.Action
+	}
+	v.metrics.RateLimited(id, action.String())
+	c.log.Debug("packet rate limited", "packet", id, "scope", res.scope, "action", action, "delay", res.delay, "retryAfter", res.retryAfter)
+
+	switch {
+	case res.allowed:
+		t := time.NewTimer(res.delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return true, nil
+		case <-c.ctx.Done():
+			return false, nil
+		}
+	case action == RateLimitThrottle && c.supports(packet.CapabilityThrottling):
+		if err := c.WritePacket(&packet.Throttle{PacketID: id, RetryAfter: res.retryAfter}, false); err != nil {
+			v.handleError(c, err)
 		}
+	case action == RateLimitDisconnect:
+		c.log.Warn("disconnecting for exceeding rate limit", "packet", id, "scope", res.scope)
+		_ = c.closeWithCode(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return false, &RateLimitError{PacketID: id, Scope: res.scope}
+	}
+	return false, nil
+}
+
+// SetRateLimits replaces the rate limits applied to the packets that connections send after logging in. It
+// may be called at any time, such as when a configuration file changes, and applies to connections that
+// are already open. The state of the limits is kept, so packets recently received still count towards
+// limits changed. By default, packets are not rate limited.
+func (v *Vortex) SetRateLimits(limits RateLimits) {
+	v.limiter.set(limits)
+}
 
+// handlePacket passes a packet to the Handler within a span started by the Tracer, if set.
+func (v *Vortex) handlePacket(ctx context.Context, c *Conn, pk packet.Packet) {
+	if v.handler == nil {
+		return
 	}
+	if v.tracer != nil {
+		var end func()
+		ctx, end = v.tracer.Start(ctx, "vortex.handle "+reflect.TypeOf(pk).Elem().Name())
+		defer end()
+	}
+
+	start := time.Now()
+	if h, ok := v.handler.(ContextHandler); ok {
+		h.HandlePacketContext(ctx, c, pk)
+	} else {
+		v.handler.HandlePacket(c, pk)
+	}
+	v.metrics.PacketHandled(pk.ID(), time.Since(start))
 }
 
+// RegisterPackets registers packets that peers may send to the service. A new packet of the same type is
+// decoded for every message received, so the values passed are only used to find the type and ID. Logins
+// of connections that send a packet with the same ID but a different packet.Schema are rejected. IDs from
+// packet.IDBuiltIn up are reserved for the packets of the protocol. RegisterPackets panics if a packet has
+// the ID of a packet built into package packet or an ID that does not fit in a byte.
 func (v *Vortex) RegisterPackets(packets ...packet.Packet) {
-	v.packets = append(v.packets, packets...)
+	v.packets.register(packets...)
+}
+
+// RegisterSentPackets registers packets that the service sends to its peers. Their packet.Schema is sent to
+// peers when they log in, and peers that receive a packet with the same ID but a different schema fail to
+// log in. Packets that are not registered may still be sent, but are not checked.
+func (v *Vortex) RegisterSentPackets(packets ...packet.Packet) {
+	v.sent.register(packets...)
+}
+
+// DescribePackets describes the packets registered with the service, including packet.Login as sent in
+// packet.CurrentProtocol, so that codecs for them can be generated in other languages. See packet.Describe
+// for the packets that may be described.
+func (v *Vortex) DescribePackets() (*packet.Description, error) {
+	packets := v.packets.packets()
+	for _, pk := range packets {
+		if l, ok := pk.(*packet.Login); ok {
+			l.Protocol = packet.CurrentProtocol
+		}
+	}
+	return packet.Describe(packets...)
 }
 
 func (v *Vortex) RegisterHandler(handler Handler) {
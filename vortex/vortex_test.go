@@ -0,0 +1,106 @@
+package vortex
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vortex-service/vortex/vortex/auth"
+	"github.com/vortex-service/vortex/vortex/proto"
+)
+
+// payload is a packet holding an arbitrary payload, used by the tests and benchmarks of the package.
+type payload struct {
+	Data []byte
+}
+
+// ID ...
+func (p *payload) ID() uint32 {
+	return 100
+}
+
+// Marshal ...
+func (p *payload) Marshal(io proto.IO) {
+	io.ByteSlice(&p.Data)
+}
+
+// testLogger returns a logger that discards everything logged.
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// serveTest serves the websocket connections of the service passed on loopback until the test ends, and
+// returns the URL to dial them at.
+func serveTest(t *testing.T, v *Vortex) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(v.serveWebsocket))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// TestCheckUpgrade checks that upgrade requests missing a required header or value are refused with 403
+// Forbidden, and requests not offering a subprotocol of the service with 400 Bad Request.
+func TestCheckUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		headers http.Header
+		status  int
+		ok      bool
+	}{
+		{"no requirements", nil, nil, 0, true},
+		{"header with any value", []Option{WithRequiredHeaders(http.Header{"X-Region": nil})}, http.Header{"X-Region": {"eu"}}, 0, true},
+		{"header missing", []Option{WithRequiredHeaders(http.Header{"X-Region": nil})}, nil, http.StatusForbidden, false},
+		{"header value", []Option{WithRequiredHeaders(http.Header{"X-Region": {"eu", "us"}})}, http.Header{"X-Region": {"us"}}, 0, true},
+		{"header value among several", []Option{WithRequiredHeaders(http.Header{"X-Region": {"eu"}})}, http.Header{"X-Region": {"ap", "eu"}}, 0, true},
+		{"header other value", []Option{WithRequiredHeaders(http.Header{"X-Region": {"eu"}})}, http.Header{"X-Region": {"ap"}}, http.StatusForbidden, false},
+		{"subprotocol", []Option{WithSubprotocols("vortex.v2", "vortex.v1")}, http.Header{"Sec-Websocket-Protocol": {"vortex.v0, vortex.v1"}}, 0, true},
+		{"subprotocol missing", []Option{WithSubprotocols("vortex.v1")}, nil, http.StatusBadRequest, false},
+		{"other subprotocol", []Option{WithSubprotocols("vortex.v1")}, http.Header{"Sec-Websocket-Protocol": {"vortex.v0"}}, http.StatusBadRequest, false},
+		{"header before subprotocol", []Option{WithRequiredHeaders(http.Header{"X-Region": nil}), WithSubprotocols("vortex.v1")}, nil, http.StatusForbidden, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewService("test", auth.Auth{}, append([]Option{WithLogger(testLogger())}, tc.opts...)...)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, values := range tc.headers {
+				r.Header[name] = values
+			}
+			if status, ok := v.checkUpgrade(r); status != tc.status || ok != tc.ok {
+				t.Errorf("got status %v and %v, want status %v and %v", status, ok, tc.status, tc.ok)
+			}
+		})
+	}
+}
+
+// TestAllowedOrigins checks that the Origin of upgrade requests must match one of the origins allowed,
+// case-insensitively, unless "*" is allowed or the request has no Origin.
+func TestAllowedOrigins(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{"no origin", []string{"https://example.com"}, "", true},
+		{"allowed", []string{"https://a.com", "https://example.com"}, "https://example.com", true},
+		{"case", []string{"https://example.com"}, "https://EXAMPLE.com", true},
+		{"other origin", []string{"https://example.com"}, "https://evil.com", false},
+		{"other scheme", []string{"https://example.com"}, "http://example.com", false},
+		{"wildcard", []string{"*"}, "https://evil.com", true},
+		{"none allowed", nil, "https://example.com", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewService("test", auth.Auth{}, WithLogger(testLogger()), WithAllowedOrigins(tc.allowed...))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if ok := v.upgrader.CheckOrigin(r); ok != tc.ok {
+				t.Errorf("origin %q allowed: got %v, want %v", tc.origin, ok, tc.ok)
+			}
+		})
+	}
+}